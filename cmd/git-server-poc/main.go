@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/npclaudiu/git-server-poc/internal/config"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
 	"github.com/npclaudiu/git-server-poc/internal/metastore"
 	"github.com/npclaudiu/git-server-poc/internal/objectstore"
 	"github.com/npclaudiu/git-server-poc/internal/server"
@@ -46,11 +48,27 @@ func main() {
 	defer metaStore.Close()
 
 	objStore, err := objectstore.New(ctx, objectstore.Options{
-		Endpoint:  cfg.ObjectStore.Endpoint,
-		AccessKey: cfg.ObjectStore.AccessKeyID,
-		SecretKey: cfg.ObjectStore.SecretAccessKey,
-		Bucket:    cfg.ObjectStore.Bucket,
-		Region:    cfg.ObjectStore.Region,
+		Endpoint:          cfg.ObjectStore.Endpoint,
+		AccessKey:         cfg.ObjectStore.AccessKeyID,
+		SecretKey:         cfg.ObjectStore.SecretAccessKey,
+		Bucket:            cfg.ObjectStore.Bucket,
+		Region:            cfg.ObjectStore.Region,
+		UploadConcurrency: cfg.ObjectStore.UploadConcurrency,
+		Retry: objectstore.RetryOptions{
+			MaxAttempts:    cfg.ObjectStore.Retry.MaxAttempts,
+			InitialBackoff: cfg.ObjectStore.Retry.InitialBackoff,
+			MaxBackoff:     cfg.ObjectStore.Retry.MaxBackoff,
+		},
+		Timeouts: objectstore.TimeoutOptions{
+			Head: cfg.ObjectStore.Timeouts.Head,
+			Get:  cfg.ObjectStore.Timeouts.Get,
+			Put:  cfg.ObjectStore.Timeouts.Put,
+			List: cfg.ObjectStore.Timeouts.List,
+		},
+		CircuitBreaker: objectstore.CircuitBreakerOptions{
+			FailureThreshold: cfg.ObjectStore.CircuitBreaker.FailureThreshold,
+			Cooldown:         cfg.ObjectStore.CircuitBreaker.Cooldown,
+		},
 	})
 	if err != nil {
 		slog.Error("failed to create object store", "err", err)
@@ -62,6 +80,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// `git-server-poc backfill [repository...]` runs a one-off maintenance task
+	// instead of serving requests.
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfill(ctx, metaStore, objStore, os.Args[2:]); err != nil {
+			slog.Error("failed to backfill repositories", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	srv := server.New(cfg, metaStore, objStore)
 
 	if err := srv.Run(); err != nil {
@@ -78,3 +106,27 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runBackfill records object type and size metadata and object index entries
+// for the given repositories, or for every repository if none are given.
+func runBackfill(ctx context.Context, ms *metastore.MetaStore, objStore *objectstore.ObjectStore, names []string) error {
+	if len(names) == 0 {
+		repos, err := ms.ListRepositories(ctx)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			names = append(names, repo.Name)
+		}
+	}
+
+	for _, name := range names {
+		n, err := storage.BackfillObjects(ctx, objStore, ms, name)
+		if err != nil {
+			return fmt.Errorf("repository %s: %w", name, err)
+		}
+		slog.Info("backfilled objects", "repo", name, "objects", n)
+	}
+
+	return nil
+}
@@ -2,15 +2,23 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Server struct {
-		Host string `yaml:"host"`
-		Port string `yaml:"port"`
+		Host            string        `yaml:"host"`
+		Port            string        `yaml:"port"`
+		PushLockTimeout time.Duration `yaml:"push_lock_timeout"`
+		DefaultBranch   string        `yaml:"default_branch"`
+		LocalRemoteRoot string        `yaml:"local_remote_root"`
 	} `yaml:"server"`
+	Mirrors struct {
+		Interval     time.Duration `yaml:"interval"`
+		PollInterval time.Duration `yaml:"poll_interval"`
+	} `yaml:"mirrors"`
 	Log struct {
 		Level string `yaml:"level"`
 	} `yaml:"log"`
@@ -23,11 +31,27 @@ type Config struct {
 		SSLMode  string `yaml:"sslmode"`
 	} `yaml:"meta_store"`
 	ObjectStore struct {
-		Endpoint        string `yaml:"endpoint"`
-		AccessKeyID     string `yaml:"access_key"`
-		SecretAccessKey string `yaml:"secret_key"`
-		Bucket          string `yaml:"bucket"`
-		Region          string `yaml:"region"`
+		Endpoint          string `yaml:"endpoint"`
+		AccessKeyID       string `yaml:"access_key"`
+		SecretAccessKey   string `yaml:"secret_key"`
+		Bucket            string `yaml:"bucket"`
+		Region            string `yaml:"region"`
+		UploadConcurrency int    `yaml:"upload_concurrency"`
+		Retry             struct {
+			MaxAttempts    int           `yaml:"max_attempts"`
+			InitialBackoff time.Duration `yaml:"initial_backoff"`
+			MaxBackoff     time.Duration `yaml:"max_backoff"`
+		} `yaml:"retry"`
+		Timeouts struct {
+			Head time.Duration `yaml:"head"`
+			Get  time.Duration `yaml:"get"`
+			Put  time.Duration `yaml:"put"`
+			List time.Duration `yaml:"list"`
+		} `yaml:"timeouts"`
+		CircuitBreaker struct {
+			FailureThreshold int           `yaml:"failure_threshold"`
+			Cooldown         time.Duration `yaml:"cooldown"`
+		} `yaml:"circuit_breaker"`
 	} `yaml:"object_store"`
 }
 
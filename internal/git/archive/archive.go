@@ -0,0 +1,287 @@
+// Package archive writes the tree of a commit as a tar, tar.gz or zip archive,
+// like git archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+type Format string
+
+const (
+	Tar   Format = "tar"
+	TarGz Format = "tar.gz"
+	Zip   Format = "zip"
+)
+
+// formats is ordered so that longer extensions are matched first.
+var formats = []Format{TarGz, Tar, Zip}
+
+// ParseName splits an archive name such as "v1.0.tar.gz" into the revision
+// and the format.
+func ParseName(name string) (rev string, format Format, err error) {
+	for _, f := range formats {
+		if rev, ok := strings.CutSuffix(name, "."+string(f)); ok && rev != "" {
+			return rev, f, nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported archive format: %q", name)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case TarGz:
+		return "application/gzip"
+	case Zip:
+		return "application/zip"
+	default:
+		return "application/x-tar"
+	}
+}
+
+// Write streams the tree of c to w. Every path is prepended with prefix, which
+// usually ends with a slash. Files are dated with the commit time and the
+// commit hash is recorded in the archive comment.
+func Write(w io.Writer, st storer.EncodedObjectStorer, c *object.Commit, format Format, prefix string) error {
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+
+	var aw entryWriter
+	switch format {
+	case Tar, TarGz:
+		aw, err = newTarWriter(w, format == TarGz, c)
+	case Zip:
+		aw, err = newZipWriter(w, c)
+	default:
+		err = fmt.Errorf("unsupported archive format: %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(prefix, "/") {
+		if err := aw.dir(prefix); err != nil {
+			return err
+		}
+	}
+
+	if err := walk(st, tree, prefix, aw); err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+func walk(st storer.EncodedObjectStorer, tree *object.Tree, prefix string, aw entryWriter) error {
+	for _, e := range tree.Entries {
+		path := prefix + e.Name
+
+		switch e.Mode {
+		case filemode.Dir:
+			if err := aw.dir(path + "/"); err != nil {
+				return err
+			}
+			sub, err := object.GetTree(st, e.Hash)
+			if err != nil {
+				return err
+			}
+			if err := walk(st, sub, path+"/", aw); err != nil {
+				return err
+			}
+		case filemode.Submodule:
+			// Submodules live in other repositories and are archived as
+			// empty directories.
+			if err := aw.dir(path + "/"); err != nil {
+				return err
+			}
+		case filemode.Symlink:
+			target, err := readBlob(st, e)
+			if err != nil {
+				return err
+			}
+			if err := aw.symlink(path, target); err != nil {
+				return err
+			}
+		default:
+			if err := writeFile(st, e, path, aw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readBlob(st storer.EncodedObjectStorer, e object.TreeEntry) (string, error) {
+	blob, err := object.GetBlob(st, e.Hash)
+	if err != nil {
+		return "", err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func writeFile(st storer.EncodedObjectStorer, e object.TreeEntry, path string, aw entryWriter) error {
+	blob, err := object.GetBlob(st, e.Hash)
+	if err != nil {
+		return err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return aw.file(path, e.Mode == filemode.Executable, blob.Size, r)
+}
+
+// entryWriter writes the entries of an archive. Directory paths end with a
+// slash.
+type entryWriter interface {
+	dir(path string) error
+	file(path string, executable bool, size int64, r io.Reader) error
+	symlink(path, target string) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw    *tar.Writer
+	gz    *gzip.Writer
+	mtime time.Time
+}
+
+func newTarWriter(w io.Writer, compress bool, c *object.Commit) (*tarWriter, error) {
+	t := &tarWriter{mtime: c.Committer.When}
+	if compress {
+		t.gz = gzip.NewWriter(w)
+		w = t.gz
+	}
+	t.tw = tar.NewWriter(w)
+
+	// Like git archive, record the commit in a pax global header, from which
+	// git get-tar-commit-id reads it.
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       "pax_global_header",
+		PAXRecords: map[string]string{"comment": c.Hash.String()},
+		Format:     tar.FormatPAX,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *tarWriter) header(typ byte, path string, mode int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: typ,
+		Name:     path,
+		Mode:     mode,
+		ModTime:  t.mtime,
+		Uname:    "root",
+		Gname:    "root",
+	}
+}
+
+func (t *tarWriter) dir(path string) error {
+	return t.tw.WriteHeader(t.header(tar.TypeDir, path, 0o755))
+}
+
+func (t *tarWriter) file(path string, executable bool, size int64, r io.Reader) error {
+	h := t.header(tar.TypeReg, path, 0o644)
+	if executable {
+		h.Mode = 0o755
+	}
+	h.Size = size
+	if err := t.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarWriter) symlink(path, target string) error {
+	h := t.header(tar.TypeSymlink, path, 0o777)
+	h.Linkname = target
+	return t.tw.WriteHeader(h)
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw    *zip.Writer
+	mtime time.Time
+}
+
+func newZipWriter(w io.Writer, c *object.Commit) (*zipWriter, error) {
+	z := &zipWriter{zw: zip.NewWriter(w), mtime: c.Committer.When}
+	if err := z.zw.SetComment(c.Hash.String()); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *zipWriter) create(path string, mode os.FileMode, method uint16) (io.Writer, error) {
+	h := &zip.FileHeader{Name: path, Method: method, Modified: z.mtime}
+	h.SetMode(mode)
+	return z.zw.CreateHeader(h)
+}
+
+func (z *zipWriter) dir(path string) error {
+	_, err := z.create(path, os.ModeDir|0o755, zip.Store)
+	return err
+}
+
+func (z *zipWriter) file(path string, executable bool, _ int64, r io.Reader) error {
+	mode := os.FileMode(0o644)
+	if executable {
+		mode = 0o755
+	}
+	fw, err := z.create(path, mode, zip.Deflate)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (z *zipWriter) symlink(path, target string) error {
+	fw, err := z.create(path, os.ModeSymlink|0o777, zip.Store)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(fw, target)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,178 @@
+// Package bundle reads and writes Git bundles (v2 and v3): a header listing
+// references and prerequisite commits, followed by a packfile.
+package bundle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	signatureV2 = "# v2 git bundle"
+	signatureV3 = "# v3 git bundle"
+
+	// objectFormat is the only object format supported by go-git.
+	objectFormat = "sha1"
+)
+
+var (
+	ErrInvalidSignature  = errors.New("not a v2 or v3 git bundle")
+	ErrUnsupportedFormat = errors.New("unsupported object format")
+	ErrEmpty             = errors.New("refusing to create an empty bundle")
+)
+
+// Header is the part of a bundle that precedes the packfile.
+type Header struct {
+	Version int
+	// Capabilities are only written by v3 bundles.
+	Capabilities map[string]string
+	// Prerequisites are commits the packfile depends on but does not
+	// contain.
+	Prerequisites []plumbing.Hash
+	References    []*plumbing.Reference
+}
+
+// ReadHeader reads the header of a bundle. r is left positioned at the start
+// of the packfile.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{Capabilities: make(map[string]string)}
+	switch line {
+	case signatureV2:
+		h.Version = 2
+	case signatureV3:
+		h.Version = 3
+	default:
+		return nil, ErrInvalidSignature
+	}
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case line == "":
+			return h, nil
+		case h.Version == 3 && strings.HasPrefix(line, "@"):
+			key, value, _ := strings.Cut(line[1:], "=")
+			if key == "object-format" && value != objectFormat {
+				return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, value)
+			}
+			h.Capabilities[key] = value
+		case strings.HasPrefix(line, "-"):
+			hash, _, _ := strings.Cut(line[1:], " ")
+			if !plumbing.IsHash(hash) {
+				return nil, fmt.Errorf("invalid prerequisite: %q", line)
+			}
+			h.Prerequisites = append(h.Prerequisites, plumbing.NewHash(hash))
+		default:
+			hash, name, ok := strings.Cut(line, " ")
+			if !ok || !plumbing.IsHash(hash) {
+				return nil, fmt.Errorf("invalid reference: %q", line)
+			}
+			h.References = append(h.References, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// Encode writes the header, including the blank line that ends it.
+func (h *Header) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	switch h.Version {
+	case 2:
+		fmt.Fprintln(bw, signatureV2)
+	case 3:
+		fmt.Fprintln(bw, signatureV3)
+		keys := make([]string, 0, len(h.Capabilities))
+		for k := range h.Capabilities {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(bw, "@%s=%s\n", k, h.Capabilities[k])
+		}
+	default:
+		return fmt.Errorf("unsupported bundle version %d", h.Version)
+	}
+
+	for _, p := range h.Prerequisites {
+		fmt.Fprintf(bw, "-%s\n", p)
+	}
+	for _, ref := range h.References {
+		fmt.Fprintf(bw, "%s %s\n", ref.Hash(), ref.Name())
+	}
+	fmt.Fprintln(bw)
+
+	return bw.Flush()
+}
+
+// Write writes a bundle of version 2 or 3 containing refs and every object
+// reachable from them that is not reachable from prerequisites, which the
+// recipient must already have.
+func Write(w io.Writer, st storer.EncodedObjectStorer, version int, refs []*plumbing.Reference, prerequisites []plumbing.Hash) error {
+	if len(refs) == 0 {
+		return ErrEmpty
+	}
+
+	h := &Header{
+		Version:       version,
+		Prerequisites: prerequisites,
+		References:    refs,
+	}
+	if version == 3 {
+		h.Capabilities = map[string]string{"object-format": objectFormat}
+	}
+
+	var tips []plumbing.Hash
+	for _, ref := range refs {
+		tips = append(tips, ref.Hash())
+	}
+
+	var ignore []plumbing.Hash
+	if len(prerequisites) > 0 {
+		var err error
+		ignore, err = revlist.Objects(st, prerequisites, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	hashes, err := revlist.Objects(st, tips, ignore)
+	if err != nil {
+		return err
+	}
+
+	if err := h.Encode(w); err != nil {
+		return err
+	}
+
+	_, err = packfile.NewEncoder(w, st, false).Encode(hashes, 10)
+	return err
+}
@@ -0,0 +1,128 @@
+// Package merge implements the three-way merge of text files, like diff3:
+// regions changed on one side only are taken from that side, and regions
+// changed differently on both sides are conflicts.
+package merge
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Hunk is a region of a file. Start is the first line, numbered from 1, and
+// Lines the number of lines. An empty region starts at the line following
+// it.
+type Hunk struct {
+	Start int `json:"start"`
+	Lines int `json:"lines"`
+}
+
+// Conflict is a region of the base changed differently by both sides.
+type Conflict struct {
+	Base   Hunk `json:"base"`
+	Ours   Hunk `json:"ours"`
+	Theirs Hunk `json:"theirs"`
+}
+
+// Text merges the changes from base to theirs into ours. The merged content
+// is only meaningful if there are no conflicts.
+func Text(base, ours, theirs []byte) ([]byte, []Conflict) {
+	o := splitLines(string(base))
+	a := splitLines(string(ours))
+	b := splitLines(string(theirs))
+	ma := matches(string(base), string(ours), len(o))
+	mb := matches(string(base), string(theirs), len(o))
+
+	var out bytes.Buffer
+	var conflicts []Conflict
+
+	// i, j and k are the positions in the base, ours and theirs.
+	i, j, k := 0, 0, 0
+	for {
+		// Copy the lines unchanged on both sides.
+		n := 0
+		for i+n < len(o) && ma[i+n] == j+n && mb[i+n] == k+n {
+			out.WriteString(o[i+n])
+			n++
+		}
+		if n > 0 {
+			i, j, k = i+n, j+n, k+n
+			continue
+		}
+
+		// The changed region ends at the next line kept by both sides.
+		next := i
+		for next < len(o) && (ma[next] < 0 || mb[next] < 0) {
+			next++
+		}
+		endA, endB := len(a), len(b)
+		if next < len(o) {
+			endA, endB = ma[next], mb[next]
+		}
+		if next == i && endA == j && endB == k {
+			break
+		}
+
+		baseLines, ourLines, theirLines := o[i:next], a[j:endA], b[k:endB]
+		switch {
+		case slices.Equal(baseLines, ourLines):
+			write(&out, theirLines)
+		case slices.Equal(baseLines, theirLines), slices.Equal(ourLines, theirLines):
+			write(&out, ourLines)
+		default:
+			conflicts = append(conflicts, Conflict{
+				Base:   Hunk{Start: i + 1, Lines: next - i},
+				Ours:   Hunk{Start: j + 1, Lines: endA - j},
+				Theirs: Hunk{Start: k + 1, Lines: endB - k},
+			})
+			write(&out, ourLines)
+		}
+		i, j, k = next, endA, endB
+	}
+
+	return out.Bytes(), conflicts
+}
+
+// matches returns, for each of the n lines of from, the index of the same
+// line in to, or -1 if it was removed.
+func matches(from, to string, n int) []int {
+	m := make([]int, n)
+	i, j := 0, 0
+	for _, d := range diff.Do(from, to) {
+		lines := len(splitLines(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for l := 0; l < lines; l++ {
+				m[i+l] = j + l
+			}
+			i += lines
+			j += lines
+		case diffmatchpatch.DiffDelete:
+			for l := 0; l < lines; l++ {
+				m[i+l] = -1
+			}
+			i += lines
+		case diffmatchpatch.DiffInsert:
+			j += lines
+		}
+	}
+	return m
+}
+
+// splitLines splits s after each newline. The last line may lack one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func write(w *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		w.WriteString(l)
+	}
+}
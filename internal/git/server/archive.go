@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/npclaudiu/git-server-poc/internal/git/archive"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// Archive handles GET /repositories/:id/archive/:rev.:format. The "prefix"
+// query parameter is prepended to every path in the archive.
+func (h *GitHandler) Archive(w http.ResponseWriter, r *http.Request, repoName, rev string, format archive.Format) {
+	prefix := r.URL.Query().Get("prefix")
+	if path.IsAbs(prefix) || strings.Contains("/"+prefix+"/", "/../") {
+		http.Error(w, "invalid prefix", http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	c, err := resolveRevision(st, rev)
+	if errors.Is(err, errUnknownRevision) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to resolve revision", "repo", repoName, "rev", rev, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s.%s", repoName, strings.ReplaceAll(rev, "/", "-"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	if err := archive.Write(w, st, c, format, prefix); err != nil {
+		slog.Error("failed to write archive", "repo", repoName, "rev", rev, "err", err)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// Blame is the blame of a file at a commit.
+type Blame struct {
+	Commit string       `json:"commit"`
+	Path   string       `json:"path"`
+	Ranges []BlameRange `json:"ranges"`
+}
+
+// BlameRange is a run of consecutive lines, numbered from 1, that were last
+// changed by the same commit.
+type BlameRange struct {
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Commit    string    `json:"commit"`
+	Author    Signature `json:"author"`
+	Lines     []string  `json:"lines"`
+}
+
+// Blame handles GET /repositories/:id/blame/:rev/:path, attributing each line
+// of the file at path to the commit that last changed it.
+func (h *GitHandler) Blame(w http.ResponseWriter, r *http.Request, repoName, revPath string) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	c, _, p, err := resolveRevisionPath(st, revPath)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	if p == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	e, err := findEntry(tree, p)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	if entryType(e.Mode) != "blob" {
+		http.Error(w, fmt.Sprintf("not a file: %s", p), http.StatusBadRequest)
+		return
+	}
+
+	res, err := git.Blame(c, p)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+
+	blame := Blame{Commit: c.Hash.String(), Path: p, Ranges: []BlameRange{}}
+	for i, line := range res.Lines {
+		n := len(blame.Ranges)
+		if n > 0 && blame.Ranges[n-1].Commit == line.Hash.String() {
+			blame.Ranges[n-1].EndLine = i + 1
+			blame.Ranges[n-1].Lines = append(blame.Ranges[n-1].Lines, line.Text)
+			continue
+		}
+		blame.Ranges = append(blame.Ranges, BlameRange{
+			StartLine: i + 1,
+			EndLine:   i + 1,
+			Commit:    line.Hash.String(),
+			Author:    Signature{Name: line.AuthorName, Email: line.Author, Date: line.Date},
+			Lines:     []string{line.Text},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blame)
+}
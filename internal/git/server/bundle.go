@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/bundle"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// bundleImportResponse reports the outcome of a bundle import.
+type bundleImportResponse struct {
+	Unpack     string            `json:"unpack"`
+	References []refUpdateStatus `json:"references"`
+}
+
+// refUpdateStatus is the outcome of updating a single reference.
+type refUpdateStatus struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash"`
+	Status string `json:"status"`
+}
+
+// Bundle handles GET /repositories/:id/bundle. The "ref" query parameters
+// select the references to bundle (all of them by default), the "basis" ones
+// name commits the recipient already has, and "version" is 2 (the default)
+// or 3.
+func (h *GitHandler) Bundle(w http.ResponseWriter, r *http.Request, repoName string) {
+	q := r.URL.Query()
+
+	version := 2
+	switch q.Get("version") {
+	case "", "2":
+	case "3":
+		version = 3
+	default:
+		http.Error(w, "version must be 2 or 3", http.StatusBadRequest)
+		return
+	}
+
+	var basis []plumbing.Hash
+	for _, b := range q["basis"] {
+		if !plumbing.IsHash(b) {
+			http.Error(w, fmt.Sprintf("invalid basis: %q", b), http.StatusBadRequest)
+			return
+		}
+		basis = append(basis, plumbing.NewHash(b))
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	refs, err := bundleReferences(st, q["ref"])
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to list references", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(refs) == 0 {
+		http.Error(w, bundle.ErrEmpty.Error(), http.StatusNotFound)
+		return
+	}
+
+	for _, b := range basis {
+		if err := st.HasEncodedObject(b); err != nil {
+			http.Error(w, fmt.Sprintf("unknown basis: %s", b), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-bundle")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", repoName+".bundle"))
+
+	if err := bundle.Write(w, st, version, refs, basis); err != nil {
+		slog.Error("failed to write bundle", "repo", repoName, "err", err)
+	}
+}
+
+// bundleReferences resolves the requested references, which may be
+// abbreviated like on the git command line. With no names, every branch and
+// tag is returned, along with HEAD if it points to one of them.
+func bundleReferences(st storer.ReferenceStorer, names []string) ([]*plumbing.Reference, error) {
+	var refs []*plumbing.Reference
+
+	if len(names) > 0 {
+		for _, name := range names {
+			ref, err := resolveShortReference(st, name)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, ref)
+		}
+		return refs, nil
+	}
+
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(ref.Name().String(), "refs/") {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if head, err := storer.ResolveReference(st, plumbing.HEAD); err == nil {
+		refs = append(refs, plumbing.NewHashReference(plumbing.HEAD, head.Hash()))
+	}
+
+	return refs, nil
+}
+
+// ImportBundle handles POST /repositories/:id/bundle. The objects of the
+// bundle are stored and its references are created; existing references that
+// point elsewhere are only moved when the "force" query parameter is true.
+func (h *GitHandler) ImportBundle(w http.ResponseWriter, r *http.Request, repoName string) {
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	br := bufio.NewReader(body)
+	hdr, err := bundle.ReadHeader(br)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := hdr.Capabilities["filter"]; ok {
+		http.Error(w, "partial bundles are not supported", http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	for _, p := range hdr.Prerequisites {
+		if err := st.HasEncodedObject(p); err != nil {
+			http.Error(w, fmt.Sprintf("missing prerequisite: %s", p), http.StatusBadRequest)
+			return
+		}
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+
+	resp := &bundleImportResponse{}
+	req := packp.NewReferenceUpdateRequest()
+	for _, ref := range hdr.References {
+		name := ref.Name()
+		if name == plumbing.HEAD {
+			continue
+		}
+		if !strings.HasPrefix(name.String(), "refs/") {
+			http.Error(w, fmt.Sprintf("invalid reference name: %s", name), http.StatusBadRequest)
+			return
+		}
+
+		cmd := &packp.Command{Name: name, New: ref.Hash()}
+		current, err := st.Reference(name)
+		switch {
+		case err == plumbing.ErrReferenceNotFound:
+		case err != nil:
+			slog.Error("failed to read reference", "repo", repoName, "ref", name, "err", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		case current.Hash() == ref.Hash():
+			resp.References = append(resp.References, refUpdateStatus{
+				Name:   name.String(),
+				Hash:   ref.Hash().String(),
+				Status: "ok",
+			})
+			continue
+		case force:
+			cmd.Old = current.Hash()
+		}
+		req.Commands = append(req.Commands, cmd)
+	}
+
+	rs := h.receivePack(r.Context(), st, repoName, req, br)
+
+	resp.Unpack = rs.UnpackStatus
+	status := http.StatusOK
+	if rs.UnpackStatus != "ok" {
+		status = http.StatusBadRequest
+	}
+	for i, cs := range rs.CommandStatuses {
+		resp.References = append(resp.References, refUpdateStatus{
+			Name:   cs.ReferenceName.String(),
+			Hash:   req.Commands[i].New.String(),
+			Status: cs.Status,
+		})
+		if cs.Status != "ok" && status == http.StatusOK {
+			status = http.StatusConflict
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// Commit is the metadata of a commit.
+type Commit struct {
+	Hash      string    `json:"hash"`
+	Parents   []string  `json:"parents"`
+	Author    Signature `json:"author"`
+	Committer Signature `json:"committer"`
+	Message   string    `json:"message"`
+	// Signed reports whether the commit carries a signature. It is not
+	// verified.
+	Signed bool `json:"signed"`
+}
+
+type Signature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+func newCommit(c *object.Commit) Commit {
+	parents := make([]string, len(c.ParentHashes))
+	for i, p := range c.ParentHashes {
+		parents[i] = p.String()
+	}
+	return Commit{
+		Hash:      c.Hash.String(),
+		Parents:   parents,
+		Author:    newSignature(c.Author),
+		Committer: newSignature(c.Committer),
+		Message:   c.Message,
+		Signed:    c.PGPSignature != "",
+	}
+}
+
+func newSignature(s object.Signature) Signature {
+	return Signature{Name: s.Name, Email: s.Email, Date: s.When}
+}
+
+// Commits handles GET /repositories/:id/commits, listing the commits reachable
+// from the "ref" query parameter (HEAD by default), newest first. They can be
+// filtered by "path", by committer date with "since" and "until", and by
+// author with "author", a regular expression matched against "Name <email>".
+// Results are paginated with "page" and "per_page".
+func (h *GitHandler) Commits(w http.ResponseWriter, r *http.Request, repoName string) {
+	q := r.URL.Query()
+
+	f, err := parseLogFilter(q.Get("path"), q.Get("since"), q.Get("until"), q.Get("author"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rev := q.Get("ref")
+	if rev == "" {
+		rev = plumbing.HEAD.String()
+	}
+
+	// The history walk reads commits and, to filter by path, trees only.
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	st.PrefetchBlobs(false)
+	defer st.Close()
+
+	c, err := resolveRevision(st, rev)
+	if errors.Is(err, errUnknownRevision) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to resolve revision", "repo", repoName, "rev", rev, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// One more commit than needed tells whether there is a next page.
+	skip := (page - 1) * perPage
+	commits := make([]Commit, 0, perPage)
+	more := false
+	err = walkLog(st, c, f, func(c *object.Commit) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if len(commits) == perPage {
+			more = true
+			return false
+		}
+		commits = append(commits, newCommit(c))
+		return true
+	})
+	if err != nil {
+		slog.Error("failed to walk history", "repo", repoName, "rev", rev, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if more {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commits)
+}
+
+func parsePage(pageParam, perPageParam string) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if pageParam != "" {
+		if page, err = strconv.Atoi(pageParam); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page: %q", pageParam)
+		}
+	}
+	if perPageParam != "" {
+		if perPage, err = strconv.Atoi(perPageParam); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return page, perPage, nil
+}
+
+// logFilter selects the commits listed by walkLog.
+type logFilter struct {
+	path   string
+	since  time.Time
+	until  time.Time
+	author *regexp.Regexp
+}
+
+func parseLogFilter(path, since, until, author string) (*logFilter, error) {
+	f := &logFilter{path: strings.Trim(path, "/")}
+
+	var err error
+	if f.since, err = parseDate(since); err != nil {
+		return nil, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.until, err = parseDate(until); err != nil {
+		return nil, fmt.Errorf("invalid until: %w", err)
+	}
+	if author != "" {
+		if f.author, err = regexp.Compile("(?i)" + author); err != nil {
+			return nil, fmt.Errorf("invalid author: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// parseDate parses an RFC 3339 date, a calendar date or a Unix timestamp.
+// An empty string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+func (f *logFilter) matches(c *object.Commit) bool {
+	when := c.Committer.When
+	if !f.until.IsZero() && when.After(f.until) {
+		return false
+	}
+	if f.author != nil && !f.author.MatchString(fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email)) {
+		return false
+	}
+	return true
+}
+
+// walkLog calls fn with the commits reachable from start that pass f, newest
+// first, until fn returns false.
+//
+// With a path, history is simplified like git log does by default: commits
+// that do not change the path are skipped, and only one parent whose version
+// of the path is identical is followed from a merge, so side branches that did
+// not contribute to the path are not walked.
+func walkLog(st storer.EncodedObjectStorer, start *object.Commit, f *logFilter, fn func(*object.Commit) bool) error {
+	w := &logWalker{st: st, path: f.path, pathHashes: make(map[plumbing.Hash]plumbing.Hash)}
+	seen := map[plumbing.Hash]bool{start.Hash: true}
+	queue := &commitQueue{start}
+
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(*object.Commit)
+		// The queue is ordered by committer date, so the remaining commits are
+		// all older, clock skew aside.
+		if !f.since.IsZero() && c.Committer.When.Before(f.since) {
+			break
+		}
+
+		show, parents, err := w.simplify(c)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range parents {
+			if seen[p.Hash] {
+				continue
+			}
+			seen[p.Hash] = true
+			heap.Push(queue, p)
+		}
+
+		if show && f.matches(c) && !fn(c) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+type logWalker struct {
+	st   storer.EncodedObjectStorer
+	path string
+	// pathHashes caches the hash of path in a commit, the zero hash if it is
+	// absent.
+	pathHashes map[plumbing.Hash]plumbing.Hash
+}
+
+// simplify reports whether c changes the path and returns the parents to
+// follow.
+func (w *logWalker) simplify(c *object.Commit) (bool, []*object.Commit, error) {
+	parents := make([]*object.Commit, 0, len(c.ParentHashes))
+	for _, p := range c.ParentHashes {
+		pc, err := object.GetCommit(w.st, p)
+		if err == plumbing.ErrObjectNotFound {
+			// The history of a shallow push may be incomplete.
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		parents = append(parents, pc)
+	}
+
+	if w.path == "" {
+		return true, parents, nil
+	}
+
+	h, err := w.pathHash(c)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(parents) == 0 {
+		return !h.IsZero(), nil, nil
+	}
+
+	for _, p := range parents {
+		ph, err := w.pathHash(p)
+		if err != nil {
+			return false, nil, err
+		}
+		if ph == h {
+			return false, []*object.Commit{p}, nil
+		}
+	}
+
+	return true, parents, nil
+}
+
+func (w *logWalker) pathHash(c *object.Commit) (plumbing.Hash, error) {
+	if h, ok := w.pathHashes[c.Hash]; ok {
+		return h, nil
+	}
+
+	tree, err := object.GetTree(w.st, c.TreeHash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := plumbing.ZeroHash
+	e, err := tree.FindEntry(w.path)
+	switch {
+	case err == nil:
+		h = e.Hash
+	case err != object.ErrEntryNotFound && err != object.ErrDirectoryNotFound:
+		return plumbing.ZeroHash, err
+	}
+
+	w.pathHashes[c.Hash] = h
+	return h, nil
+}
+
+// commitQueue is a max-heap of commits ordered by committer date.
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// maxCompareCommits bounds the number of commits listed by a comparison.
+// AheadBy and BehindBy are always exact.
+const maxCompareCommits = 250
+
+// Comparison describes how head differs from base.
+type Comparison struct {
+	Base string `json:"base"`
+	Head string `json:"head"`
+	// MergeBase is empty if the revisions have no common history.
+	MergeBase string `json:"merge_base,omitempty"`
+	AheadBy   int    `json:"ahead_by"`
+	BehindBy  int    `json:"behind_by"`
+	// Commits are the commits of head missing from base, oldest first.
+	Commits []Commit   `json:"commits"`
+	Files   []FileDiff `json:"files"`
+}
+
+// FileDiff is the change of a single file.
+type FileDiff struct {
+	Path string `json:"path"`
+	// OldPath is only set for renamed files.
+	OldPath string `json:"old_path,omitempty"`
+	// Status is one of added, removed, modified and renamed.
+	Status string `json:"status"`
+	// Similarity is the percentage of unchanged content of a renamed file.
+	Similarity int  `json:"similarity,omitempty"`
+	Additions  int  `json:"additions"`
+	Deletions  int  `json:"deletions"`
+	Binary     bool `json:"binary"`
+	// Patch is the unified diff of the file, without binary content.
+	Patch string `json:"patch"`
+}
+
+// Compare handles GET /repositories/:id/compare/:base...:head. Like
+// git diff base...head, files are compared between the merge base and head.
+func (h *GitHandler) Compare(w http.ResponseWriter, r *http.Request, repoName, baseRev, headRev string) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	cmp, err := compare(r.Context(), st, baseRev, headRev)
+	if errors.Is(err, errUnknownRevision) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to compare revisions", "repo", repoName, "base", baseRev, "head", headRev, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmp)
+}
+
+func compare(ctx context.Context, st storer.Storer, baseRev, headRev string) (*Comparison, error) {
+	base, err := resolveRevision(st, baseRev)
+	if err != nil {
+		return nil, err
+	}
+	head, err := resolveRevision(st, headRev)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &Comparison{Base: base.Hash.String(), Head: head.Hash.String()}
+
+	baseAncestors, err := ancestors(st, base)
+	if err != nil {
+		return nil, err
+	}
+	headAncestors, err := ancestors(st, head)
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range baseAncestors {
+		if _, ok := headAncestors[h]; !ok {
+			cmp.BehindBy++
+		}
+	}
+	commits := missingCommits(head, headAncestors, baseAncestors)
+	cmp.AheadBy = len(commits)
+
+	cmp.Commits = make([]Commit, 0, min(len(commits), maxCompareCommits))
+	for _, c := range commits[:min(len(commits), maxCompareCommits)] {
+		cmp.Commits = append(cmp.Commits, newCommit(c))
+	}
+
+	from := base
+	bases, err := base.MergeBase(head)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) > 0 {
+		from = bases[0]
+		cmp.MergeBase = from.Hash.String()
+	}
+
+	cmp.Files, err = diffCommits(ctx, from, head)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmp, nil
+}
+
+// missingCommits returns the commits of from that are not in exclude, parents
+// before children.
+func missingCommits(head *object.Commit, from, exclude map[plumbing.Hash]*object.Commit) []*object.Commit {
+	var commits []*object.Commit
+	visited := make(map[plumbing.Hash]bool)
+
+	type frame struct {
+		c    *object.Commit
+		next int
+	}
+	stack := []*frame{{c: head}}
+	visited[head.Hash] = true
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if f.next == len(f.c.ParentHashes) {
+			stack = stack[:len(stack)-1]
+			commits = append(commits, f.c)
+			continue
+		}
+
+		p := f.c.ParentHashes[f.next]
+		f.next++
+		pc, ok := from[p]
+		if !ok || visited[p] {
+			continue
+		}
+		visited[p] = true
+		if _, ok := exclude[p]; !ok {
+			stack = append(stack, &frame{c: pc})
+		}
+	}
+
+	if _, ok := exclude[head.Hash]; ok {
+		return nil
+	}
+	return commits
+}
+
+// ancestors returns c and all its ancestors.
+func ancestors(st storer.EncodedObjectStorer, c *object.Commit) (map[plumbing.Hash]*object.Commit, error) {
+	set := map[plumbing.Hash]*object.Commit{c.Hash: c}
+	stack := []*object.Commit{c}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, p := range c.ParentHashes {
+			if _, ok := set[p]; ok {
+				continue
+			}
+			pc, err := object.GetCommit(st, p)
+			if err == plumbing.ErrObjectNotFound {
+				// The history of a shallow push may be incomplete.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			set[p] = pc
+			stack = append(stack, pc)
+		}
+	}
+	return set, nil
+}
+
+// diffCommits returns the changes from the tree of a to the tree of b, with
+// rename detection.
+func diffCommits(ctx context.Context, a, b *object.Commit) ([]FileDiff, error) {
+	at, err := a.Tree()
+	if err != nil {
+		return nil, err
+	}
+	bt, err := b.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeWithOptions(ctx, at, bt, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := changes.PatchContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileDiff, 0, len(patch.FilePatches()))
+	for _, fp := range patch.FilePatches() {
+		fd, err := newFileDiff(fp)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fd)
+	}
+	return files, nil
+}
+
+func newFileDiff(fp fdiff.FilePatch) (FileDiff, error) {
+	from, to := fp.Files()
+
+	var fd FileDiff
+	switch {
+	case from == nil:
+		fd.Path, fd.Status = to.Path(), "added"
+	case to == nil:
+		fd.Path, fd.Status = from.Path(), "removed"
+	case from.Path() != to.Path():
+		fd.Path, fd.OldPath, fd.Status = to.Path(), from.Path(), "renamed"
+	default:
+		fd.Path, fd.Status = to.Path(), "modified"
+	}
+	fd.Binary = fp.IsBinary()
+
+	var unchanged, fromSize, toSize int
+	for _, chunk := range fp.Chunks() {
+		s := chunk.Content()
+		switch chunk.Type() {
+		case fdiff.Add:
+			fd.Additions += countLines(s)
+			toSize += len(s)
+		case fdiff.Delete:
+			fd.Deletions += countLines(s)
+			fromSize += len(s)
+		case fdiff.Equal:
+			unchanged += len(s)
+			fromSize += len(s)
+			toSize += len(s)
+		}
+	}
+	if fd.Status == "renamed" {
+		switch {
+		case from.Hash() == to.Hash():
+			fd.Similarity = 100
+		case max(fromSize, toSize) > 0:
+			fd.Similarity = unchanged * 100 / max(fromSize, toSize)
+		}
+	}
+
+	var sb strings.Builder
+	if err := fdiff.NewUnifiedEncoder(&sb, fdiff.DefaultContextLines).Encode(filePatch{fp}); err != nil {
+		return FileDiff{}, fmt.Errorf("failed to encode patch of %s: %w", fd.Path, err)
+	}
+	fd.Patch = sb.String()
+
+	return fd, nil
+}
+
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// filePatch is a patch of a single file, so that each file gets its own
+// unified diff.
+type filePatch struct {
+	fp fdiff.FilePatch
+}
+
+func (p filePatch) FilePatches() []fdiff.FilePatch { return []fdiff.FilePatch{p.fp} }
+func (p filePatch) Message() string                { return "" }
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// TreeEntry is an entry of a directory listing. Size is only set for blobs.
+type TreeEntry struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Size *int64 `json:"size,omitempty"`
+}
+
+var errNotFound = errors.New("path not found")
+
+// Tree handles GET /repositories/:id/tree/:rev/:path, listing the directory at
+// path in the given revision.
+func (h *GitHandler) Tree(w http.ResponseWriter, r *http.Request, repoName, revPath string) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	c, _, p, err := resolveRevisionPath(st, revPath)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	if p != "" {
+		e, err := findEntry(tree, p)
+		if err != nil {
+			h.contentsError(w, repoName, revPath, err)
+			return
+		}
+		if e.Mode != filemode.Dir {
+			http.Error(w, fmt.Sprintf("not a directory: %s", p), http.StatusBadRequest)
+			return
+		}
+		if tree, err = object.GetTree(st, e.Hash); err != nil {
+			h.contentsError(w, repoName, revPath, err)
+			return
+		}
+	}
+
+	var blobs []plumbing.Hash
+	for _, e := range tree.Entries {
+		if entryType(e.Mode) == "blob" {
+			blobs = append(blobs, e.Hash)
+		}
+	}
+	sizes, err := st.EncodedObjectSizes(blobs)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+
+	entries := make([]TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		entry := TreeEntry{
+			Name: e.Name,
+			Path: path.Join(p, e.Name),
+			Mode: fmt.Sprintf("%06o", uint32(e.Mode)),
+			Type: entryType(e.Mode),
+			Hash: e.Hash.String(),
+		}
+		if size, ok := sizes[e.Hash]; ok && entry.Type == "blob" {
+			entry.Size = &size
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Blob handles GET /repositories/:id/blob/:rev/:path, returning the raw
+// content of the file at path in the given revision. Range requests and
+// conditional requests on the blob hash are supported.
+func (h *GitHandler) Blob(w http.ResponseWriter, r *http.Request, repoName, revPath string) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	c, _, p, err := resolveRevisionPath(st, revPath)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	if p == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	e, err := findEntry(tree, p)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+	if entryType(e.Mode) != "blob" {
+		http.Error(w, fmt.Sprintf("not a file: %s", p), http.StatusBadRequest)
+		return
+	}
+
+	content, err := readBlob(st, e.Hash)
+	if err != nil {
+		h.contentsError(w, repoName, revPath, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", blobContentType(p, content))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fmt.Sprintf("%q", e.Hash.String()))
+	http.ServeContent(w, r, path.Base(p), time.Time{}, bytes.NewReader(content))
+}
+
+func (h *GitHandler) contentsError(w http.ResponseWriter, repoName, revPath string, err error) {
+	if errors.Is(err, errUnknownRevision) || errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Error("failed to read repository contents", "repo", repoName, "path", revPath, "err", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+func findEntry(tree *object.Tree, p string) (*object.TreeEntry, error) {
+	e, err := tree.FindEntry(p)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, p)
+	}
+	return e, err
+}
+
+func entryType(mode filemode.FileMode) string {
+	switch mode {
+	case filemode.Dir:
+		return "tree"
+	case filemode.Submodule:
+		return "commit"
+	default:
+		return "blob"
+	}
+}
+
+func readBlob(st storer.EncodedObjectStorer, h plumbing.Hash) ([]byte, error) {
+	blob, err := object.GetBlob(st, h)
+	if err != nil {
+		return nil, err
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// blobContentType guesses the content type of a file from its extension, then
+// from its content. Types that browsers would render as active content are
+// served as plain text.
+func blobContentType(name string, content []byte) string {
+	ct := mime.TypeByExtension(path.Ext(name))
+	if ct == "" {
+		ct = http.DetectContentType(content)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	switch {
+	case mediaType == "text/html", mediaType == "application/xhtml+xml", mediaType == "image/svg+xml",
+		strings.HasSuffix(mediaType, "javascript"):
+		return "text/plain; charset=utf-8"
+	}
+	return ct
+}
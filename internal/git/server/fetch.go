@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+var errInvalidRemote = errors.New("invalid remote")
+
+// remoteFetch is the result of fetchRemote. Close must be called once the
+// packfile has been read.
+type remoteFetch struct {
+	// Refs are the branches and tags of the remote.
+	Refs map[plumbing.ReferenceName]plumbing.Hash
+	// Head is the branch HEAD points to in the remote, if it advertises it.
+	Head plumbing.ReferenceName
+	// Pack is the packfile of the objects missing from the repository, or nil
+	// if it has them all.
+	Pack  io.Reader
+	close func() error
+}
+
+func (f *remoteFetch) Close() error {
+	return f.close()
+}
+
+// remoteEndpoint parses the location of a remote repository: an http(s),
+// git or ssh URL, a file:// URL or the absolute path of a local repository.
+func remoteEndpoint(rawURL string) (*transport.Endpoint, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("%w: url is required", errInvalidRemote)
+	}
+	ep, err := transport.NewEndpoint(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRemote, err)
+	}
+
+	switch ep.Protocol {
+	case "http", "https", "git", "ssh":
+	case "file":
+		if !filepath.IsAbs(ep.Path) {
+			return nil, fmt.Errorf("%w: local paths must be absolute", errInvalidRemote)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported protocol %q", errInvalidRemote, ep.Protocol)
+	}
+	return ep, nil
+}
+
+// allowedEndpoint parses the location of a remote repository like
+// remoteEndpoint, and checks that a local repository is under the configured
+// LocalRemoteRoot, so that API clients cannot read or write any repository of
+// the host.
+func (h *GitHandler) allowedEndpoint(rawURL string) (*transport.Endpoint, error) {
+	ep, err := remoteEndpoint(rawURL)
+	if err != nil || ep.Protocol != "file" {
+		return ep, err
+	}
+	if h.opts.LocalRemoteRoot == "" {
+		return nil, fmt.Errorf("%w: local repositories are not allowed", errInvalidRemote)
+	}
+
+	// Symbolic links are resolved, so that they cannot lead out of the root.
+	root, err := filepath.EvalSymlinks(h.opts.LocalRemoteRoot)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.EvalSymlinks(ep.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: local repository not found", errInvalidRemote)
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%w: local repositories must be under the allowed directory", errInvalidRemote)
+	}
+	return ep, nil
+}
+
+// redactedURL returns the URL of ep without its credentials.
+func redactedURL(ep *transport.Endpoint) string {
+	e := *ep
+	e.User, e.Password = "", ""
+	return e.String()
+}
+
+// redactError returns the message of err without the credentials of ep,
+// which transports include in their errors along with the URL. ep may be nil.
+func redactError(ep *transport.Endpoint, err error) string {
+	msg := err.Error()
+	if ep == nil || (ep.User == "" && ep.Password == "") {
+		return msg
+	}
+	msg = strings.ReplaceAll(msg, ep.String(), redactedURL(ep))
+	if ep.Password != "" {
+		msg = strings.ReplaceAll(msg, ep.Password, "xxxxx")
+	}
+	return msg
+}
+
+// remoteClient returns the transport of ep. Local repositories are read in
+// process rather than by running git-upload-pack, which the server may not
+// have.
+func remoteClient(ep *transport.Endpoint) (transport.Transport, error) {
+	if ep.Protocol == "file" {
+		return server.NewClient(server.DefaultLoader), nil
+	}
+	return client.NewClient(ep)
+}
+
+// fetchRemote lists the branches and tags of the remote repository at ep and
+// requests the objects st is missing to have them all. Progress messages sent
+// by the remote are written to progress, which may be nil.
+func fetchRemote(ctx context.Context, st *storage.Storer, ep *transport.Endpoint, progress io.Writer) (*remoteFetch, error) {
+	cli, err := remoteClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := cli.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &remoteFetch{
+		Refs:  make(map[plumbing.ReferenceName]plumbing.Hash),
+		close: sess.Close,
+	}
+
+	ar, err := sess.AdvertisedReferencesContext(ctx)
+	if err == transport.ErrEmptyRemoteRepository {
+		return f, nil
+	}
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	refs, err := ar.AllReferences()
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	var hashes []plumbing.Hash
+	for name, ref := range refs {
+		if name == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			f.Head = ref.Target()
+			continue
+		}
+		if ref.Type() != plumbing.HashReference || !(name.IsBranch() || name.IsTag()) || strings.HasSuffix(name.String(), "^{}") {
+			continue
+		}
+		if _, ok := f.Refs[name]; !ok {
+			f.Refs[name] = ref.Hash()
+			hashes = append(hashes, ref.Hash())
+		}
+	}
+
+	// An object being present does not mean its history is: a failed fetch
+	// may have left it behind without its ancestors. Only the tips of the
+	// local branches and tags are known to be complete.
+	tips, err := localTips(st)
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
+	seen := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		if !tips[h] {
+			req.Wants = append(req.Wants, h)
+		} else if ep.Protocol == "file" {
+			// The in-process server used for local repositories fails on
+			// haves it does not have, while git ignores them.
+			req.Haves = append(req.Haves, h)
+		}
+	}
+	if len(req.Wants) == 0 {
+		return f, nil
+	}
+	if ep.Protocol != "file" {
+		for h := range tips {
+			req.Haves = append(req.Haves, h)
+		}
+	}
+	if progress == nil && ar.Capabilities.Supports(capability.NoProgress) {
+		req.Capabilities.Set(capability.NoProgress)
+	}
+
+	resp, err := sess.UploadPack(ctx, req)
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	f.Pack = demuxSideband(req.Capabilities, resp, progress)
+	f.close = func() error {
+		resp.Close()
+		return sess.Close()
+	}
+	return f, nil
+}
+
+// localTips returns the objects the branches and tags of st point to.
+func localTips(st *storage.Storer) (map[plumbing.Hash]bool, error) {
+	refs, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	tips := make(map[plumbing.Hash]bool)
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag()) {
+			tips[ref.Hash()] = true
+		}
+		return nil
+	})
+	return tips, err
+}
+
+// demuxSideband returns the packfile of an upload-pack response, separating
+// it from the progress messages when the sideband is used.
+func demuxSideband(caps *capability.List, r io.Reader, progress io.Writer) io.Reader {
+	var t sideband.Type
+	switch {
+	case caps.Supports(capability.Sideband64k):
+		t = sideband.Sideband64k
+	case caps.Supports(capability.Sideband):
+		t = sideband.Sideband
+	default:
+		return r
+	}
+
+	d := sideband.NewDemuxer(t, r)
+	d.Progress = progress
+	return d
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+var (
+	errInvalidAction = errors.New("invalid action")
+	errNoChanges     = errors.New("the actions do not change any file")
+)
+
+type CreateCommitRequest struct {
+	// Branch is the branch to commit to.
+	Branch string `json:"branch"`
+	// BaseBranch is the branch to start from when Branch does not exist. If
+	// neither exists, a root commit is created.
+	BaseBranch string `json:"base_branch,omitempty"`
+	// ExpectedHead, if set, is the commit Branch must point to.
+	ExpectedHead string           `json:"expected_head,omitempty"`
+	Message      string           `json:"message"`
+	Author       SignatureRequest `json:"author"`
+	// Committer defaults to Author.
+	Committer *SignatureRequest `json:"committer,omitempty"`
+	Actions   []FileAction      `json:"actions"`
+}
+
+type SignatureRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// FileAction is a change to a single file. Action is one of "create",
+// "update", "delete" and "move".
+type FileAction struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// PreviousPath is the file being moved.
+	PreviousPath string `json:"previous_path,omitempty"`
+	// Content is base64-encoded. Updated and moved files keep their content
+	// if it is omitted.
+	Content []byte `json:"content,omitempty"`
+	// Executable sets the mode of the file. Updated and moved files keep
+	// their mode if it is omitted.
+	Executable *bool `json:"executable,omitempty"`
+}
+
+// CreateCommit handles POST /repositories/:id/commits. It commits the actions
+// on top of the branch without a working copy and advances the branch if it
+// has not moved in the meantime.
+func (h *GitHandler) CreateCommit(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req CreateCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := plumbing.NewBranchReferenceName(req.Branch)
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	parent, old, err := commitParent(st, name, &req)
+	if err != nil {
+		h.fileCommitError(w, repoName, name, err)
+		return
+	}
+
+	c, err := writeFileCommit(st, parent, &req)
+	if err != nil {
+		h.fileCommitError(w, repoName, name, err)
+		return
+	}
+
+	// The branch is only advanced if it still points to the parent, or
+	// created if it still does not exist.
+	if err := h.advanceBranch(r, repoName, name, old, c.Hash, st); err != nil {
+		h.fileCommitError(w, repoName, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newCommit(c))
+}
+
+func (req *CreateCommitRequest) validate() error {
+	name := plumbing.NewBranchReferenceName(req.Branch)
+	if req.Branch == "" || validateReferenceName(name) != nil {
+		return fmt.Errorf("invalid branch: %q", req.Branch)
+	}
+	if req.BaseBranch != "" && validateReferenceName(plumbing.NewBranchReferenceName(req.BaseBranch)) != nil {
+		return fmt.Errorf("invalid base_branch: %q", req.BaseBranch)
+	}
+	if req.ExpectedHead != "" && !plumbing.IsHash(req.ExpectedHead) {
+		return errors.New("invalid expected_head")
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		return errors.New("message is required")
+	}
+	if req.Author.Name == "" || req.Author.Email == "" {
+		return errors.New("author name and email are required")
+	}
+	if req.Committer != nil && (req.Committer.Name == "" || req.Committer.Email == "") {
+		return errors.New("committer name and email are required")
+	}
+	if len(req.Actions) == 0 {
+		return errors.New("at least one action is required")
+	}
+	return nil
+}
+
+// commitParent returns the commit to build on, nil for a root commit, and the
+// hash the branch must still have for the commit to be applied, which is the
+// zero hash if the branch does not exist yet.
+func commitParent(st *storage.Storer, name plumbing.ReferenceName, req *CreateCommitRequest) (*object.Commit, plumbing.Hash, error) {
+	head := plumbing.ZeroHash
+	ref, err := st.Reference(name)
+	switch {
+	case err == nil:
+		head = ref.Hash()
+	case err != plumbing.ErrReferenceNotFound:
+		return nil, plumbing.ZeroHash, err
+	}
+
+	if req.ExpectedHead != "" && plumbing.NewHash(req.ExpectedHead) != head {
+		return nil, plumbing.ZeroHash, errStale
+	}
+
+	start := head
+	if start.IsZero() && req.BaseBranch != "" {
+		base, err := st.Reference(plumbing.NewBranchReferenceName(req.BaseBranch))
+		if err == plumbing.ErrReferenceNotFound {
+			return nil, plumbing.ZeroHash, fmt.Errorf("%w: base branch %q not found", errInvalidAction, req.BaseBranch)
+		}
+		if err != nil {
+			return nil, plumbing.ZeroHash, err
+		}
+		start = base.Hash()
+	}
+	if start.IsZero() {
+		return nil, head, nil
+	}
+
+	c, err := object.GetCommit(st, start)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return c, head, nil
+}
+
+// writeFileCommit applies the actions to the tree of parent and writes the
+// resulting commit.
+func writeFileCommit(st *storage.Storer, parent *object.Commit, req *CreateCommitRequest) (*object.Commit, error) {
+	root := plumbing.ZeroHash
+	if parent != nil {
+		root = parent.TreeHash
+	}
+
+	b := newTreeBuilder(st, root)
+	for i, a := range req.Actions {
+		if err := applyFileAction(st, b, a); err != nil {
+			if errors.Is(err, errInvalidAction) || errors.Is(err, errInvalidPath) {
+				return nil, fmt.Errorf("actions[%d]: %w", i, err)
+			}
+			return nil, err
+		}
+	}
+
+	tree, err := b.write()
+	if err != nil {
+		return nil, err
+	}
+	if tree == root {
+		return nil, errNoChanges
+	}
+
+	now := time.Now()
+	author := req.Author.signature(now)
+	committer := author
+	if req.Committer != nil {
+		committer = req.Committer.signature(now)
+	}
+
+	c := &object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message:   commitMessage(req.Message),
+		TreeHash:  tree,
+	}
+	if parent != nil {
+		c.ParentHashes = []plumbing.Hash{parent.Hash}
+	}
+
+	return c, writeCommit(st, c)
+}
+
+func applyFileAction(st storer.EncodedObjectStorer, b *treeBuilder, a FileAction) error {
+	switch a.Action {
+	case "create":
+		e, err := b.entry(a.Path)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			return fmt.Errorf("%w: %s already exists", errInvalidAction, a.Path)
+		}
+		return putFile(st, b, a.Path, a.Content, fileMode(a.Executable, filemode.Regular))
+
+	case "update":
+		e, err := fileEntry(b, a.Path)
+		if err != nil {
+			return err
+		}
+		mode := fileMode(a.Executable, e.mode)
+		if a.Content == nil {
+			return b.put(a.Path, mode, e.hash)
+		}
+		return putFile(st, b, a.Path, a.Content, mode)
+
+	case "delete":
+		if _, err := fileEntry(b, a.Path); err != nil {
+			return err
+		}
+		return b.remove(a.Path)
+
+	case "move":
+		if a.PreviousPath == "" {
+			return fmt.Errorf("%w: previous_path is required", errInvalidAction)
+		}
+		e, err := fileEntry(b, a.PreviousPath)
+		if err != nil {
+			return err
+		}
+		if dst, err := b.entry(a.Path); err != nil {
+			return err
+		} else if dst != nil {
+			return fmt.Errorf("%w: %s already exists", errInvalidAction, a.Path)
+		}
+		if err := b.remove(a.PreviousPath); err != nil {
+			return err
+		}
+		mode := fileMode(a.Executable, e.mode)
+		if a.Content == nil {
+			return b.put(a.Path, mode, e.hash)
+		}
+		return putFile(st, b, a.Path, a.Content, mode)
+
+	default:
+		return fmt.Errorf("%w: unknown action %q", errInvalidAction, a.Action)
+	}
+}
+
+// fileEntry returns the entry of the file at p, which must exist and must not
+// be a directory.
+func fileEntry(b *treeBuilder, p string) (*treeNodeEntry, error) {
+	e, err := b.entry(p)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s does not exist", errInvalidAction, p)
+	}
+	if e.mode == filemode.Dir || e.mode == filemode.Submodule {
+		return nil, fmt.Errorf("%w: %s is not a file", errInvalidAction, p)
+	}
+	return e, nil
+}
+
+// fileMode returns the mode set by executable, or mode if it is nil.
+func fileMode(executable *bool, mode filemode.FileMode) filemode.FileMode {
+	switch {
+	case executable == nil:
+		return mode
+	case *executable:
+		return filemode.Executable
+	default:
+		return filemode.Regular
+	}
+}
+
+func putFile(st storer.EncodedObjectStorer, b *treeBuilder, p string, content []byte, mode filemode.FileMode) error {
+	h, err := writeBlob(st, content)
+	if err != nil {
+		return err
+	}
+	return b.put(p, mode, h)
+}
+
+func writeBlob(st storer.EncodedObjectStorer, content []byte) (plumbing.Hash, error) {
+	obj := st.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+	wr, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := wr.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := wr.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return st.SetEncodedObject(obj)
+}
+
+// writeCommit writes c and sets its hash.
+func writeCommit(st storer.EncodedObjectStorer, c *object.Commit) error {
+	obj := st.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return err
+	}
+	h, err := st.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+	c.Hash = h
+	return nil
+}
+
+func (s SignatureRequest) signature(when time.Time) object.Signature {
+	return object.Signature{Name: s.Name, Email: s.Email, When: when}
+}
+
+// commitMessage terminates message with a newline, like git commit.
+func commitMessage(message string) string {
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	return message
+}
+
+func (h *GitHandler) fileCommitError(w http.ResponseWriter, repoName string, name plumbing.ReferenceName, err error) {
+	switch {
+	case errors.Is(err, errInvalidAction), errors.Is(err, errInvalidPath), err == errNoChanges:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		h.referenceError(w, repoName, name, err)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// objectFilter omits objects from a packfile, as requested by a partial clone
+// with --filter. Objects the client asks for explicitly are never omitted,
+// which is how a partial clone fetches missing objects lazily.
+type objectFilter struct {
+	// blobLimit omits blobs of at least this size. -1 means no limit.
+	blobLimit int64
+	// treeDepth omits trees and blobs at this depth or deeper, the root tree
+	// of a commit being at depth 0. -1 means no limit.
+	treeDepth int
+}
+
+// parseFilter parses a filter-spec. The blob:none, blob:limit=<n>[kmg],
+// tree:<depth> and combine:<filter>+<filter>... forms are supported.
+func parseFilter(spec string) (*objectFilter, error) {
+	f := &objectFilter{blobLimit: -1, treeDepth: -1}
+	if err := f.add(spec); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *objectFilter) add(spec string) error {
+	switch {
+	case spec == "blob:none":
+		f.limitBlobs(0)
+	case strings.HasPrefix(spec, "blob:limit="):
+		n, err := parseSize(strings.TrimPrefix(spec, "blob:limit="))
+		if err != nil {
+			return fmt.Errorf("invalid filter-spec %q: %w", spec, err)
+		}
+		f.limitBlobs(n)
+	case strings.HasPrefix(spec, "tree:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(spec, "tree:"))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid filter-spec %q", spec)
+		}
+		if f.treeDepth < 0 || n < f.treeDepth {
+			f.treeDepth = n
+		}
+	case strings.HasPrefix(spec, "combine:"):
+		for _, part := range strings.Split(strings.TrimPrefix(spec, "combine:"), "+") {
+			sub, err := url.PathUnescape(part)
+			if err != nil {
+				return fmt.Errorf("invalid filter-spec %q: %w", spec, err)
+			}
+			if err := f.add(sub); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported filter-spec %q", spec)
+	}
+	return nil
+}
+
+func (f *objectFilter) limitBlobs(n int64) {
+	if f.blobLimit < 0 || n < f.blobLimit {
+		f.blobLimit = n
+	}
+}
+
+// parseSize parses a size with an optional k, m or g suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// omitsTree reports whether a tree at depth is omitted.
+func (f *objectFilter) omitsTree(depth int) bool {
+	return f != nil && f.treeDepth >= 0 && depth >= f.treeDepth
+}
+
+// omitsBlob reports whether the blob h at depth is omitted. Its size is only
+// looked up when the filter has a size limit.
+func (f *objectFilter) omitsBlob(st storer.EncodedObjectStorer, h plumbing.Hash, depth int) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	if f.omitsTree(depth) || f.blobLimit == 0 {
+		return true, nil
+	}
+	if f.blobLimit < 0 {
+		return false, nil
+	}
+
+	size, err := st.EncodedObjectSize(h)
+	if err != nil {
+		return false, err
+	}
+	return size >= f.blobLimit, nil
+}
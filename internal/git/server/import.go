@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
+)
+
+// Import job states.
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+const (
+	// jobHeartbeat is how often a running job records its progress, even if
+	// it has not changed.
+	jobHeartbeat = 15 * time.Second
+
+	// jobStaleAfter is how long a job can go without recording its progress
+	// before it is considered abandoned, e.g. by a server that stopped.
+	jobStaleAfter = 4 * jobHeartbeat
+)
+
+// ImportJob is the state of an import of a remote repository. Progress is
+// the last progress message of the import.
+type ImportJob struct {
+	ID         int32      `json:"id"`
+	URL        string     `json:"url"`
+	State      string     `json:"state"`
+	Progress   string     `json:"progress,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ImportRequest is the remote repository to import. Existing references that
+// point elsewhere are only moved if Force is set.
+type ImportRequest struct {
+	URL   string `json:"url"`
+	Force bool   `json:"force,omitempty"`
+}
+
+// Import handles POST /repositories/:id/import. It starts a job fetching the
+// branches, tags and objects of a remote repository, returns 202 and the job,
+// whose state is then available at the URL of the Location header.
+func (h *GitHandler) Import(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ep, err := h.allowedEndpoint(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.ms.FailStaleImportJobs(r.Context(), repoName, jobStaleAfter, "import was abandoned")
+	var job pg.ImportJob
+	if err == nil {
+		job, err = h.ms.CreateImportJob(r.Context(), repoName, redactedURL(ep))
+	}
+	if err == metastore.ErrImportInProgress {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to create import job", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.jobs.Add(1)
+	go h.runImport(job, ep, req.Force)
+
+	w.Header().Set("Location", fmt.Sprintf("/repositories/%s/import/%d", repoName, job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newImportJob(job))
+}
+
+// ImportJobs handles GET /repositories/:id/import, listing the import jobs of
+// a repository, newest first. Results are paginated with "page" and
+// "per_page".
+func (h *GitHandler) ImportJobs(w http.ResponseWriter, r *http.Request, repoName string) {
+	q := r.URL.Query()
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One more job is fetched to know if there is a next page.
+	jobs, err := h.ms.ListImportJobs(r.Context(), repoName, int32((page-1)*perPage), int32(perPage+1))
+	if err != nil {
+		slog.Error("failed to list import jobs", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]ImportJob, 0, min(len(jobs), perPage))
+	for _, job := range jobs[:min(len(jobs), perPage)] {
+		resp = append(resp, newImportJob(job))
+	}
+
+	if len(jobs) > perPage {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetImportJob handles GET /repositories/:id/import/:job.
+func (h *GitHandler) GetImportJob(w http.ResponseWriter, r *http.Request, repoName string, id int32) {
+	job, err := h.ms.GetImportJob(r.Context(), repoName, id)
+	if err == metastore.ErrNotFound {
+		http.Error(w, "import job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get import job", "repo", repoName, "job", id, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newImportJob(job))
+}
+
+// runImport runs an import job in the background and records its outcome.
+func (h *GitHandler) runImport(job pg.ImportJob, ep *transport.Endpoint, force bool) {
+	defer h.jobs.Done()
+
+	p := newJobProgress(h.ctx, h.ms, job.ID)
+	err := h.importRepository(h.ctx, job.RepoName, ep, force, p)
+	p.stop()
+
+	state, msg := jobSucceeded, ""
+	if err != nil {
+		state, msg = jobFailed, redactError(ep, err)
+		slog.Warn("import failed", "repo", job.RepoName, "job", job.ID, "url", job.Url, "err", msg)
+	}
+
+	// The outcome is recorded even if the job was cancelled by Close.
+	if err := h.ms.FinishImportJob(context.WithoutCancel(h.ctx), job.ID, state, msg); err != nil {
+		slog.Error("failed to finish import job", "repo", job.RepoName, "job", job.ID, "err", err)
+	}
+}
+
+// importRepository fetches the objects of the remote at ep, then creates its
+// branches and tags like a push would. If the branch HEAD points to does not
+// exist afterwards, HEAD is pointed to the default branch of the remote.
+func (h *GitHandler) importRepository(ctx context.Context, repoName string, ep *transport.Endpoint, force bool, p *jobProgress) error {
+	st := storage.NewStorer(ctx, h.os, h.ms, repoName)
+
+	p.set("Fetching references")
+	f, err := fetchRemote(ctx, st, ep, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req := packp.NewReferenceUpdateRequest()
+	for _, name := range sortedNames(f.Refs) {
+		cmd, err := importCommand(st, name, f.Refs[name], force)
+		if err != nil {
+			return err
+		}
+		if cmd != nil {
+			req.Commands = append(req.Commands, cmd)
+		}
+	}
+
+	if f.Pack != nil {
+		p.set("Receiving objects")
+	}
+	rs := h.receivePack(ctx, st, repoName, req, f.Pack)
+	if rs.UnpackStatus != "ok" {
+		return fmt.Errorf("failed to unpack objects: %s", rs.UnpackStatus)
+	}
+
+	var rejected []string
+	for _, cs := range rs.CommandStatuses {
+		if cs.Status != "ok" {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", cs.ReferenceName, cs.Status))
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%d references rejected: %s", len(rejected), strings.Join(rejected, "; "))
+	}
+
+	p.set(fmt.Sprintf("Imported %d references", len(f.Refs)))
+	if f.Head == "" {
+		return nil
+	}
+	return h.withRepositoryLock(ctx, repoName, func() error {
+		return setMissingHead(st, f.Head)
+	})
+}
+
+// importCommand returns the command creating name at hash or, if force is
+// set, moving it there. It returns nil if name already points to hash.
+func importCommand(st *storage.Storer, name plumbing.ReferenceName, hash plumbing.Hash, force bool) (*packp.Command, error) {
+	cmd := &packp.Command{Name: name, New: hash}
+	current, err := st.Reference(name)
+	switch {
+	case err == plumbing.ErrReferenceNotFound:
+	case err != nil:
+		return nil, err
+	case current.Hash() == hash:
+		return nil, nil
+	case force:
+		cmd.Old = current.Hash()
+	}
+	return cmd, nil
+}
+
+// setMissingHead points HEAD to branch if it does not point to an existing
+// branch. It must be called with the repository lock held.
+func setMissingHead(st *storage.Storer, branch plumbing.ReferenceName) error {
+	head, err := st.Reference(plumbing.HEAD)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+	if err == nil && head.Type() == plumbing.SymbolicReference {
+		if _, err := st.Reference(head.Target()); err == nil {
+			return nil
+		}
+	}
+	if _, err := st.Reference(branch); err != nil {
+		return nil
+	}
+	return st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+}
+
+func sortedNames(refs map[plumbing.ReferenceName]plumbing.Hash) []plumbing.ReferenceName {
+	names := make([]plumbing.ReferenceName, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func newImportJob(job pg.ImportJob) ImportJob {
+	resp := ImportJob{
+		ID:        job.ID,
+		URL:       job.Url,
+		State:     job.State,
+		Progress:  job.Progress,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt.Time,
+		UpdatedAt: job.UpdatedAt.Time,
+	}
+	if job.FinishedAt.Valid {
+		resp.FinishedAt = &job.FinishedAt.Time
+	}
+	return resp
+}
+
+// jobProgress records the progress of a running job: the last line of the
+// progress messages of the remote, or the current step of the job. It is
+// written to the metastore once a second when it changes, and at least every
+// jobHeartbeat.
+type jobProgress struct {
+	ms   *metastore.MetaStore
+	id   int32
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	line    string
+	partial []byte
+	changed bool
+}
+
+func newJobProgress(ctx context.Context, ms *metastore.MetaStore, id int32) *jobProgress {
+	p := &jobProgress{ms: ms, id: id, done: make(chan struct{}), changed: true}
+	p.wg.Add(1)
+	go p.run(ctx)
+	return p
+}
+
+// Write receives progress messages, whose lines end with \r or \n.
+func (p *jobProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(p.partial[:i])); line != "" {
+			p.line, p.changed = line, true
+		}
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *jobProgress) set(line string) {
+	p.mu.Lock()
+	p.line, p.changed = line, true
+	p.mu.Unlock()
+}
+
+func (p *jobProgress) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := time.Time{}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			line, changed := p.line, p.changed
+			p.changed = false
+			p.mu.Unlock()
+
+			if !changed && now.Sub(last) < jobHeartbeat {
+				continue
+			}
+			if err := p.ms.UpdateImportJobProgress(ctx, p.id, jobRunning, line); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Warn("failed to record job progress", "job", p.id, "err", err)
+			}
+			last = now
+		}
+	}
+}
+
+func (p *jobProgress) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
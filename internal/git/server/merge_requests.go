@@ -0,0 +1,872 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
+)
+
+// mergeRequestRefPrefix is the namespace of the references holding the head
+// of merge requests. Only the server updates them.
+const mergeRequestRefPrefix = "refs/merge-requests/"
+
+// Merge request states.
+const (
+	stateOpen   = "open"
+	stateClosed = "closed"
+	stateMerged = "merged"
+)
+
+var (
+	errMergeRequestNotFound = errors.New("merge request not found")
+	errNotOpen              = errors.New("merge request is not open")
+	errInvalidReview        = errors.New("invalid review")
+)
+
+// MergeRequest is a request to merge a source branch into a target branch.
+type MergeRequest struct {
+	Number       int32  `json:"number"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	Author       string `json:"author"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	// State is one of open, closed and merged.
+	State string `json:"state"`
+	// HeadCommit is the commit of refs/merge-requests/{number}/head, which
+	// follows the source branch while the merge request is open. It is empty
+	// if the reference is missing.
+	HeadCommit string `json:"head_commit,omitempty"`
+	// BaseCommit and MergeCommit are the tip of the target branch before and
+	// after merging.
+	BaseCommit  string `json:"base_commit,omitempty"`
+	MergeCommit string `json:"merge_commit,omitempty"`
+	// Approvals are only listed for a single merge request.
+	Approvals []Approval `json:"approvals,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// Approval is an approval of a merge request at a given head commit.
+type Approval struct {
+	Approver  string    `json:"approver"`
+	Commit    string    `json:"commit"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewComment is a comment on a merge request. Comments with a Path are
+// anchored to a line of a file: Side "new" is the file at Commit and "old"
+// the file at the merge base of Commit and the target branch.
+type ReviewComment struct {
+	ID        int32     `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Commit    string    `json:"commit"`
+	Path      string    `json:"path,omitempty"`
+	Line      int32     `json:"line,omitempty"`
+	Side      string    `json:"side,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateMergeRequestRequest struct {
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	Author       string `json:"author"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+}
+
+// UpdateMergeRequestRequest changes the fields that are set. State can be
+// set to "closed" or "open" to close or reopen a merge request.
+type UpdateMergeRequestRequest struct {
+	Title        *string `json:"title,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	TargetBranch *string `json:"target_branch,omitempty"`
+	State        *string `json:"state,omitempty"`
+}
+
+type CreateReviewCommentRequest struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+	// Commit defaults to the head of the merge request.
+	Commit string `json:"commit,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Line   int32  `json:"line,omitempty"`
+	// Side defaults to "new".
+	Side string `json:"side,omitempty"`
+}
+
+type ApprovalRequest struct {
+	Approver string `json:"approver"`
+}
+
+// AcceptMergeRequestRequest merges a merge request. The fields are those of
+// MergeBranchRequest, ExpectedHead being the head commit of the merge
+// request, e.g. the one that was reviewed.
+type AcceptMergeRequestRequest struct {
+	Method       string            `json:"method,omitempty"`
+	Message      string            `json:"message,omitempty"`
+	Author       *SignatureRequest `json:"author,omitempty"`
+	Committer    *SignatureRequest `json:"committer,omitempty"`
+	ExpectedHead string            `json:"expected_head,omitempty"`
+}
+
+func mergeRequestRef(number int32) plumbing.ReferenceName {
+	return plumbing.ReferenceName(fmt.Sprintf("%s%d/head", mergeRequestRefPrefix, number))
+}
+
+// isHiddenReference reports whether name is maintained by the server and
+// cannot be updated by clients.
+func isHiddenReference(name plumbing.ReferenceName) bool {
+	return strings.HasPrefix(name.String(), mergeRequestRefPrefix)
+}
+
+// updateMergeRequestHeads points the head of the open merge requests of
+// branch to h. It must be called with the repository lock held.
+func (h *GitHandler) updateMergeRequestHeads(ctx context.Context, st *storage.Storer, repoName, branch string, hash plumbing.Hash) error {
+	numbers, err := h.ms.ListOpenMergeRequestsBySource(ctx, repoName, branch)
+	if err != nil {
+		return err
+	}
+	for _, n := range numbers {
+		if err := st.SetReference(plumbing.NewHashReference(mergeRequestRef(n), hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateMergeRequest handles POST /repositories/:id/merge-requests. The head
+// of the merge request is set to the source branch, which must exist, like
+// the target branch.
+func (h *GitHandler) CreateMergeRequest(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req CreateMergeRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	var mr pg.MergeRequest
+	err := h.withRepositoryLock(r.Context(), repoName, func() error {
+		if _, err := branchHead(st, plumbing.NewBranchReferenceName(req.TargetBranch), ""); err != nil {
+			return err
+		}
+		source, err := branchHead(st, plumbing.NewBranchReferenceName(req.SourceBranch), "")
+		if err != nil {
+			return err
+		}
+
+		mr, err = h.ms.CreateMergeRequest(r.Context(), pg.CreateMergeRequestParams{
+			RepoName:     repoName,
+			Title:        req.Title,
+			Description:  req.Description,
+			Author:       req.Author,
+			SourceBranch: req.SourceBranch,
+			TargetBranch: req.TargetBranch,
+		}, func(n int32) string { return mergeRequestRef(n).String() }, source.Hash.String())
+		return err
+	})
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	h.writeMergeRequest(w, r, st, mr, http.StatusCreated)
+}
+
+// ListMergeRequests handles GET /repositories/:id/merge-requests, newest
+// first. The "state" query parameter filters merge requests by state, "open"
+// by default or "all", and results are paginated with "page" and "per_page".
+func (h *GitHandler) ListMergeRequests(w http.ResponseWriter, r *http.Request, repoName string) {
+	q := r.URL.Query()
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state := q.Get("state")
+	switch state {
+	case "":
+		state = stateOpen
+	case "all":
+		state = ""
+	case stateOpen, stateClosed, stateMerged:
+	default:
+		http.Error(w, fmt.Sprintf("invalid state: %q", state), http.StatusBadRequest)
+		return
+	}
+
+	// One more merge request is fetched to know if there is a next page.
+	mrs, err := h.ms.ListMergeRequests(r.Context(), repoName, state, int32((page-1)*perPage), int32(perPage+1))
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	resp := make([]MergeRequest, 0, min(len(mrs), perPage))
+	for _, mr := range mrs[:min(len(mrs), perPage)] {
+		m, err := h.mergeRequest(r.Context(), st, mr, false)
+		if err != nil {
+			h.mergeRequestError(w, repoName, err)
+			return
+		}
+		resp = append(resp, m)
+	}
+
+	if len(mrs) > perPage {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetMergeRequest handles GET /repositories/:id/merge-requests/:number.
+func (h *GitHandler) GetMergeRequest(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	mr, err := h.getMergeRequest(r.Context(), repoName, number)
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	h.writeMergeRequest(w, r, st, mr, http.StatusOK)
+}
+
+// UpdateMergeRequest handles PATCH /repositories/:id/merge-requests/:number.
+// Reopening a merge request moves its head to the source branch, which must
+// still exist.
+func (h *GitHandler) UpdateMergeRequest(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	var req UpdateMergeRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	var mr pg.MergeRequest
+	err := h.withRepositoryLock(r.Context(), repoName, func() error {
+		var err error
+		mr, err = h.getMergeRequest(r.Context(), repoName, number)
+		if err != nil {
+			return err
+		}
+		if mr.State == stateMerged {
+			return fmt.Errorf("%w: it is already merged", errNotOpen)
+		}
+
+		arg := updateParams(mr)
+		if req.Title != nil {
+			arg.Title = *req.Title
+		}
+		if req.Description != nil {
+			arg.Description = *req.Description
+		}
+		if req.TargetBranch != nil && *req.TargetBranch != mr.TargetBranch {
+			if *req.TargetBranch == mr.SourceBranch {
+				return fmt.Errorf("%w: the source and target branches must differ", errInvalidReview)
+			}
+			if _, err := branchHead(st, plumbing.NewBranchReferenceName(*req.TargetBranch), ""); err != nil {
+				return err
+			}
+			arg.TargetBranch = *req.TargetBranch
+		}
+
+		if req.State != nil && *req.State != mr.State {
+			arg.State = *req.State
+			switch arg.State {
+			case stateClosed:
+				arg.ClosedAt = pgtype.Timestamp{Time: time.Now().UTC(), Valid: true}
+			case stateOpen:
+				arg.ClosedAt = pgtype.Timestamp{}
+				source, err := branchHead(st, plumbing.NewBranchReferenceName(mr.SourceBranch), "")
+				if err != nil {
+					return err
+				}
+				if err := st.SetReference(plumbing.NewHashReference(mergeRequestRef(number), source.Hash)); err != nil {
+					return err
+				}
+			}
+		}
+
+		mr, err = h.ms.UpdateMergeRequest(r.Context(), arg)
+		return err
+	})
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	h.writeMergeRequest(w, r, st, mr, http.StatusOK)
+}
+
+// MergeRequestDiff handles GET /repositories/:id/merge-requests/:number/diff,
+// comparing the head of the merge request with the target branch, or with
+// the tip of the target branch it was merged into.
+func (h *GitHandler) MergeRequestDiff(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	mr, err := h.getMergeRequest(r.Context(), repoName, number)
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	head, err := st.Reference(mergeRequestRef(number))
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	base := plumbing.NewBranchReferenceName(mr.TargetBranch).String()
+	if mr.BaseCommit.Valid {
+		base = mr.BaseCommit.String
+	}
+
+	cmp, err := compare(r.Context(), st, base, head.Hash().String())
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmp)
+}
+
+// ListReviewComments handles GET
+// /repositories/:id/merge-requests/:number/comments, oldest first.
+func (h *GitHandler) ListReviewComments(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	if _, err := h.getMergeRequest(r.Context(), repoName, number); err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	comments, err := h.ms.ListMergeRequestComments(r.Context(), repoName, number)
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	resp := make([]ReviewComment, 0, len(comments))
+	for _, c := range comments {
+		resp = append(resp, newReviewComment(c))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CreateReviewComment handles POST
+// /repositories/:id/merge-requests/:number/comments. Comments anchored to a
+// line are checked against the file they refer to.
+func (h *GitHandler) CreateReviewComment(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	var req CreateReviewCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Side == "" && req.Path != "" {
+		req.Side = "new"
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mr, err := h.getMergeRequest(r.Context(), repoName, number)
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	commit := req.Commit
+	if commit == "" {
+		head, err := st.Reference(mergeRequestRef(number))
+		if err != nil {
+			h.mergeRequestError(w, repoName, err)
+			return
+		}
+		commit = head.Hash().String()
+	}
+	c, err := object.GetCommit(st, plumbing.NewHash(commit))
+	if err == plumbing.ErrObjectNotFound {
+		err = fmt.Errorf("%w: commit %s not found", errInvalidReview, commit)
+	}
+	if err == nil && req.Path != "" {
+		err = checkCommentLine(st, mr, c, req.Path, req.Line, req.Side)
+	}
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	comment, err := h.ms.CreateMergeRequestComment(r.Context(), pg.CreateMergeRequestCommentParams{
+		RepoName:   repoName,
+		Number:     number,
+		Author:     req.Author,
+		Body:       req.Body,
+		CommitHash: c.Hash.String(),
+		Path:       pgtype.Text{String: req.Path, Valid: req.Path != ""},
+		Line:       pgtype.Int4{Int32: req.Line, Valid: req.Path != ""},
+		Side:       pgtype.Text{String: req.Side, Valid: req.Path != ""},
+	})
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newReviewComment(comment))
+}
+
+// checkCommentLine checks that the file at p has the given line on the given
+// side of the changes of c.
+func checkCommentLine(st *storage.Storer, mr pg.MergeRequest, c *object.Commit, p string, line int32, side string) error {
+	if side == "old" {
+		base, err := mergeRequestBase(st, mr, c)
+		if err != nil {
+			return err
+		}
+		c = base
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	e, err := findEntry(tree, p)
+	if errors.Is(err, errNotFound) {
+		return fmt.Errorf("%w: %s does not exist on the %s side", errInvalidReview, p, side)
+	}
+	if err != nil {
+		return err
+	}
+	if e.Mode == filemode.Dir || e.Mode == filemode.Submodule {
+		return fmt.Errorf("%w: %s is not a file", errInvalidReview, p)
+	}
+
+	content, err := readBlob(st, e.Hash)
+	if err != nil {
+		return err
+	}
+	if lines := countLines(string(content)); int(line) > lines {
+		return fmt.Errorf("%w: %s has %d lines on the %s side", errInvalidReview, p, lines, side)
+	}
+	return nil
+}
+
+// mergeRequestBase returns the commit c is compared with: its merge base
+// with the target branch or, once merged, with the commit the target branch
+// was at.
+func mergeRequestBase(st *storage.Storer, mr pg.MergeRequest, c *object.Commit) (*object.Commit, error) {
+	rev := plumbing.NewBranchReferenceName(mr.TargetBranch).String()
+	if mr.BaseCommit.Valid {
+		rev = mr.BaseCommit.String
+	}
+	target, err := resolveRevision(st, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := target.MergeBase(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("%w: %s has no common history with %s", errInvalidReview, c.Hash, mr.TargetBranch)
+	}
+	return bases[0], nil
+}
+
+// Approve handles POST /repositories/:id/merge-requests/:number/approvals,
+// approving the current head of an open merge request. Approving again
+// moves the approval to the current head.
+func (h *GitHandler) Approve(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	var req ApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Approver == "" {
+		http.Error(w, "approver is required", http.StatusBadRequest)
+		return
+	}
+
+	mr, err := h.getMergeRequest(r.Context(), repoName, number)
+	if err == nil && mr.State != stateOpen {
+		err = errNotOpen
+	}
+	if err == nil && mr.Author == req.Approver {
+		err = fmt.Errorf("%w: authors cannot approve their own merge requests", errInvalidReview)
+	}
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	head, err := st.Reference(mergeRequestRef(number))
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	a, err := h.ms.PutMergeRequestApproval(r.Context(), repoName, number, req.Approver, head.Hash().String())
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newApproval(a))
+}
+
+// Unapprove handles DELETE
+// /repositories/:id/merge-requests/:number/approvals/:approver.
+func (h *GitHandler) Unapprove(w http.ResponseWriter, r *http.Request, repoName string, number int32, approver string) {
+	err := h.ms.DeleteMergeRequestApproval(r.Context(), repoName, number, approver)
+	if err == metastore.ErrNotFound {
+		http.Error(w, "approval not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptMergeRequest handles POST
+// /repositories/:id/merge-requests/:number/merge. It merges the head of the
+// merge request into the target branch like Merge and marks it as merged. A
+// merge request whose changes are already in the target branch is marked as
+// merged without changing the branch.
+func (h *GitHandler) AcceptMergeRequest(w http.ResponseWriter, r *http.Request, repoName string, number int32) {
+	var body AcceptMergeRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.ExpectedHead != "" && !plumbing.IsHash(body.ExpectedHead) {
+		http.Error(w, "invalid expected_head", http.StatusBadRequest)
+		return
+	}
+
+	mr, err := h.getMergeRequest(r.Context(), repoName, number)
+	if err == nil && mr.State != stateOpen {
+		err = errNotOpen
+	}
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	req := MergeBranchRequest{
+		Base:      mr.TargetBranch,
+		Head:      mergeRequestRef(number).String(),
+		Method:    body.Method,
+		Message:   body.Message,
+		Author:    body.Author,
+		Committer: body.Committer,
+	}
+	if req.Method == "" {
+		req.Method = methodMerge
+	}
+	if req.Message == "" {
+		req.Message = fmt.Sprintf("Merge request #%d: %s", number, mr.Title)
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := plumbing.NewBranchReferenceName(mr.TargetBranch)
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	headRef, err := st.Reference(mergeRequestRef(number))
+	if err == nil && body.ExpectedHead != "" && plumbing.NewHash(body.ExpectedHead) != headRef.Hash() {
+		http.Error(w, "merge request head is not the expected commit", http.StatusPreconditionFailed)
+		return
+	}
+	var base, head *object.Commit
+	if err == nil {
+		base, err = branchHead(st, name, "")
+	}
+	if err == nil {
+		head, err = object.GetCommit(st, headRef.Hash())
+	}
+	if err != nil {
+		h.mergeRequestError(w, repoName, err)
+		return
+	}
+
+	m := &treeMerger{st: st}
+	tip, stopped, err := mergeInto(m, base, head, &req)
+	switch {
+	case err == errUpToDate:
+		tip, err = base, nil
+	case err != nil:
+		h.mergeError(w, repoName, name, err)
+		return
+	case len(m.conflicts) > 0:
+		resp := ConflictResponse{Message: "merge conflict", Conflicts: m.conflicts}
+		if stopped != nil {
+			resp.Commit = stopped.Hash.String()
+		}
+		writeConflicts(w, resp)
+		return
+	default:
+		err = st.Flush()
+	}
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	// The merge request is checked again, the branch advanced and the merge
+	// request marked as merged under the lock, so that a concurrent accept,
+	// close or push cannot interleave with them.
+	err = h.withRepositoryLock(r.Context(), repoName, func() error {
+		current, err := h.getMergeRequest(r.Context(), repoName, number)
+		if err != nil {
+			return err
+		}
+		if current.State != stateOpen {
+			return errNotOpen
+		}
+		headRef, err := st.Reference(mergeRequestRef(number))
+		if err != nil {
+			return err
+		}
+		if current.TargetBranch != mr.TargetBranch || headRef.Hash() != head.Hash {
+			return errStale
+		}
+
+		if tip != base {
+			if err := h.moveBranch(r.Context(), st, repoName, name, base.Hash, tip.Hash); err != nil {
+				return err
+			}
+		}
+
+		arg := updateParams(current)
+		arg.State = stateMerged
+		arg.BaseCommit = pgtype.Text{String: base.Hash.String(), Valid: true}
+		arg.MergeCommit = pgtype.Text{String: tip.Hash.String(), Valid: true}
+		arg.ClosedAt = pgtype.Timestamp{Time: time.Now().UTC(), Valid: true}
+		mr, err = h.ms.UpdateMergeRequest(r.Context(), arg)
+		return err
+	})
+	switch {
+	case err == errMergeRequestNotFound, err == errNotOpen:
+		h.mergeRequestError(w, repoName, err)
+		return
+	case err != nil:
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	h.writeMergeRequest(w, r, st, mr, http.StatusOK)
+}
+
+func (req *CreateMergeRequestRequest) validate() error {
+	switch {
+	case req.Title == "" || len(req.Title) > 255:
+		return errors.New("title is required and must be at most 255 bytes")
+	case req.Author == "":
+		return errors.New("author is required")
+	case !isValidBranch(req.SourceBranch):
+		return fmt.Errorf("invalid source_branch: %q", req.SourceBranch)
+	case !isValidBranch(req.TargetBranch):
+		return fmt.Errorf("invalid target_branch: %q", req.TargetBranch)
+	case req.SourceBranch == req.TargetBranch:
+		return errors.New("the source and target branches must differ")
+	}
+	return nil
+}
+
+func (req *UpdateMergeRequestRequest) validate() error {
+	switch {
+	case req.Title != nil && (*req.Title == "" || len(*req.Title) > 255):
+		return errors.New("title must not be empty and must be at most 255 bytes")
+	case req.TargetBranch != nil && !isValidBranch(*req.TargetBranch):
+		return fmt.Errorf("invalid target_branch: %q", *req.TargetBranch)
+	case req.State != nil && *req.State != stateOpen && *req.State != stateClosed:
+		return fmt.Errorf("state must be %q or %q", stateOpen, stateClosed)
+	}
+	return nil
+}
+
+func (req *CreateReviewCommentRequest) validate() error {
+	switch {
+	case req.Author == "":
+		return errors.New("author is required")
+	case strings.TrimSpace(req.Body) == "":
+		return errors.New("body is required")
+	case req.Commit != "" && !plumbing.IsHash(req.Commit):
+		return errors.New("invalid commit")
+	case req.Path == "" && (req.Line != 0 || req.Side != ""):
+		return errors.New("line and side require a path")
+	case req.Path != "" && req.Line < 1:
+		return errors.New("line must be at least 1")
+	case req.Path != "" && req.Side != "new" && req.Side != "old":
+		return errors.New(`side must be "new" or "old"`)
+	}
+	return nil
+}
+
+func isValidBranch(name string) bool {
+	return name != "" && validateReferenceName(plumbing.NewBranchReferenceName(name)) == nil
+}
+
+func (h *GitHandler) getMergeRequest(ctx context.Context, repoName string, number int32) (pg.MergeRequest, error) {
+	mr, err := h.ms.GetMergeRequest(ctx, repoName, number)
+	if err == metastore.ErrNotFound {
+		return mr, errMergeRequestNotFound
+	}
+	return mr, err
+}
+
+// updateParams returns the parameters that leave mr unchanged.
+func updateParams(mr pg.MergeRequest) pg.UpdateMergeRequestParams {
+	return pg.UpdateMergeRequestParams{
+		RepoName:     mr.RepoName,
+		Number:       mr.Number,
+		Title:        mr.Title,
+		Description:  mr.Description,
+		TargetBranch: mr.TargetBranch,
+		State:        mr.State,
+		BaseCommit:   mr.BaseCommit,
+		MergeCommit:  mr.MergeCommit,
+		ClosedAt:     mr.ClosedAt,
+	}
+}
+
+// mergeRequest returns the REST representation of mr, with its approvals if
+// withApprovals is set.
+func (h *GitHandler) mergeRequest(ctx context.Context, st *storage.Storer, mr pg.MergeRequest, withApprovals bool) (MergeRequest, error) {
+	resp := MergeRequest{
+		Number:       mr.Number,
+		Title:        mr.Title,
+		Description:  mr.Description,
+		Author:       mr.Author,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		State:        mr.State,
+		BaseCommit:   mr.BaseCommit.String,
+		MergeCommit:  mr.MergeCommit.String,
+		CreatedAt:    mr.CreatedAt.Time,
+		UpdatedAt:    mr.UpdatedAt.Time,
+	}
+	if mr.ClosedAt.Valid {
+		resp.ClosedAt = &mr.ClosedAt.Time
+	}
+
+	head, err := st.Reference(mergeRequestRef(mr.Number))
+	switch {
+	case err == nil:
+		resp.HeadCommit = head.Hash().String()
+	case err != plumbing.ErrReferenceNotFound:
+		return resp, err
+	}
+
+	if withApprovals {
+		approvals, err := h.ms.ListMergeRequestApprovals(ctx, mr.RepoName, mr.Number)
+		if err != nil {
+			return resp, err
+		}
+		resp.Approvals = make([]Approval, 0, len(approvals))
+		for _, a := range approvals {
+			resp.Approvals = append(resp.Approvals, newApproval(a))
+		}
+	}
+	return resp, nil
+}
+
+func (h *GitHandler) writeMergeRequest(w http.ResponseWriter, r *http.Request, st *storage.Storer, mr pg.MergeRequest, status int) {
+	resp, err := h.mergeRequest(r.Context(), st, mr, true)
+	if err != nil {
+		h.mergeRequestError(w, mr.RepoName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newApproval(a pg.MergeRequestApproval) Approval {
+	return Approval{Approver: a.Approver, Commit: a.CommitHash, CreatedAt: a.CreatedAt.Time}
+}
+
+func newReviewComment(c pg.MergeRequestComment) ReviewComment {
+	return ReviewComment{
+		ID:        c.ID,
+		Author:    c.Author,
+		Body:      c.Body,
+		Commit:    c.CommitHash,
+		Path:      c.Path.String,
+		Line:      c.Line.Int32,
+		Side:      c.Side.String,
+		CreatedAt: c.CreatedAt.Time,
+	}
+}
+
+func (h *GitHandler) mergeRequestError(w http.ResponseWriter, repoName string, err error) {
+	switch {
+	case err == errMergeRequestNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == errMissingRef:
+		http.Error(w, "branch not found", http.StatusUnprocessableEntity)
+	case errors.Is(err, errNotOpen):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidReview):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, errUnknownRevision):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == metastore.ErrLockTimeout:
+		http.Error(w, statusLocked, http.StatusServiceUnavailable)
+	default:
+		slog.Error("merge request operation failed", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
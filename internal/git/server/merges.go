@@ -0,0 +1,471 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+)
+
+// maxRebaseCommits bounds the number of commits replayed by a rebase.
+const maxRebaseCommits = 250
+
+var (
+	errInvalidMerge   = errors.New("invalid merge")
+	errNotFastForward = errors.New("not a fast-forward")
+	errUpToDate       = errors.New("already up to date")
+)
+
+// Merge methods.
+const (
+	methodMerge       = "merge"
+	methodSquash      = "squash"
+	methodRebase      = "rebase"
+	methodFastForward = "fast-forward"
+)
+
+type MergeBranchRequest struct {
+	// Base is the branch to merge into.
+	Base string `json:"base"`
+	// Head is the revision to merge.
+	Head string `json:"head"`
+	// Method is one of "merge" (the default), "squash", "rebase" and
+	// "fast-forward".
+	Method string `json:"method,omitempty"`
+	// ExpectedHead, if set, is the commit Base must point to.
+	ExpectedHead string `json:"expected_head,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// Author defaults to Committer.
+	Author *SignatureRequest `json:"author,omitempty"`
+	// Committer is required unless fast-forwarding.
+	Committer *SignatureRequest `json:"committer,omitempty"`
+}
+
+type CherryPickRequest struct {
+	// Branch is the branch to apply the commit to.
+	Branch string `json:"branch"`
+	// Commit is the revision to pick.
+	Commit string `json:"commit"`
+	// Mainline is the parent, numbered from 1, whose changes are picked from
+	// a merge commit.
+	Mainline int `json:"mainline,omitempty"`
+	// ExpectedHead, if set, is the commit Branch must point to.
+	ExpectedHead string           `json:"expected_head,omitempty"`
+	Committer    SignatureRequest `json:"committer"`
+}
+
+// ConflictResponse is returned with 409 when changes cannot be merged.
+type ConflictResponse struct {
+	Message string `json:"message"`
+	// Commit is the commit being replayed when a rebase stopped.
+	Commit    string          `json:"commit,omitempty"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// Merge handles POST /repositories/:id/merges. It merges head into the base
+// branch and returns the new tip of the branch, or 204 if there is nothing to
+// merge. The merge method decides how:
+//
+//   - merge always creates a merge commit, even if base could be
+//     fast-forwarded.
+//   - squash creates a single commit with the merged changes on top of base.
+//   - rebase replays the commits of head missing from base on top of it, or
+//     fast-forwards base if it has no commits of its own.
+//   - fast-forward moves base to head, or fails with 409.
+func (h *GitHandler) Merge(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req MergeBranchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Method == "" {
+		req.Method = methodMerge
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := plumbing.NewBranchReferenceName(req.Base)
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	st.EnablePrefetch()
+	defer st.Close()
+
+	base, err := branchHead(st, name, req.ExpectedHead)
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+	head, err := resolveRevision(st, req.Head)
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	m := &treeMerger{st: st}
+	tip, stopped, err := mergeInto(m, base, head, &req)
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+	if len(m.conflicts) > 0 {
+		resp := ConflictResponse{Message: "merge conflict", Conflicts: m.conflicts}
+		if stopped != nil {
+			resp.Commit = stopped.Hash.String()
+		}
+		writeConflicts(w, resp)
+		return
+	}
+
+	if err := h.advanceBranch(r, repoName, name, base.Hash, tip.Hash, st); err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCommit(tip))
+}
+
+// mergeInto merges head into base with the method of req and returns the new
+// tip of base. If the merger recorded conflicts, the commit being replayed by
+// a rebase is returned instead.
+func mergeInto(m *treeMerger, base, head *object.Commit, req *MergeBranchRequest) (*object.Commit, *object.Commit, error) {
+	bases, err := base.MergeBase(head)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bases) == 0 {
+		return nil, nil, fmt.Errorf("%w: %s and %s have no common history", errInvalidMerge, req.Base, req.Head)
+	}
+	// With several merge bases, e.g. after criss-cross merges, the first
+	// one is used instead of merging them like Git does.
+	mergeBase := bases[0]
+	if mergeBase.Hash == head.Hash {
+		return nil, nil, errUpToDate
+	}
+	fastForward := mergeBase.Hash == base.Hash
+
+	now := time.Now()
+	var author, committer object.Signature
+	if req.Committer != nil {
+		committer = req.Committer.signature(now)
+		author = committer
+		if req.Author != nil {
+			author = req.Author.signature(now)
+		}
+	}
+
+	switch req.Method {
+	case methodFastForward:
+		if !fastForward {
+			return nil, nil, errNotFastForward
+		}
+		return head, nil, nil
+
+	case methodRebase:
+		if fastForward {
+			return head, nil, nil
+		}
+		return rebase(m, base, head, committer)
+
+	default:
+		tree, err := m.merge(mergeBase.TreeHash, base.TreeHash, head.TreeHash)
+		if err != nil || len(m.conflicts) > 0 {
+			return nil, nil, err
+		}
+
+		message := req.Message
+		if message == "" {
+			message = fmt.Sprintf("Merge %s into %s", req.Head, req.Base)
+			if req.Method == methodSquash {
+				message = fmt.Sprintf("Squash %s into %s", req.Head, req.Base)
+			}
+		}
+
+		c := &object.Commit{
+			Author:       author,
+			Committer:    committer,
+			Message:      commitMessage(message),
+			TreeHash:     tree,
+			ParentHashes: []plumbing.Hash{base.Hash, head.Hash},
+		}
+		if req.Method == methodSquash {
+			if tree == base.TreeHash {
+				return nil, nil, errUpToDate
+			}
+			c.ParentHashes = c.ParentHashes[:1]
+		}
+		return c, nil, writeCommit(m.st, c)
+	}
+}
+
+// rebase replays the commits of head missing from base on top of base, oldest
+// first. Commits that change nothing on top of base, including empty
+// commits, are dropped.
+func rebase(m *treeMerger, base, head *object.Commit, committer object.Signature) (*object.Commit, *object.Commit, error) {
+	commits, err := rebaseCommits(m.st, base, head)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range commits {
+		if len(c.ParentHashes) > 1 {
+			return nil, nil, fmt.Errorf("%w: cannot rebase merge commit %s", errInvalidMerge, c.Hash)
+		}
+	}
+
+	tip := base
+	for _, c := range commits {
+		picked, err := m.pick(tip, c, 1, committer)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(m.conflicts) > 0 {
+			return nil, c, nil
+		}
+		if picked != nil {
+			tip = picked
+		}
+	}
+	if tip == base {
+		return nil, nil, errUpToDate
+	}
+	return tip, nil, nil
+}
+
+// rebaseCommits returns the commits of head missing from base, parents before
+// children. Both histories are walked together, newest first, until the
+// commits left to visit are all reachable from base, so only the commits
+// since the merge base are read. It fails once more than maxRebaseCommits
+// commits are found.
+func rebaseCommits(st storer.EncodedObjectStorer, base, head *object.Commit) ([]*object.Commit, error) {
+	const (
+		fromHead = 1 << iota
+		fromBase
+	)
+
+	flags := map[plumbing.Hash]int{head.Hash: fromHead}
+	flags[base.Hash] |= fromBase
+	queue := &commitQueue{head}
+	if base.Hash != head.Hash {
+		heap.Push(queue, base)
+	}
+
+	missing := make(map[plumbing.Hash]*object.Commit)
+	for slices.ContainsFunc(*queue, func(c *object.Commit) bool { return flags[c.Hash] == fromHead }) {
+		c := heap.Pop(queue).(*object.Commit)
+		f := flags[c.Hash]
+		if f == fromHead {
+			missing[c.Hash] = c
+			if len(missing) > maxRebaseCommits {
+				return nil, fmt.Errorf("%w: cannot rebase more than %d commits", errInvalidMerge, maxRebaseCommits)
+			}
+		} else {
+			// Reached from base after being visited from head, clock skew
+			// aside.
+			delete(missing, c.Hash)
+		}
+
+		for _, p := range c.ParentHashes {
+			if flags[p]|f == flags[p] {
+				continue
+			}
+			pc, err := object.GetCommit(st, p)
+			if err == plumbing.ErrObjectNotFound {
+				// The history of a shallow push may be incomplete.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			flags[p] |= f
+			heap.Push(queue, pc)
+		}
+	}
+
+	if flags[head.Hash]&fromBase != 0 {
+		return nil, nil
+	}
+	return missingCommits(head, missing, nil), nil
+}
+
+// CherryPick handles POST /repositories/:id/cherry-picks. It applies the
+// changes of a commit on top of the branch, keeping its author and message,
+// and returns the new commit.
+func (h *GitHandler) CherryPick(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req CherryPickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := plumbing.NewBranchReferenceName(req.Branch)
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	onto, err := branchHead(st, name, req.ExpectedHead)
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+	c, err := resolveRevision(st, req.Commit)
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	parent := req.Mainline
+	switch {
+	case len(c.ParentHashes) > 1 && parent == 0:
+		err = fmt.Errorf("%w: mainline is required to cherry-pick merge commit %s", errInvalidMerge, c.Hash)
+	case len(c.ParentHashes) <= 1 && parent > 1, parent > len(c.ParentHashes):
+		err = fmt.Errorf("%w: commit %s has no parent %d", errInvalidMerge, c.Hash, parent)
+	case parent == 0:
+		parent = 1
+	}
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	m := &treeMerger{st: st}
+	picked, err := m.pick(onto, c, parent, req.Committer.signature(time.Now()))
+	if err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+	if len(m.conflicts) > 0 {
+		writeConflicts(w, ConflictResponse{Message: "cherry-pick conflict", Conflicts: m.conflicts})
+		return
+	}
+	if picked == nil {
+		h.mergeError(w, repoName, name, errNoChanges)
+		return
+	}
+
+	if err := h.advanceBranch(r, repoName, name, onto.Hash, picked.Hash, st); err != nil {
+		h.mergeError(w, repoName, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newCommit(picked))
+}
+
+func (req *MergeBranchRequest) validate() error {
+	if req.Base == "" || validateReferenceName(plumbing.NewBranchReferenceName(req.Base)) != nil {
+		return fmt.Errorf("invalid base: %q", req.Base)
+	}
+	if req.Head == "" {
+		return errors.New("head is required")
+	}
+	if req.ExpectedHead != "" && !plumbing.IsHash(req.ExpectedHead) {
+		return errors.New("invalid expected_head")
+	}
+
+	switch req.Method {
+	case methodMerge, methodSquash, methodRebase:
+		if req.Committer == nil || req.Committer.Name == "" || req.Committer.Email == "" {
+			return errors.New("committer name and email are required")
+		}
+		if req.Author != nil && (req.Author.Name == "" || req.Author.Email == "") {
+			return errors.New("author name and email are required")
+		}
+	case methodFastForward:
+	default:
+		return fmt.Errorf("unknown merge method: %q", req.Method)
+	}
+	return nil
+}
+
+func (req *CherryPickRequest) validate() error {
+	if req.Branch == "" || validateReferenceName(plumbing.NewBranchReferenceName(req.Branch)) != nil {
+		return fmt.Errorf("invalid branch: %q", req.Branch)
+	}
+	if req.Commit == "" {
+		return errors.New("commit is required")
+	}
+	if req.Mainline < 0 {
+		return errors.New("invalid mainline")
+	}
+	if req.ExpectedHead != "" && !plumbing.IsHash(req.ExpectedHead) {
+		return errors.New("invalid expected_head")
+	}
+	if req.Committer.Name == "" || req.Committer.Email == "" {
+		return errors.New("committer name and email are required")
+	}
+	return nil
+}
+
+// branchHead returns the commit the branch points to, which must be expected
+// if it is set.
+func branchHead(st *storage.Storer, name plumbing.ReferenceName, expected string) (*object.Commit, error) {
+	ref, err := st.Reference(name)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, errMissingRef
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expected != "" && plumbing.NewHash(expected) != ref.Hash() {
+		return nil, errStale
+	}
+	return object.GetCommit(st, ref.Hash())
+}
+
+// advanceBranch waits for the new objects to be stored, then moves the branch
+// from old to new, or creates it if old is the zero hash, unless it has
+// changed in the meantime.
+func (h *GitHandler) advanceBranch(r *http.Request, repoName string, name plumbing.ReferenceName, old, new plumbing.Hash, st *storage.Storer) error {
+	if err := st.Flush(); err != nil {
+		return err
+	}
+
+	return h.withRepositoryLock(r.Context(), repoName, func() error {
+		return h.moveBranch(r.Context(), st, repoName, name, old, new)
+	})
+}
+
+// moveBranch moves the branch from old to new, unless it has changed. It must
+// be called with the repository lock held.
+func (h *GitHandler) moveBranch(ctx context.Context, st *storage.Storer, repoName string, name plumbing.ReferenceName, old, new plumbing.Hash) error {
+	cmd := &packp.Command{Name: name, Old: old, New: new}
+	if err := h.validateCommand(ctx, st, repoName, cmd); err != nil {
+		return err
+	}
+	return h.applyCommand(ctx, st, repoName, cmd)
+}
+
+func writeConflicts(w http.ResponseWriter, resp ConflictResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *GitHandler) mergeError(w http.ResponseWriter, repoName string, name plumbing.ReferenceName, err error) {
+	switch {
+	case err == errUpToDate:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errUnknownRevision):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == errNotFastForward:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidMerge), err == errNoChanges:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		h.referenceError(w, repoName, name, err)
+	}
+}
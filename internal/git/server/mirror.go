@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
+)
+
+const (
+	// minMirrorInterval is the shortest interval between two scheduled
+	// synchronisations of a mirror.
+	minMirrorInterval = time.Minute
+
+	// mirrorBatch is how many due mirrors are synchronised concurrently.
+	mirrorBatch = 4
+
+	// mirrorSyncTimeout bounds a synchronisation, so that an unresponsive
+	// upstream cannot hold back the others.
+	mirrorSyncTimeout = 30 * time.Minute
+)
+
+// PullMirror is the upstream of a mirror and the outcome of its last
+// synchronisation. Credentials are removed from URL.
+type PullMirror struct {
+	URL             string     `json:"url"`
+	IntervalSeconds int32      `json:"interval_seconds"`
+	NextSyncAt      time.Time  `json:"next_sync_at"`
+	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
+	LastSyncState   string     `json:"last_sync_state,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastSuccessAt   *time.Time `json:"last_success_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// PullMirrorRequest configures the upstream of a mirror. IntervalSeconds
+// defaults to the configured mirror interval.
+type PullMirrorRequest struct {
+	URL             string `json:"url"`
+	IntervalSeconds int32  `json:"interval_seconds,omitempty"`
+}
+
+// isMirroredReference reports whether name is kept in sync with the upstream
+// of a pull mirror.
+func isMirroredReference(name plumbing.ReferenceName) bool {
+	return name.IsBranch() || name.IsTag()
+}
+
+// SetMirror handles PUT /repositories/:id/mirror. It makes the repository a
+// mirror of an upstream repository, or changes its upstream, and schedules
+// a synchronisation right away.
+func (h *GitHandler) SetMirror(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req PullMirrorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.allowedEndpoint(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := h.opts.MirrorInterval
+	if req.IntervalSeconds != 0 {
+		interval = time.Duration(req.IntervalSeconds) * time.Second
+	}
+	if interval < minMirrorInterval {
+		http.Error(w, fmt.Sprintf("interval_seconds must be at least %d", int(minMirrorInterval.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	// The URL is stored with its credentials, which are needed to fetch.
+	mirror, err := h.ms.UpsertPullMirror(r.Context(), repoName, req.URL, interval)
+	if err != nil {
+		slog.Error("failed to set pull mirror", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeMirror(w, mirror, http.StatusOK)
+}
+
+// GetMirror handles GET /repositories/:id/mirror.
+func (h *GitHandler) GetMirror(w http.ResponseWriter, r *http.Request, repoName string) {
+	mirror, err := h.ms.GetPullMirror(r.Context(), repoName)
+	if err != nil {
+		h.mirrorError(w, repoName, err)
+		return
+	}
+
+	writeMirror(w, mirror, http.StatusOK)
+}
+
+// DeleteMirror handles DELETE /repositories/:id/mirror. The repository keeps
+// its references and accepts pushes again.
+func (h *GitHandler) DeleteMirror(w http.ResponseWriter, r *http.Request, repoName string) {
+	if err := h.ms.DeletePullMirror(r.Context(), repoName); err != nil {
+		h.mirrorError(w, repoName, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SyncMirror handles POST /repositories/:id/mirror/sync, synchronising the
+// mirror with its upstream before responding. It returns 502 if the
+// synchronisation failed, with the error in the mirror.
+func (h *GitHandler) SyncMirror(w http.ResponseWriter, r *http.Request, repoName string) {
+	mirror, err := h.ms.GetPullMirror(r.Context(), repoName)
+	if err != nil {
+		h.mirrorError(w, repoName, err)
+		return
+	}
+
+	mirror, err = h.syncMirror(r.Context(), mirror)
+	if err != nil {
+		h.mirrorError(w, repoName, err)
+		return
+	}
+
+	status := http.StatusOK
+	if mirror.LastError != "" {
+		status = http.StatusBadGateway
+	}
+	writeMirror(w, mirror, status)
+}
+
+func (h *GitHandler) mirrorError(w http.ResponseWriter, repoName string, err error) {
+	switch {
+	case err == metastore.ErrNotFound:
+		http.Error(w, "repository is not a mirror", http.StatusNotFound)
+	default:
+		slog.Error("pull mirror operation failed", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// runMirrors synchronises the mirrors that are due every poll interval until
+// the handler is closed.
+func (h *GitHandler) runMirrors() {
+	defer h.jobs.Done()
+
+	ticker := time.NewTicker(h.opts.MirrorPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.syncDueMirrors()
+		}
+	}
+}
+
+// syncDueMirrors claims the mirrors that are due, a batch at a time, and
+// synchronises them.
+func (h *GitHandler) syncDueMirrors() {
+	for h.ctx.Err() == nil {
+		mirrors, err := h.ms.ClaimDuePullMirrors(h.ctx, mirrorBatch)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				slog.Error("failed to claim due mirrors", "err", err)
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, mirror := range mirrors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(h.ctx, mirrorSyncTimeout)
+				defer cancel()
+				if _, err := h.syncMirror(ctx, mirror); err != nil {
+					slog.Error("failed to record mirror synchronisation", "repo", mirror.RepoName, "err", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(mirrors) < mirrorBatch {
+			return
+		}
+	}
+}
+
+// syncMirror synchronises a mirror and records the outcome. The returned
+// error is only about recording it.
+func (h *GitHandler) syncMirror(ctx context.Context, mirror pg.PullMirror) (pg.PullMirror, error) {
+	state, msg := jobSucceeded, ""
+	ep, err := h.allowedEndpoint(mirror.Url)
+	if err == nil {
+		err = h.fetchMirror(ctx, mirror.RepoName, ep)
+	}
+	if err != nil {
+		state, msg = jobFailed, redactError(ep, err)
+		slog.Warn("mirror synchronisation failed", "repo", mirror.RepoName, "err", msg)
+	}
+
+	// The outcome is recorded even if ctx was cancelled.
+	return h.ms.RecordPullMirrorSync(context.WithoutCancel(ctx), mirror.RepoName, state, msg)
+}
+
+// fetchMirror fetches the objects of the upstream at ep, then makes the
+// branches and tags of the repository match those of the upstream, deleting
+// those the upstream no longer has, and points HEAD to its default branch.
+func (h *GitHandler) fetchMirror(ctx context.Context, repoName string, ep *transport.Endpoint) error {
+	st := storage.NewStorer(ctx, h.os, h.ms, repoName)
+	f, err := fetchRemote(ctx, st, ep, nil)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if f.Pack != nil {
+		if err := packfile.UpdateObjectStorage(st, f.Pack); err != nil {
+			return err
+		}
+		if err := st.Flush(); err != nil {
+			return err
+		}
+	}
+
+	// The commands are computed under the lock, so that concurrent
+	// synchronisations of the same mirror cannot undo each other.
+	return h.withRepositoryLock(ctx, repoName, func() error {
+		cmds, err := mirrorCommands(st, f.Refs)
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			if err := h.applyCommand(ctx, st, repoName, cmd); err != nil {
+				return fmt.Errorf("failed to update %s: %w", cmd.Name, err)
+			}
+		}
+
+		if _, ok := f.Refs[f.Head]; !ok {
+			return nil
+		}
+		head, err := st.Reference(plumbing.HEAD)
+		if err == nil && head.Target() == f.Head {
+			return nil
+		}
+		return st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, f.Head))
+	})
+}
+
+// mirrorCommands returns the commands making the branches and tags of st
+// match refs.
+func mirrorCommands(st *storage.Storer, refs map[plumbing.ReferenceName]plumbing.Hash) ([]*packp.Command, error) {
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var cmds []*packp.Command
+	local := make(map[plumbing.ReferenceName]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !isMirroredReference(ref.Name()) {
+			return nil
+		}
+		local[ref.Name()] = true
+
+		hash, ok := refs[ref.Name()]
+		switch {
+		case !ok:
+			cmds = append(cmds, &packp.Command{Name: ref.Name(), Old: ref.Hash(), New: plumbing.ZeroHash})
+		case hash != ref.Hash():
+			cmds = append(cmds, &packp.Command{Name: ref.Name(), Old: ref.Hash(), New: hash})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range sortedNames(refs) {
+		if !local[name] {
+			cmds = append(cmds, &packp.Command{Name: name, New: refs[name]})
+		}
+	}
+	return cmds, nil
+}
+
+func writeMirror(w http.ResponseWriter, mirror pg.PullMirror, status int) {
+	resp := PullMirror{
+		URL:             mirror.Url,
+		IntervalSeconds: mirror.IntervalSeconds,
+		NextSyncAt:      mirror.NextSyncAt.Time,
+		LastSyncState:   mirror.LastSyncState,
+		LastError:       mirror.LastError,
+		CreatedAt:       mirror.CreatedAt.Time,
+		UpdatedAt:       mirror.UpdatedAt.Time,
+	}
+	if ep, err := remoteEndpoint(mirror.Url); err == nil {
+		resp.URL = redactedURL(ep)
+	}
+	if mirror.LastSyncAt.Valid {
+		resp.LastSyncAt = &mirror.LastSyncAt.Time
+	}
+	if mirror.LastSuccessAt.Valid {
+		resp.LastSuccessAt = &mirror.LastSuccessAt.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
+)
+
+const (
+	// pushMirrorAttempts is how many times a push to a mirror is attempted
+	// before the run is recorded as failed.
+	pushMirrorAttempts = 3
+
+	// pushMirrorBackoff is the delay before the second attempt, doubled
+	// before each of the next ones.
+	pushMirrorBackoff = time.Second
+
+	// pushMirrorRetryDelay is the delay before a mirror whose run failed is
+	// synchronised again, doubled after each failed retry up to
+	// pushMirrorMaxRetryDelay.
+	pushMirrorRetryDelay    = time.Minute
+	pushMirrorMaxRetryDelay = time.Hour
+)
+
+var errDeleteUnsupported = errors.New("remote does not support deleting references")
+
+// PushMirror is a remote to which the branches and tags of a repository are
+// pushed after they change, and the outcome of its last run. Credentials are
+// removed from URL.
+type PushMirror struct {
+	ID        int32      `json:"id"`
+	URL       string     `json:"url"`
+	LastState string     `json:"last_state,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PushMirrorRun is a push to a mirror. Refs are the references it updated or
+// deleted, and Attempts how many times it was tried.
+type PushMirrorRun struct {
+	ID         int32     `json:"id"`
+	State      string    `json:"state"`
+	Refs       []string  `json:"refs"`
+	Attempts   int32     `json:"attempts"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type PushMirrorRequest struct {
+	URL string `json:"url"`
+}
+
+// pushQueue holds the pushes waiting for the push mirrors of a repository.
+type pushQueue struct {
+	// refs are the branches and tags that changed.
+	refs map[plumbing.ReferenceName]bool
+	// syncs are the mirrors to which every branch and tag is pushed.
+	syncs map[int32]bool
+}
+
+// pushRetry is the scheduled synchronisation of a mirror whose run failed.
+type pushRetry struct {
+	delay time.Duration
+	// timer is nil once the synchronisation is queued.
+	timer *time.Timer
+}
+
+func newPushQueue() *pushQueue {
+	return &pushQueue{
+		refs:  make(map[plumbing.ReferenceName]bool),
+		syncs: make(map[int32]bool),
+	}
+}
+
+// CreatePushMirror handles POST /repositories/:id/push-mirrors. The remote is
+// synchronised with the repository right away.
+func (h *GitHandler) CreatePushMirror(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req PushMirrorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.allowedEndpoint(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The URL is stored with its credentials, which are needed to push.
+	mirror, err := h.ms.CreatePushMirror(r.Context(), repoName, req.URL)
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+	h.queuePushMirrorSync(repoName, mirror.ID)
+
+	writePushMirror(w, mirror, http.StatusCreated)
+}
+
+// ListPushMirrors handles GET /repositories/:id/push-mirrors.
+func (h *GitHandler) ListPushMirrors(w http.ResponseWriter, r *http.Request, repoName string) {
+	mirrors, err := h.ms.ListPushMirrors(r.Context(), repoName)
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+
+	resp := make([]PushMirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		resp = append(resp, newPushMirror(m))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetPushMirror handles GET /repositories/:id/push-mirrors/:mirror.
+func (h *GitHandler) GetPushMirror(w http.ResponseWriter, r *http.Request, repoName string, id int32) {
+	mirror, err := h.ms.GetPushMirror(r.Context(), repoName, id)
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+
+	writePushMirror(w, mirror, http.StatusOK)
+}
+
+// DeletePushMirror handles DELETE /repositories/:id/push-mirrors/:mirror. The
+// remote is left as it is.
+func (h *GitHandler) DeletePushMirror(w http.ResponseWriter, r *http.Request, repoName string, id int32) {
+	if err := h.ms.DeletePushMirror(r.Context(), repoName, id); err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+	h.resetPushMirrorRetry(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SyncPushMirror handles POST /repositories/:id/push-mirrors/:mirror/sync. It
+// queues a push of every branch and tag to the mirror, deleting those the
+// repository does not have from it, and returns 202.
+func (h *GitHandler) SyncPushMirror(w http.ResponseWriter, r *http.Request, repoName string, id int32) {
+	mirror, err := h.ms.GetPushMirror(r.Context(), repoName, id)
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+	h.queuePushMirrorSync(repoName, mirror.ID)
+
+	writePushMirror(w, mirror, http.StatusAccepted)
+}
+
+// PushMirrorRuns handles GET /repositories/:id/push-mirrors/:mirror/runs,
+// listing the runs of a mirror, newest first. Results are paginated with
+// "page" and "per_page".
+func (h *GitHandler) PushMirrorRuns(w http.ResponseWriter, r *http.Request, repoName string, id int32) {
+	q := r.URL.Query()
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mirror, err := h.ms.GetPushMirror(r.Context(), repoName, id)
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+
+	// One more run is fetched to know if there is a next page.
+	runs, err := h.ms.ListPushMirrorRuns(r.Context(), mirror.ID, int32((page-1)*perPage), int32(perPage+1))
+	if err != nil {
+		h.pushMirrorError(w, repoName, err)
+		return
+	}
+
+	resp := make([]PushMirrorRun, 0, min(len(runs), perPage))
+	for _, run := range runs[:min(len(runs), perPage)] {
+		resp = append(resp, newPushMirrorRun(run))
+	}
+
+	if len(runs) > perPage {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *GitHandler) pushMirrorError(w http.ResponseWriter, repoName string, err error) {
+	switch {
+	case err == metastore.ErrNotFound:
+		http.Error(w, "push mirror not found", http.StatusNotFound)
+	default:
+		slog.Error("push mirror operation failed", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// queueMirrorPush queues a push of a branch or tag that changed to the push
+// mirrors of a repository.
+func (h *GitHandler) queueMirrorPush(repoName string, name plumbing.ReferenceName) {
+	if !isMirroredReference(name) {
+		return
+	}
+	h.queuePush(repoName, func(q *pushQueue) { q.refs[name] = true })
+}
+
+// queuePushMirrorSync queues a push of every branch and tag to a push mirror.
+func (h *GitHandler) queuePushMirrorSync(repoName string, id int32) {
+	h.queuePush(repoName, func(q *pushQueue) { q.syncs[id] = true })
+}
+
+// schedulePushMirrorRetry queues a synchronisation of a mirror whose run
+// failed, after a delay that grows with each failed retry. It does nothing if
+// one is already scheduled.
+func (h *GitHandler) schedulePushMirrorRetry(repoName string, id int32) {
+	h.pushMu.Lock()
+	defer h.pushMu.Unlock()
+
+	if h.ctx.Err() != nil {
+		return
+	}
+
+	r, ok := h.pushRetries[id]
+	if !ok {
+		r = &pushRetry{}
+		h.pushRetries[id] = r
+	}
+	if r.timer != nil {
+		return
+	}
+	r.delay = min(max(2*r.delay, pushMirrorRetryDelay), pushMirrorMaxRetryDelay)
+	r.timer = time.AfterFunc(r.delay, func() {
+		h.pushMu.Lock()
+		r.timer = nil
+		h.pushMu.Unlock()
+		h.queuePushMirrorSync(repoName, id)
+	})
+}
+
+// resetPushMirrorRetry cancels the scheduled synchronisation of a mirror and
+// resets its delay.
+func (h *GitHandler) resetPushMirrorRetry(id int32) {
+	h.pushMu.Lock()
+	defer h.pushMu.Unlock()
+
+	if r, ok := h.pushRetries[id]; ok {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		delete(h.pushRetries, id)
+	}
+}
+
+// queuePush adds to the push queue of a repository, starting its worker if it
+// is not running. Pushes queued while the worker is busy are coalesced and
+// sent once it is done, so that pushes to a mirror never overlap.
+func (h *GitHandler) queuePush(repoName string, add func(q *pushQueue)) {
+	h.pushMu.Lock()
+	defer h.pushMu.Unlock()
+
+	if h.ctx.Err() != nil {
+		return
+	}
+
+	q, ok := h.pushQueues[repoName]
+	if !ok {
+		q = newPushQueue()
+		h.pushQueues[repoName] = q
+		h.jobs.Add(1)
+		go h.runPushMirrors(repoName)
+	}
+	add(q)
+}
+
+// runPushMirrors sends the queued pushes of a repository until its queue is
+// empty.
+func (h *GitHandler) runPushMirrors(repoName string) {
+	defer h.jobs.Done()
+
+	for {
+		h.pushMu.Lock()
+		q := h.pushQueues[repoName]
+		if len(q.refs) == 0 && len(q.syncs) == 0 {
+			delete(h.pushQueues, repoName)
+			h.pushMu.Unlock()
+			return
+		}
+		h.pushQueues[repoName] = newPushQueue()
+		h.pushMu.Unlock()
+
+		if h.ctx.Err() != nil {
+			continue
+		}
+		h.pushToMirrors(repoName, q)
+	}
+}
+
+func (h *GitHandler) pushToMirrors(repoName string, q *pushQueue) {
+	mirrors, err := h.ms.ListPushMirrors(h.ctx, repoName)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.Error("failed to list push mirrors", "repo", repoName, "err", err)
+		}
+		return
+	}
+
+	names := make([]plumbing.ReferenceName, 0, len(q.refs))
+	for name := range q.refs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, mirror := range mirrors {
+		full := q.syncs[mirror.ID]
+		if len(names) == 0 && !full {
+			continue
+		}
+		h.runPushMirror(repoName, mirror, names, full)
+	}
+}
+
+// runPushMirror pushes to a mirror, retrying with a backoff if it fails, and
+// records the run. If every attempt fails, a synchronisation of the mirror is
+// scheduled, since the references that were not pushed would otherwise stay
+// out of sync until they change again.
+func (h *GitHandler) runPushMirror(repoName string, mirror pg.PushMirror, names []plumbing.ReferenceName, full bool) {
+	started := time.Now().UTC()
+
+	var (
+		refs     []string
+		err      error
+		attempts int32
+		backoff  = pushMirrorBackoff
+	)
+	for {
+		attempts++
+		refs, err = h.pushMirror(h.ctx, repoName, mirror.Url, names, full)
+		if err == nil || attempts == pushMirrorAttempts {
+			break
+		}
+
+		select {
+		case <-h.ctx.Done():
+		case <-time.After(backoff):
+		}
+		if h.ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+	if err == nil && len(refs) == 0 && !full {
+		return
+	}
+
+	state, msg := jobSucceeded, ""
+	switch {
+	case err != nil:
+		ep, _ := remoteEndpoint(mirror.Url)
+		state, msg = jobFailed, redactError(ep, err)
+		slog.Warn("push to mirror failed", "repo", repoName, "mirror", mirror.ID, "attempts", attempts, "err", msg)
+		h.schedulePushMirrorRetry(repoName, mirror.ID)
+	case full:
+		h.resetPushMirrorRetry(mirror.ID)
+	}
+	if refs == nil {
+		refs = []string{}
+	}
+
+	// The run is recorded even if it was cancelled by Close.
+	_, err = h.ms.RecordPushMirrorRun(context.WithoutCancel(h.ctx), pg.CreatePushMirrorRunParams{
+		MirrorID:  mirror.ID,
+		State:     state,
+		Refs:      refs,
+		Attempts:  attempts,
+		Error:     msg,
+		StartedAt: pgtype.Timestamp{Time: started, Valid: true},
+	})
+	if err != nil {
+		slog.Error("failed to record push mirror run", "repo", repoName, "mirror", mirror.ID, "err", err)
+	}
+}
+
+// pushMirror pushes the current value of names to the remote at url, deleting
+// those the repository no longer has. If full is set, every branch and tag of
+// either side is pushed instead. It returns the references it updated.
+func (h *GitHandler) pushMirror(ctx context.Context, repoName, url string, names []plumbing.ReferenceName, full bool) ([]string, error) {
+	ep, err := h.allowedEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := remoteClient(ep)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := cli.NewReceivePackSession(ep, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	ar, err := sess.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	st := storage.NewStorer(ctx, h.os, h.ms, repoName)
+	if full {
+		if names, err = mirroredNames(st, ar.References); err != nil {
+			return nil, err
+		}
+	}
+
+	req := packp.NewReferenceUpdateRequestFromCapabilities(ar.Capabilities)
+	var wants []plumbing.Hash
+	var refs []string
+	for _, name := range names {
+		cmd := &packp.Command{Name: name, Old: ar.References[name.String()]}
+		ref, err := st.Reference(name)
+		switch {
+		case err == plumbing.ErrReferenceNotFound:
+		case err != nil:
+			return nil, err
+		case ref.Type() == plumbing.HashReference:
+			cmd.New = ref.Hash()
+		default:
+			continue
+		}
+		if cmd.Old == cmd.New {
+			continue
+		}
+		if cmd.Action() == packp.Delete && !ar.Capabilities.Supports(capability.DeleteRefs) {
+			return nil, errDeleteUnsupported
+		}
+
+		req.Commands = append(req.Commands, cmd)
+		refs = append(refs, name.String())
+		if !cmd.New.IsZero() {
+			wants = append(wants, cmd.New)
+		}
+	}
+	if len(req.Commands) == 0 {
+		return nil, nil
+	}
+
+	rs, err := sendPack(ctx, sess, st, req, wants, ar.References)
+	if err != nil {
+		return refs, err
+	}
+	if rs != nil {
+		return refs, rs.Error()
+	}
+	return refs, nil
+}
+
+// mirroredNames returns the branches and tags of st and of a remote.
+func mirroredNames(st *storage.Storer, remote map[string]plumbing.Hash) ([]plumbing.ReferenceName, error) {
+	iter, err := st.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	seen := make(map[plumbing.ReferenceName]bool)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if isMirroredReference(ref.Name()) {
+			seen[ref.Name()] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range remote {
+		if n := plumbing.ReferenceName(name); isMirroredReference(n) {
+			seen[n] = true
+		}
+	}
+
+	names := make([]plumbing.ReferenceName, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// sendPack sends req with a packfile of the objects reachable from wants that
+// the remote, whose references are remote, is missing.
+func sendPack(ctx context.Context, sess transport.ReceivePackSession, st *storage.Storer, req *packp.ReferenceUpdateRequest, wants []plumbing.Hash, remote map[string]plumbing.Hash) (*packp.ReportStatus, error) {
+	if len(wants) == 0 {
+		return sess.ReceivePack(ctx, req)
+	}
+
+	tips := make([]plumbing.Hash, 0, len(remote))
+	for _, h := range remote {
+		tips = append(tips, h)
+	}
+	found, err := hasObjects(st, tips)
+	if err != nil {
+		return nil, err
+	}
+	var haves []plumbing.Hash
+	for h := range found {
+		haves = append(haves, h)
+	}
+
+	hashes, err := objectsToPack(st, wants, haves, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := packfile.NewEncoder(pw, st, false).Encode(hashes, packWindow)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	req.Packfile = pr
+	rs, err := sess.ReceivePack(ctx, req)
+	pr.Close()
+	if encErr := <-done; err == nil && encErr != nil {
+		err = encErr
+	}
+	return rs, err
+}
+
+func writePushMirror(w http.ResponseWriter, mirror pg.PushMirror, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newPushMirror(mirror))
+}
+
+func newPushMirror(mirror pg.PushMirror) PushMirror {
+	resp := PushMirror{
+		ID:        mirror.ID,
+		URL:       mirror.Url,
+		LastState: mirror.LastState,
+		LastError: mirror.LastError,
+		CreatedAt: mirror.CreatedAt.Time,
+	}
+	if ep, err := remoteEndpoint(mirror.Url); err == nil {
+		resp.URL = redactedURL(ep)
+	}
+	if mirror.LastRunAt.Valid {
+		resp.LastRunAt = &mirror.LastRunAt.Time
+	}
+	return resp
+}
+
+func newPushMirrorRun(run pg.PushMirrorRun) PushMirrorRun {
+	return PushMirrorRun{
+		ID:         run.ID,
+		State:      run.State,
+		Refs:       run.Refs,
+		Attempts:   run.Attempts,
+		Error:      run.Error,
+		StartedAt:  run.StartedAt.Time,
+		FinishedAt: run.FinishedAt.Time,
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+)
+
+// Statuses reported for rejected commands, as shown by git next to
+// "[remote rejected]".
+const (
+	statusUnpackFailed = "unpacker error"
+	statusLocked       = "repository is locked by another push, try again later"
+	statusLockFailed   = "failed to lock repository"
+	statusUpdateFailed = "failed to update ref"
+)
+
+var (
+	errStale         = errors.New("stale info")
+	errAlreadyExists = errors.New("already exists")
+	errMissingRef    = errors.New("reference does not exist")
+	errMissingObject = errors.New("missing necessary objects")
+	errInvalidCmd    = errors.New("invalid command")
+	errHiddenRef     = errors.New("deny updating a hidden ref")
+	errMirroredRef   = errors.New("deny updating a mirrored ref")
+)
+
+// receivePack unpacks the packfile of req into the storer, then validates and
+// applies the reference updates while holding the write lock of the
+// repository, so that concurrent pushes to the same repository, possibly
+// handled by different server instances, cannot interleave.
+func (h *GitHandler) receivePack(ctx context.Context, st *storage.Storer, repoName string, req *packp.ReferenceUpdateRequest, pack io.Reader) *packp.ReportStatus {
+	rs := packp.NewReportStatus()
+	rs.UnpackStatus = "ok"
+
+	if pack != nil {
+		err := packfile.UpdateObjectStorage(st, pack)
+		if err == nil {
+			err = st.Flush()
+		}
+		if err != nil {
+			slog.Error("failed to unpack objects", "repo", repoName, "err", err)
+			rs.UnpackStatus = err.Error()
+			setAllStatuses(rs, req, statusUnpackFailed)
+			return rs
+		}
+	}
+
+	unlock, err := h.ms.LockRepository(ctx, repoName, h.opts.PushLockTimeout)
+	if err != nil {
+		slog.Warn("failed to acquire repository lock", "repo", repoName, "err", err)
+		status := statusLockFailed
+		if err == metastore.ErrLockTimeout {
+			status = statusLocked
+		}
+		setAllStatuses(rs, req, status)
+		return rs
+	}
+	defer unlock()
+
+	for _, cmd := range req.Commands {
+		status := "ok"
+		if err := h.validateCommand(ctx, st, repoName, cmd); err != nil {
+			status = err.Error()
+		} else if err := h.applyCommand(ctx, st, repoName, cmd); err != nil {
+			slog.Error("failed to update reference", "repo", repoName, "ref", cmd.Name, "err", err)
+			status = statusUpdateFailed
+		}
+
+		rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        status,
+		})
+	}
+
+	return rs
+}
+
+// validateCommand checks a command against the current value of its
+// reference. Branches and tags of pull mirrors are only updated by
+// synchronisation. It must be called with the repository lock held.
+func (h *GitHandler) validateCommand(ctx context.Context, st *storage.Storer, repoName string, cmd *packp.Command) error {
+	if isHiddenReference(cmd.Name) {
+		return errHiddenRef
+	}
+	if isMirroredReference(cmd.Name) {
+		mirrored, err := h.ms.IsPullMirror(ctx, repoName)
+		if err != nil {
+			return err
+		}
+		if mirrored {
+			return errMirroredRef
+		}
+	}
+
+	current, err := st.Reference(cmd.Name)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return err
+	}
+	exists := err == nil
+
+	switch cmd.Action() {
+	case packp.Create:
+		if exists {
+			return errAlreadyExists
+		}
+	case packp.Delete:
+		if !exists {
+			return errMissingRef
+		}
+		if current.Hash() != cmd.Old {
+			return errStale
+		}
+		return nil
+	case packp.Update:
+		if !exists {
+			return errMissingRef
+		}
+		if current.Hash() != cmd.Old {
+			return errStale
+		}
+	default:
+		return errInvalidCmd
+	}
+
+	if err := st.HasEncodedObject(cmd.New); err != nil {
+		return errMissingObject
+	}
+
+	return nil
+}
+
+// applyCommand updates the reference of a validated command, and the head of
+// the open merge requests of a branch, then queues a push of the reference to
+// the push mirrors. It must be called with the repository lock held.
+func (h *GitHandler) applyCommand(ctx context.Context, st *storage.Storer, repoName string, cmd *packp.Command) error {
+	if cmd.Action() == packp.Delete {
+		if err := st.RemoveReference(cmd.Name); err != nil {
+			return err
+		}
+		h.queueMirrorPush(repoName, cmd.Name)
+		return nil
+	}
+	// The merge request heads are updated first, so that failing to update
+	// them fails the command before the reference has moved.
+	if cmd.Name.IsBranch() {
+		if err := h.updateMergeRequestHeads(ctx, st, repoName, cmd.Name.Short(), cmd.New); err != nil {
+			return err
+		}
+	}
+	if err := st.SetReference(plumbing.NewHashReference(cmd.Name, cmd.New)); err != nil {
+		return err
+	}
+	h.queueMirrorPush(repoName, cmd.Name)
+	return nil
+}
+
+func setAllStatuses(rs *packp.ReportStatus, req *packp.ReferenceUpdateRequest, status string) {
+	for _, cmd := range req.Commands {
+		rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        status,
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+)
+
+var errInvalidTarget = errors.New("branches must point to commits")
+
+// Reference is a branch, a tag or any other reference. Symbolic references
+// have a Target instead of a Hash.
+type Reference struct {
+	Name   string `json:"name"`
+	Hash   string `json:"hash,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Peeled is the object an annotated tag points to. It is only set when
+	// a single reference is requested.
+	Peeled string `json:"peeled,omitempty"`
+}
+
+func newReference(ref *plumbing.Reference) Reference {
+	if ref.Type() == plumbing.SymbolicReference {
+		return Reference{Name: ref.Name().String(), Target: ref.Target().String()}
+	}
+	return Reference{Name: ref.Name().String(), Hash: ref.Hash().String()}
+}
+
+type CreateReferenceRequest struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	// Tag, if set, creates an annotated tag object pointing to Hash, and the
+	// reference points to the tag object.
+	Tag *TagRequest `json:"tag,omitempty"`
+}
+
+type TagRequest struct {
+	Message string `json:"message"`
+	Tagger  struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"tagger"`
+}
+
+type UpdateReferenceRequest struct {
+	Hash string `json:"hash"`
+	// OldHash, if set, is the value the reference must have for the update
+	// to be applied.
+	OldHash string `json:"old_hash,omitempty"`
+}
+
+// ListReferences handles GET /repositories/:id/refs. The "prefix" query
+// parameter filters references by name, and results are paginated with "page"
+// and "per_page".
+func (h *GitHandler) ListReferences(w http.ResponseWriter, r *http.Request, repoName string) {
+	q := r.URL.Query()
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// One more reference is fetched to know if there is a next page.
+	rows, err := h.ms.ListRefsByPrefix(r.Context(), repoName, q.Get("prefix"), int32((page-1)*perPage), int32(perPage+1))
+	if err != nil {
+		slog.Error("failed to list references", "repo", repoName, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	refs := make([]Reference, 0, min(len(rows), perPage))
+	for _, row := range rows[:min(len(rows), perPage)] {
+		if row.Type == metastore.RefTypeSymbolic {
+			refs = append(refs, Reference{Name: row.RefName, Target: row.Target.String})
+		} else {
+			refs = append(refs, Reference{Name: row.RefName, Hash: row.Hash.String})
+		}
+	}
+
+	if len(rows) > perPage {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refs)
+}
+
+// GetReference handles GET /repositories/:id/refs/:name.
+func (h *GitHandler) GetReference(w http.ResponseWriter, r *http.Request, repoName string, name plumbing.ReferenceName) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	ref, err := st.Reference(name)
+	if err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	resp := newReference(ref)
+	if ref.Type() == plumbing.HashReference {
+		peeled, err := peel(st, ref.Hash())
+		if err != nil {
+			h.referenceError(w, repoName, name, err)
+			return
+		}
+		if peeled != ref.Hash() {
+			resp.Peeled = peeled.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CreateReference handles POST /repositories/:id/refs. It fails if the
+// reference already exists.
+func (h *GitHandler) CreateReference(w http.ResponseWriter, r *http.Request, repoName string) {
+	var req CreateReferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := plumbing.ReferenceName(req.Name)
+	if err := validateReferenceName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !plumbing.IsHash(req.Hash) {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	if req.Tag != nil && (!name.IsTag() || req.Tag.Tagger.Name == "" || req.Tag.Tagger.Email == "") {
+		http.Error(w, "annotated tags must be under refs/tags/ and have a tagger name and email", http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	target := plumbing.NewHash(req.Hash)
+	if err := checkTarget(st, name, target); err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	// The command is validated against the tagged object, so that the tag
+	// object is only written once the reference is known to be creatable.
+	cmd := &packp.Command{Name: name, New: target}
+	err := h.withRepositoryLock(r.Context(), repoName, func() error {
+		if err := h.validateCommand(r.Context(), st, repoName, cmd); err != nil {
+			return err
+		}
+		if req.Tag != nil {
+			tag, err := writeTag(st, name, target, req.Tag)
+			if err != nil {
+				return err
+			}
+			cmd.New = tag
+		}
+		return h.applyCommand(r.Context(), st, repoName, cmd)
+	})
+	if err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newReference(plumbing.NewHashReference(name, cmd.New)))
+}
+
+// UpdateReference handles PATCH /repositories/:id/refs/:name.
+func (h *GitHandler) UpdateReference(w http.ResponseWriter, r *http.Request, repoName string, name plumbing.ReferenceName) {
+	if err := validateReferenceName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateReferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !plumbing.IsHash(req.Hash) || (req.OldHash != "" && !plumbing.IsHash(req.OldHash)) {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	cmd := &packp.Command{Name: name, New: plumbing.NewHash(req.Hash)}
+	if err := checkTarget(st, name, cmd.New); err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	err := h.withRepositoryLock(r.Context(), repoName, func() error {
+		if err := setOld(st, cmd, req.OldHash); err != nil {
+			return err
+		}
+		if err := h.validateCommand(r.Context(), st, repoName, cmd); err != nil {
+			return err
+		}
+		return h.applyCommand(r.Context(), st, repoName, cmd)
+	})
+	if err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newReference(plumbing.NewHashReference(name, cmd.New)))
+}
+
+// DeleteReference handles DELETE /repositories/:id/refs/:name. The
+// "old_hash" query parameter is the value the reference must have for it to
+// be deleted.
+func (h *GitHandler) DeleteReference(w http.ResponseWriter, r *http.Request, repoName string, name plumbing.ReferenceName) {
+	if err := validateReferenceName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	oldHash := r.URL.Query().Get("old_hash")
+	if oldHash != "" && !plumbing.IsHash(oldHash) {
+		http.Error(w, "invalid old_hash", http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+
+	cmd := &packp.Command{Name: name}
+	err := h.withRepositoryLock(r.Context(), repoName, func() error {
+		if err := setOld(st, cmd, oldHash); err != nil {
+			return err
+		}
+		if err := h.validateCommand(r.Context(), st, repoName, cmd); err != nil {
+			return err
+		}
+		return h.applyCommand(r.Context(), st, repoName, cmd)
+	})
+	if err != nil {
+		h.referenceError(w, repoName, name, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetDefaultBranch points HEAD to branch, which does not need to exist yet.
+func (h *GitHandler) SetDefaultBranch(ctx context.Context, repoName string, branch plumbing.ReferenceName) error {
+	st := storage.NewStorer(ctx, h.os, h.ms, repoName)
+	return h.withRepositoryLock(ctx, repoName, func() error {
+		return st.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+	})
+}
+
+// withRepositoryLock calls fn while holding the write lock of the repository,
+// which pushes hold too.
+func (h *GitHandler) withRepositoryLock(ctx context.Context, repoName string, fn func() error) error {
+	unlock, err := h.ms.LockRepository(ctx, repoName, h.opts.PushLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// setOld sets the expected old value of cmd to oldHash or, if it is empty, to
+// the current value of the reference.
+func setOld(st *storage.Storer, cmd *packp.Command, oldHash string) error {
+	if oldHash != "" {
+		cmd.Old = plumbing.NewHash(oldHash)
+		return nil
+	}
+
+	current, err := st.Reference(cmd.Name)
+	if err == plumbing.ErrReferenceNotFound {
+		return errMissingRef
+	}
+	if err != nil {
+		return err
+	}
+	cmd.Old = current.Hash()
+	return nil
+}
+
+func validateReferenceName(name plumbing.ReferenceName) error {
+	if !strings.HasPrefix(name.String(), "refs/") || name.Validate() != nil {
+		return fmt.Errorf("invalid reference name: %q", name)
+	}
+	return nil
+}
+
+// checkTarget checks that h exists and, if name is a branch, that it is a
+// commit.
+func checkTarget(st *storage.Storer, name plumbing.ReferenceName, h plumbing.Hash) error {
+	t, _, err := st.EncodedObjectInfo(h)
+	if err == plumbing.ErrObjectNotFound {
+		return errMissingObject
+	}
+	if err != nil {
+		return err
+	}
+	if name.IsBranch() && t != plumbing.CommitObject {
+		return errInvalidTarget
+	}
+	return nil
+}
+
+// writeTag writes an annotated tag object named after the tag reference name
+// and returns its hash.
+func writeTag(st *storage.Storer, name plumbing.ReferenceName, target plumbing.Hash, req *TagRequest) (plumbing.Hash, error) {
+	t, _, err := st.EncodedObjectInfo(target)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	message := req.Message
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	tag := &object.Tag{
+		Name: name.Short(),
+		Tagger: object.Signature{
+			Name:  req.Tagger.Name,
+			Email: req.Tagger.Email,
+			When:  time.Now(),
+		},
+		Message:    message,
+		TargetType: t,
+		Target:     target,
+	}
+
+	obj := st.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	h, err := st.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return h, st.Flush()
+}
+
+// peel returns the object h points to, following annotated tags.
+func peel(st *storage.Storer, h plumbing.Hash) (plumbing.Hash, error) {
+	for {
+		t, _, err := st.EncodedObjectInfo(h)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if t != plumbing.TagObject {
+			return h, nil
+		}
+		tag, err := object.GetTag(st, h)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		h = tag.Target
+	}
+}
+
+func (h *GitHandler) referenceError(w http.ResponseWriter, repoName string, name plumbing.ReferenceName, err error) {
+	switch {
+	case err == plumbing.ErrReferenceNotFound, err == errMissingRef:
+		http.Error(w, "reference not found", http.StatusNotFound)
+	case err == errAlreadyExists:
+		http.Error(w, "reference already exists", http.StatusConflict)
+	case err == errStale:
+		http.Error(w, "reference does not have the expected old value", http.StatusPreconditionFailed)
+	case err == errMissingObject:
+		http.Error(w, "object not found", http.StatusUnprocessableEntity)
+	case err == errInvalidTarget:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case err == errHiddenRef, err == errMirroredRef:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case err == metastore.ErrLockTimeout:
+		http.Error(w, statusLocked, http.StatusServiceUnavailable)
+	default:
+		slog.Error("failed to update reference", "repo", repoName, "ref", name, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
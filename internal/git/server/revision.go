@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var errUnknownRevision = errors.New("unknown revision")
+
+// resolveRevision resolves a commit hash or a full or abbreviated reference
+// name to a commit, following annotated tags.
+func resolveRevision(st storer.Storer, rev string) (*object.Commit, error) {
+	h := plumbing.ZeroHash
+	if plumbing.IsHash(rev) {
+		h = plumbing.NewHash(rev)
+	} else {
+		ref, err := resolveShortReference(st, rev)
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, fmt.Errorf("%w: %s", errUnknownRevision, rev)
+		}
+		if err != nil {
+			return nil, err
+		}
+		h = ref.Hash()
+	}
+
+	c, err := peelToCommit(st, h)
+	if err == plumbing.ErrObjectNotFound || err == object.ErrUnsupportedObject {
+		return nil, fmt.Errorf("%w: %s", errUnknownRevision, rev)
+	}
+	return c, err
+}
+
+// resolveShortReference resolves a full or abbreviated reference name to the
+// hash it points to, keeping its full name.
+func resolveShortReference(st storer.ReferenceStorer, name string) (*plumbing.Reference, error) {
+	for _, rule := range plumbing.RefRevParseRules {
+		full := plumbing.ReferenceName(fmt.Sprintf(rule, name))
+		ref, err := storer.ResolveReference(st, full)
+		if err == nil {
+			return plumbing.NewHashReference(full, ref.Hash()), nil
+		}
+		if err != plumbing.ErrReferenceNotFound {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", plumbing.ErrReferenceNotFound, name)
+}
+
+// resolveRevisionPath splits p, a revision followed by a path, at the first
+// slash after which the prefix resolves, since reference names may contain
+// slashes too. It returns the commit, the revision and the remaining path.
+func resolveRevisionPath(st storer.Storer, p string) (*object.Commit, string, string, error) {
+	p = strings.Trim(p, "/")
+	for i := 0; i <= len(p); i++ {
+		if i < len(p) && p[i] != '/' {
+			continue
+		}
+		rev := p[:i]
+		c, err := resolveRevision(st, rev)
+		if errors.Is(err, errUnknownRevision) {
+			continue
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		return c, rev, strings.TrimPrefix(p[i:], "/"), nil
+	}
+	return nil, "", "", fmt.Errorf("%w: %s", errUnknownRevision, p)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// objectsToPack returns the objects reachable from wants that are not
+// reachable from haves, minus the objects omitted by filter, which may be nil.
+// The parents of commits in boundary are not followed on either side, since
+// they are missing from the client or not meant to be sent.
+func objectsToPack(st storer.EncodedObjectStorer, wants, haves []plumbing.Hash, boundary map[plumbing.Hash]bool, filter *objectFilter) ([]plumbing.Hash, error) {
+	w := &objectWalker{
+		st:       st,
+		boundary: boundary,
+		filter:   filter,
+		excluded: make(map[plumbing.Hash]bool),
+		sent:     make(map[plumbing.Hash]bool),
+		depths:   make(map[plumbing.Hash]int),
+	}
+
+	for _, h := range haves {
+		if err := w.exclude(h); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, h := range wants {
+		if err := w.include(h); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.objects, nil
+}
+
+// checkWants returns an error for the first of wants that is not reachable
+// from a reference of st. Wants pointed to by a reference, as sent by clones
+// and fetches, are accepted without walking the history.
+func checkWants(st storer.Storer, wants []plumbing.Hash) error {
+	pending := make(map[plumbing.Hash]bool, len(wants))
+	for _, h := range wants {
+		pending[h] = true
+	}
+
+	iter, err := st.IterReferences()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var stack []walkItem
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			delete(pending, ref.Hash())
+			stack = append(stack, walkItem{hash: ref.Hash()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := &objectWalker{st: st}
+	seen := make(map[plumbing.Hash]bool)
+	for len(stack) > 0 && len(pending) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if seen[it.hash] {
+			continue
+		}
+		seen[it.hash] = true
+		delete(pending, it.hash)
+
+		if it.blob {
+			continue
+		}
+
+		o, err := object.GetObject(st, it.hash)
+		if err == plumbing.ErrObjectNotFound {
+			// Nothing is reachable through a missing object.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stack = w.references(stack, o, 0)
+	}
+
+	for _, h := range wants {
+		if pending[h] {
+			return fmt.Errorf("upload-pack: not our ref %s", h)
+		}
+	}
+	return nil
+}
+
+type objectWalker struct {
+	st       storer.EncodedObjectStorer
+	boundary map[plumbing.Hash]bool
+	filter   *objectFilter
+	// excluded holds the objects reachable from the haves.
+	excluded map[plumbing.Hash]bool
+	// sent holds the objects added to objects.
+	sent    map[plumbing.Hash]bool
+	objects []plumbing.Hash
+	// depths holds the smallest depth at which a tree or blob was reached,
+	// when filtering by tree depth.
+	depths map[plumbing.Hash]int
+}
+
+type walkItem struct {
+	hash plumbing.Hash
+	// blob is set for tree entries that are blobs, which are never read.
+	blob bool
+	// depth is the depth of a tree or blob below the root tree of a commit,
+	// or -1 for commits, tags and the objects the client asked for.
+	depth int
+}
+
+// exclude marks the objects reachable from h as present on the client.
+func (w *objectWalker) exclude(h plumbing.Hash) error {
+	stack := []walkItem{{hash: h}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if w.excluded[it.hash] {
+			continue
+		}
+		w.excluded[it.hash] = true
+
+		if it.blob {
+			continue
+		}
+
+		o, err := object.GetObject(w.st, it.hash)
+		if err == plumbing.ErrObjectNotFound {
+			// The client may have objects the repository does not.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		stack = w.references(stack, o, 0)
+	}
+
+	return nil
+}
+
+// include adds the objects reachable from h that are neither excluded nor
+// filtered out. h itself is never filtered out.
+func (w *objectWalker) include(h plumbing.Hash) error {
+	stack := []walkItem{{hash: h, depth: -1}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if w.excluded[it.hash] {
+			continue
+		}
+
+		if it.depth >= 0 && w.filter != nil && w.filter.treeDepth >= 0 {
+			// A tree reached again closer to the root may bring entries
+			// that were filtered out the first time.
+			if d, ok := w.depths[it.hash]; ok && d <= it.depth {
+				continue
+			}
+			w.depths[it.hash] = it.depth
+		} else if w.sent[it.hash] {
+			continue
+		}
+
+		if it.blob {
+			omit, err := w.filter.omitsBlob(w.st, it.hash, it.depth)
+			if err != nil {
+				return err
+			}
+			if !omit {
+				w.send(it.hash)
+			}
+			continue
+		}
+
+		if it.depth >= 0 && w.filter.omitsTree(it.depth) {
+			continue
+		}
+
+		o, err := object.GetObject(w.st, it.hash)
+		if err != nil {
+			return err
+		}
+
+		w.send(it.hash)
+		stack = w.references(stack, o, max(it.depth, 0)+1)
+	}
+
+	return nil
+}
+
+func (w *objectWalker) send(h plumbing.Hash) {
+	if !w.sent[h] {
+		w.sent[h] = true
+		w.objects = append(w.objects, h)
+	}
+}
+
+// references pushes the objects referenced by o onto stack. Tree entries are
+// given depth; submodules point into other repositories and are skipped.
+func (w *objectWalker) references(stack []walkItem, o object.Object, depth int) []walkItem {
+	switch o := o.(type) {
+	case *object.Commit:
+		stack = append(stack, walkItem{hash: o.TreeHash, depth: 0})
+		if !w.boundary[o.Hash] {
+			for _, p := range o.ParentHashes {
+				stack = append(stack, walkItem{hash: p, depth: -1})
+			}
+		}
+	case *object.Tree:
+		for _, e := range o.Entries {
+			switch e.Mode {
+			case filemode.Submodule:
+			case filemode.Dir:
+				stack = append(stack, walkItem{hash: e.Hash, depth: depth})
+			default:
+				stack = append(stack, walkItem{hash: e.Hash, blob: true, depth: depth})
+			}
+		}
+	case *object.Tag:
+		stack = append(stack, walkItem{hash: o.Target, depth: -1})
+	}
+	return stack
+}
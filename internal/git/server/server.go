@@ -2,14 +2,19 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/server"
@@ -19,12 +24,78 @@ import (
 )
 
 type GitHandler struct {
-	ms *metastore.MetaStore
-	os *objectstore.ObjectStore
+	ms   *metastore.MetaStore
+	os   *objectstore.ObjectStore
+	opts Options
+
+	// ctx is the context of background jobs, such as imports and mirror
+	// synchronisation, which Close cancels before waiting for them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+
+	// pushQueues holds the pending pushes to the push mirrors of each
+	// repository whose worker is running, and pushRetries the
+	// synchronisations of the push mirrors whose last run failed.
+	pushMu      sync.Mutex
+	pushQueues  map[string]*pushQueue
+	pushRetries map[int32]*pushRetry
 }
 
-func New(ms *metastore.MetaStore, os *objectstore.ObjectStore) *GitHandler {
-	return &GitHandler{ms: ms, os: os}
+type Options struct {
+	// PushLockTimeout is how long a push waits for the write lock of the
+	// repository before it is rejected.
+	PushLockTimeout time.Duration
+	// MirrorInterval is the default interval between two synchronisations of
+	// a pull mirror.
+	MirrorInterval time.Duration
+	// MirrorPollInterval is how often the mirrors that are due are looked up.
+	MirrorPollInterval time.Duration
+	// LocalRemoteRoot is the directory under which local repositories can be
+	// imported, mirrored or pushed to. Local repositories are not allowed if
+	// it is empty.
+	LocalRemoteRoot string
+}
+
+func New(ms *metastore.MetaStore, os *objectstore.ObjectStore, opts Options) *GitHandler {
+	if opts.PushLockTimeout == 0 {
+		opts.PushLockTimeout = 30 * time.Second
+	}
+	if opts.MirrorInterval == 0 {
+		opts.MirrorInterval = time.Hour
+	}
+	if opts.MirrorPollInterval == 0 {
+		opts.MirrorPollInterval = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GitHandler{
+		ms:          ms,
+		os:          os,
+		opts:        opts,
+		ctx:         ctx,
+		cancel:      cancel,
+		pushQueues:  make(map[string]*pushQueue),
+		pushRetries: make(map[int32]*pushRetry),
+	}
+}
+
+// Start starts synchronising pull mirrors in the background.
+func (h *GitHandler) Start() {
+	h.jobs.Add(1)
+	go h.runMirrors()
+}
+
+// Close cancels the background jobs and waits for them to stop.
+func (h *GitHandler) Close() {
+	h.cancel()
+	h.pushMu.Lock()
+	for _, r := range h.pushRetries {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+	}
+	h.pushMu.Unlock()
+	h.jobs.Wait()
 }
 
 type repoLoader struct {
@@ -43,7 +114,7 @@ func (h *GitHandler) InfoRefs(w http.ResponseWriter, r *http.Request, repoName s
 		return
 	}
 
-	storer := storage.NewStorer(h.os, h.ms, repoName)
+	storer := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
 	srv := server.NewServer(&repoLoader{storer: storer})
 	ep, _ := transport.NewEndpoint("/")
 
@@ -73,6 +144,10 @@ func (h *GitHandler) InfoRefs(w http.ResponseWriter, r *http.Request, repoName s
 			slog.Error("failed to get advertised refs", "err", err)
 			return
 		}
+		if err := setUploadPackCapabilities(ar.Capabilities); err != nil {
+			slog.Error("failed to set capabilities", "err", err)
+			return
+		}
 		if err := ar.Encode(w); err != nil {
 			slog.Error("failed to encode refs", "err", err)
 		}
@@ -97,19 +172,20 @@ func (h *GitHandler) InfoRefs(w http.ResponseWriter, r *http.Request, repoName s
 
 // UploadPack handles POST /repositories/:id/git-upload-pack
 func (h *GitHandler) UploadPack(w http.ResponseWriter, r *http.Request, repoName string) {
-	storer := storage.NewStorer(h.os, h.ms, repoName)
-	srv := server.NewServer(&repoLoader{storer: storer})
-	ep, _ := transport.NewEndpoint("/")
+	storer := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	storer.EnablePrefetch()
+	defer storer.Close()
 
-	sess, err := srv.NewUploadPackSession(ep, nil)
+	body, err := requestBody(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer sess.Close()
+	defer body.Close()
 
-	req := packp.NewUploadPackRequest()
-	if err := req.Decode(r.Body); err != nil {
+	s := pktline.NewScanner(body)
+	req, err := decodeUploadRequest(s)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -117,38 +193,37 @@ func (h *GitHandler) UploadPack(w http.ResponseWriter, r *http.Request, repoName
 	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	resp, err := sess.UploadPack(r.Context(), req)
-	if err != nil {
-		slog.Error("upload pack failed", "err", err)
-		// We might need to write error into response stream if session started?
-		return
+	if err := uploadPack(storer, w, req, s); err != nil {
+		slog.Error("upload pack failed", "repo", repoName, "err", err)
 	}
+}
 
-	if err := resp.Encode(w); err != nil {
-		slog.Error("failed to encode upload pack response", "err", err)
+// requestBody returns the body of a git request. Git compresses large
+// requests, such as negotiations with many haves, with gzip.
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return r.Body, nil
 	}
+	return gzip.NewReader(r.Body)
 }
 
 // ReceivePack handles POST /repositories/:id/git-receive-pack
 func (h *GitHandler) ReceivePack(w http.ResponseWriter, r *http.Request, repoName string) {
-	storer := storage.NewStorer(h.os, h.ms, repoName)
-	srv := server.NewServer(&repoLoader{storer: storer})
-	ep, _ := transport.NewEndpoint("/")
+	storer := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
 
-	sess, err := srv.NewReceivePackSession(ep, nil)
+	body, err := requestBody(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer sess.Close()
 
 	// Read entire body to avoid buffering issues with mixed pktline/packfile content
-	bodyBytes, err := io.ReadAll(r.Body)
+	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	r.Body.Close()
+	body.Close()
 
 	// Parse pktlines manually to find the split point between commands and packfile
 	// this is necessary because packp.Decode uses bufio which over-reads into the packfile data.
@@ -198,19 +273,21 @@ func (h *GitHandler) ReceivePack(w http.ResponseWriter, r *http.Request, repoNam
 		slog.Info("packfile peek", "signature", string(bodyBytes[offset:offset+4]))
 	}
 
-	// The rest is the packfile
-	req.Packfile = io.NopCloser(bytes.NewReader(bodyBytes[offset:]))
+	// The rest is the packfile, which is absent when only deleting refs.
+	var pack io.Reader
+	if len(bodyBytes) > offset {
+		pack = bytes.NewReader(bodyBytes[offset:])
+	}
 
 	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	resp, err := sess.ReceivePack(r.Context(), req)
-	if err != nil {
-		slog.Error("receive pack failed", "err", err)
+	rs := h.receivePack(r.Context(), storer, repoName, req, pack)
+	if !req.Capabilities.Supports(capability.ReportStatus) {
 		return
 	}
 
-	if err := resp.Encode(w); err != nil {
+	if err := rs.Encode(w); err != nil {
 		slog.Error("failed to encode receive pack response", "err", err)
 	}
 }
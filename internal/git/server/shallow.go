@@ -0,0 +1,284 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var errNoShallowCommits = errors.New("no commits selected for shallow requests")
+
+// shallowUpdate is the shallow state of the client after an upload-pack
+// request. It is computed for every request from the shallow commits sent by
+// the client; nothing is stored on the server.
+type shallowUpdate struct {
+	// shallows are the commits that become shallow on the client.
+	shallows []plumbing.Hash
+	// unshallows are shallow commits of the client whose parents are sent.
+	unshallows []plumbing.Hash
+	// parents are the parents of the unshallowed commits, which must be sent
+	// even though the client already has the unshallowed commits.
+	parents []plumbing.Hash
+	// boundary holds the commits whose parents are not sent, as seen by the
+	// client: its shallow commits and the new ones.
+	boundary map[plumbing.Hash]bool
+}
+
+// computeShallow computes the shallow boundary requested by req, with the same
+// semantics as git's upload-pack:
+//
+//   - "deepen N" keeps the commits less than N commits away from the wants, or
+//     from the shallow commits of the client with deepen-relative;
+//   - "deepen-since" and "deepen-not" keep the commits that are newer than a
+//     date and not reachable from the given references.
+func computeShallow(st storer.Storer, req *uploadRequest) (*shallowUpdate, error) {
+	su := &shallowUpdate{boundary: make(map[plumbing.Hash]bool)}
+
+	clientShallow := make(map[plumbing.Hash]bool, len(req.shallows))
+	for _, h := range req.shallows {
+		// Unknown shallow commits are ignored, like git does.
+		if _, err := object.GetCommit(st, h); err != nil {
+			continue
+		}
+		clientShallow[h] = true
+		su.boundary[h] = true
+	}
+
+	if !req.deepens() {
+		return su, nil
+	}
+
+	var shallow, notShallow map[plumbing.Hash]bool
+	var err error
+	switch {
+	case req.depth >= infiniteDepth:
+		notShallow = clientShallow
+	case req.depth > 0:
+		heads := req.wants
+		depth := req.depth
+		if req.capabilities.Supports(capability.DeepenRelative) {
+			heads = req.shallows
+			depth++
+		}
+		shallow, notShallow, err = shallowByDepth(st, heads, depth)
+	default:
+		shallow, notShallow, err = shallowByRevList(st, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for h := range shallow {
+		if notShallow[h] {
+			continue
+		}
+		su.boundary[h] = true
+		if !clientShallow[h] {
+			su.shallows = append(su.shallows, h)
+		}
+	}
+
+	for h := range clientShallow {
+		if !notShallow[h] {
+			continue
+		}
+		c, err := object.GetCommit(st, h)
+		if err != nil {
+			return nil, err
+		}
+		su.unshallows = append(su.unshallows, h)
+		su.parents = append(su.parents, c.ParentHashes...)
+	}
+
+	return su, nil
+}
+
+// shallowByDepth walks the history of heads breadth-first. Commits less than
+// depth commits away from a head are not shallow; the ones at depth are.
+func shallowByDepth(st storer.EncodedObjectStorer, heads []plumbing.Hash, depth int) (shallow, notShallow map[plumbing.Hash]bool, err error) {
+	shallow = make(map[plumbing.Hash]bool)
+	notShallow = make(map[plumbing.Hash]bool)
+
+	depths := make(map[plumbing.Hash]int)
+	var queue []*object.Commit
+	for _, h := range heads {
+		c, err := peelToCommit(st, h)
+		if err != nil {
+			continue
+		}
+		if _, ok := depths[c.Hash]; !ok {
+			depths[c.Hash] = 1
+			queue = append(queue, c)
+		}
+	}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		d := depths[c.Hash]
+		if d >= depth {
+			shallow[c.Hash] = true
+			continue
+		}
+		notShallow[c.Hash] = true
+
+		for _, p := range c.ParentHashes {
+			if _, ok := depths[p]; ok {
+				continue
+			}
+			parent, err := object.GetCommit(st, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			depths[p] = d + 1
+			queue = append(queue, parent)
+		}
+	}
+
+	return shallow, notShallow, nil
+}
+
+// shallowByRevList selects the commits reachable from the wants that are
+// newer than deepen-since and not reachable from any deepen-not reference.
+// Selected commits with a parent that was not selected are shallow. A wanted
+// commit is always sent, so one that is not selected becomes shallow itself.
+func shallowByRevList(st storer.Storer, req *uploadRequest) (shallow, notShallow map[plumbing.Hash]bool, err error) {
+	excluded := make(map[plumbing.Hash]bool)
+	for _, name := range req.deepenNot {
+		h, err := resolveDeepenNot(st, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := markAncestors(st, h, excluded); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	selected := func(c *object.Commit) bool {
+		if excluded[c.Hash] {
+			return false
+		}
+		return req.deepenSince.IsZero() || !c.Committer.When.Before(req.deepenSince)
+	}
+
+	shallow = make(map[plumbing.Hash]bool)
+	notShallow = make(map[plumbing.Hash]bool)
+
+	seen := make(map[plumbing.Hash]bool)
+	var stack []*object.Commit
+	var wanted []*object.Commit
+	for _, h := range req.wants {
+		c, err := peelToCommit(st, h)
+		if err != nil {
+			continue
+		}
+		wanted = append(wanted, c)
+		if selected(c) && !seen[c.Hash] {
+			seen[c.Hash] = true
+			stack = append(stack, c)
+		}
+	}
+
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		parents := make([]*object.Commit, 0, len(c.ParentHashes))
+		cut := false
+		for _, p := range c.ParentHashes {
+			parent, err := object.GetCommit(st, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			cut = cut || !selected(parent)
+			parents = append(parents, parent)
+		}
+
+		if cut {
+			shallow[c.Hash] = true
+			continue
+		}
+		notShallow[c.Hash] = true
+
+		for _, parent := range parents {
+			if !seen[parent.Hash] {
+				seen[parent.Hash] = true
+				stack = append(stack, parent)
+			}
+		}
+	}
+
+	if len(seen) == 0 {
+		return nil, nil, errNoShallowCommits
+	}
+
+	for _, c := range wanted {
+		if !seen[c.Hash] {
+			shallow[c.Hash] = true
+		}
+	}
+
+	return shallow, notShallow, nil
+}
+
+// resolveDeepenNot resolves the argument of deepen-not, which may be a full
+// or an abbreviated reference name, like the --shallow-exclude option of git.
+func resolveDeepenNot(st storer.Storer, name string) (plumbing.Hash, error) {
+	for _, rule := range plumbing.RefRevParseRules {
+		ref, err := storer.ResolveReference(st, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == nil {
+			return ref.Hash(), nil
+		}
+	}
+	return plumbing.ZeroHash, fmt.Errorf("git upload-pack: ambiguous deepen-not: %s", name)
+}
+
+// markAncestors adds the commit h points to and all its ancestors to set.
+func markAncestors(st storer.EncodedObjectStorer, h plumbing.Hash, set map[plumbing.Hash]bool) error {
+	c, err := peelToCommit(st, h)
+	if err != nil {
+		return err
+	}
+
+	stack := []plumbing.Hash{c.Hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if set[h] {
+			continue
+		}
+		set[h] = true
+
+		c, err := object.GetCommit(st, h)
+		if err != nil {
+			return err
+		}
+		stack = append(stack, c.ParentHashes...)
+	}
+
+	return nil
+}
+
+// peelToCommit returns the commit h points to, following annotated tags.
+func peelToCommit(st storer.EncodedObjectStorer, h plumbing.Hash) (*object.Commit, error) {
+	for {
+		o, err := object.GetObject(st, h)
+		if err != nil {
+			return nil, err
+		}
+
+		switch o := o.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			h = o.Target
+		default:
+			return nil, object.ErrUnsupportedObject
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
+)
+
+// Commit status states.
+const (
+	statusPending = "pending"
+	statusSuccess = "success"
+	statusFailure = "failure"
+	statusError   = "error"
+)
+
+// defaultStatusContext is the context of statuses reported without one.
+const defaultStatusContext = "default"
+
+// CommitStatus is the state of a commit reported by an external system, e.g.
+// a CI build. Each context holds its own state; the latest status of a
+// context replaces the previous ones.
+type CommitStatus struct {
+	ID          int32     `json:"id"`
+	Commit      string    `json:"commit"`
+	State       string    `json:"state"`
+	Context     string    `json:"context"`
+	Description string    `json:"description,omitempty"`
+	TargetURL   string    `json:"target_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CombinedStatus aggregates the latest status of each context of a commit.
+// State is failure if a context failed or errored, pending if one is pending
+// or there are no statuses, and success otherwise.
+type CombinedStatus struct {
+	Commit   string         `json:"commit"`
+	State    string         `json:"state"`
+	Statuses []CommitStatus `json:"statuses"`
+}
+
+type CreateStatusRequest struct {
+	State       string `json:"state"`
+	Context     string `json:"context,omitempty"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+// CreateStatus handles POST /repositories/:id/statuses/:sha. The commit must
+// exist.
+func (h *GitHandler) CreateStatus(w http.ResponseWriter, r *http.Request, repoName, sha string) {
+	if !plumbing.IsHash(sha) {
+		http.Error(w, "invalid commit hash", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Context == "" {
+		req.Context = defaultStatusContext
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	c, err := object.GetCommit(st, plumbing.NewHash(sha))
+	if err == plumbing.ErrObjectNotFound {
+		http.Error(w, "commit not found", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to read commit", "repo", repoName, "commit", sha, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status, err := h.ms.CreateCommitStatus(r.Context(), pg.CreateCommitStatusParams{
+		RepoName:    repoName,
+		CommitHash:  c.Hash.String(),
+		State:       req.State,
+		Context:     req.Context,
+		Description: req.Description,
+		TargetUrl:   req.TargetURL,
+	})
+	if err != nil {
+		slog.Error("failed to create commit status", "repo", repoName, "commit", sha, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newCommitStatus(status))
+}
+
+// Statuses handles GET /repositories/:id/statuses/:rev, listing every status
+// of the commit rev resolves to, newest first. Results are paginated with
+// "page" and "per_page".
+func (h *GitHandler) Statuses(w http.ResponseWriter, r *http.Request, repoName, rev string) {
+	q := r.URL.Query()
+	page, perPage, err := parsePage(q.Get("page"), q.Get("per_page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c, ok := h.statusCommit(w, r, repoName, rev)
+	if !ok {
+		return
+	}
+
+	// One more status is fetched to know if there is a next page.
+	statuses, err := h.ms.ListCommitStatuses(r.Context(), repoName, c.Hash.String(), int32((page-1)*perPage), int32(perPage+1))
+	if err != nil {
+		slog.Error("failed to list commit statuses", "repo", repoName, "commit", c.Hash, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]CommitStatus, 0, min(len(statuses), perPage))
+	for _, s := range statuses[:min(len(statuses), perPage)] {
+		resp = append(resp, newCommitStatus(s))
+	}
+
+	if len(statuses) > perPage {
+		next := r.URL.Query()
+		next.Set("page", strconv.Itoa(page+1))
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CombinedStatus handles GET /repositories/:id/status/:rev, aggregating the
+// latest status of each context of the commit rev resolves to, e.g. the tip
+// of a branch.
+func (h *GitHandler) CombinedStatus(w http.ResponseWriter, r *http.Request, repoName, rev string) {
+	c, ok := h.statusCommit(w, r, repoName, rev)
+	if !ok {
+		return
+	}
+
+	statuses, err := h.ms.ListLatestCommitStatuses(r.Context(), repoName, c.Hash.String())
+	if err != nil {
+		slog.Error("failed to list commit statuses", "repo", repoName, "commit", c.Hash, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := CombinedStatus{
+		Commit:   c.Hash.String(),
+		State:    combineStatuses(statuses),
+		Statuses: make([]CommitStatus, 0, len(statuses)),
+	}
+	for _, s := range statuses {
+		resp.Statuses = append(resp.Statuses, newCommitStatus(s))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// statusCommit resolves the revision of a status route, writing an error
+// response and returning false if it fails.
+func (h *GitHandler) statusCommit(w http.ResponseWriter, r *http.Request, repoName, rev string) (*object.Commit, bool) {
+	st := storage.NewStorer(r.Context(), h.os, h.ms, repoName)
+	c, err := resolveRevision(st, rev)
+	if errors.Is(err, errUnknownRevision) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("failed to resolve revision", "repo", repoName, "rev", rev, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return c, true
+}
+
+func combineStatuses(statuses []pg.CommitStatus) string {
+	if len(statuses) == 0 {
+		return statusPending
+	}
+
+	state := statusSuccess
+	for _, s := range statuses {
+		switch s.State {
+		case statusFailure, statusError:
+			return statusFailure
+		case statusPending:
+			state = statusPending
+		}
+	}
+	return state
+}
+
+func (req *CreateStatusRequest) validate() error {
+	switch req.State {
+	case statusPending, statusSuccess, statusFailure, statusError:
+	default:
+		return fmt.Errorf("invalid state: %q", req.State)
+	}
+
+	switch {
+	case len(req.Context) > 255:
+		return errors.New("context must be at most 255 bytes")
+	case len(req.Description) > 1024:
+		return errors.New("description must be at most 1024 bytes")
+	}
+
+	if req.TargetURL != "" {
+		u, err := url.Parse(req.TargetURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid target_url: %q", req.TargetURL)
+		}
+	}
+	return nil
+}
+
+func newCommitStatus(s pg.CommitStatus) CommitStatus {
+	return CommitStatus{
+		ID:          s.ID,
+		Commit:      s.CommitHash,
+		State:       s.State,
+		Context:     s.Context,
+		Description: s.Description,
+		TargetURL:   s.TargetUrl,
+		CreatedAt:   s.CreatedAt.Time,
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+var errInvalidPath = errors.New("invalid path")
+
+// treeBuilder edits a tree by path and writes the trees that changed. Trees
+// are only read when a path goes through them.
+type treeBuilder struct {
+	st   storer.EncodedObjectStorer
+	root *treeNode
+}
+
+type treeNode struct {
+	// hash is the tree the node was read from, the zero hash for new trees.
+	hash plumbing.Hash
+	// entries is nil until the tree is read.
+	entries map[string]*treeNodeEntry
+	dirty   bool
+}
+
+type treeNodeEntry struct {
+	mode filemode.FileMode
+	hash plumbing.Hash
+	// dir is set for the directories that were read.
+	dir *treeNode
+}
+
+// newTreeBuilder returns a builder editing the tree root, or an empty tree if
+// root is the zero hash.
+func newTreeBuilder(st storer.EncodedObjectStorer, root plumbing.Hash) *treeBuilder {
+	return &treeBuilder{st: st, root: &treeNode{hash: root}}
+}
+
+func (n *treeNode) load(st storer.EncodedObjectStorer) error {
+	if n.entries != nil {
+		return nil
+	}
+	n.entries = make(map[string]*treeNodeEntry)
+	if n.hash.IsZero() {
+		return nil
+	}
+
+	tree, err := object.GetTree(st, n.hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range tree.Entries {
+		n.entries[e.Name] = &treeNodeEntry{mode: e.Mode, hash: e.Hash}
+	}
+	return nil
+}
+
+// splitPath splits a slash-separated path, rejecting empty, "." and ".."
+// components and the .git directory.
+func splitPath(p string) ([]string, error) {
+	parts := strings.Split(p, "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.EqualFold(part, ".git") {
+			return nil, fmt.Errorf("%w: %q", errInvalidPath, p)
+		}
+	}
+	return parts, nil
+}
+
+// dir returns the node of the directory holding the last component of parts.
+// Missing directories are created if create is set, otherwise nil is
+// returned. The nodes on the way are marked dirty if create is set.
+func (b *treeBuilder) dir(parts []string, create bool) (*treeNode, error) {
+	n := b.root
+	for i, name := range parts[:len(parts)-1] {
+		if err := n.load(b.st); err != nil {
+			return nil, err
+		}
+		if create {
+			n.dirty = true
+		}
+
+		e, ok := n.entries[name]
+		switch {
+		case !ok && !create:
+			return nil, nil
+		case !ok:
+			e = &treeNodeEntry{mode: filemode.Dir, dir: &treeNode{}}
+			n.entries[name] = e
+		case e.mode != filemode.Dir:
+			return nil, fmt.Errorf("%w: %s is not a directory", errInvalidPath, strings.Join(parts[:i+1], "/"))
+		case e.dir == nil:
+			e.dir = &treeNode{hash: e.hash}
+		}
+		n = e.dir
+	}
+
+	if err := n.load(b.st); err != nil {
+		return nil, err
+	}
+	if create {
+		n.dirty = true
+	}
+	return n, nil
+}
+
+// entry returns the entry at p, or nil if there is none.
+func (b *treeBuilder) entry(p string) (*treeNodeEntry, error) {
+	parts, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	n, err := b.dir(parts, false)
+	if err != nil || n == nil {
+		return nil, err
+	}
+	return n.entries[parts[len(parts)-1]], nil
+}
+
+// put sets the entry at p, creating the missing directories.
+func (b *treeBuilder) put(p string, mode filemode.FileMode, h plumbing.Hash) error {
+	parts, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	n, err := b.dir(parts, true)
+	if err != nil {
+		return err
+	}
+
+	name := parts[len(parts)-1]
+	if e, ok := n.entries[name]; ok && e.mode == filemode.Dir && mode != filemode.Dir {
+		return fmt.Errorf("%w: %s is a directory", errInvalidPath, p)
+	}
+	n.entries[name] = &treeNodeEntry{mode: mode, hash: h}
+	return nil
+}
+
+// remove removes the entry at p, if any. Directories left empty are removed
+// when the tree is written, since Git does not store empty trees.
+func (b *treeBuilder) remove(p string) error {
+	parts, err := splitPath(p)
+	if err != nil {
+		return err
+	}
+	n, err := b.dir(parts, false)
+	if err != nil || n == nil {
+		return err
+	}
+	if _, ok := n.entries[parts[len(parts)-1]]; !ok {
+		return nil
+	}
+
+	// Walk again to mark the directories on the way as changed.
+	if n, err = b.dir(parts, true); err != nil {
+		return err
+	}
+	delete(n.entries, parts[len(parts)-1])
+	return nil
+}
+
+// write writes the trees that changed and returns the hash of the root tree.
+func (b *treeBuilder) write() (plumbing.Hash, error) {
+	h, _, err := b.writeNode(b.root)
+	return h, err
+}
+
+// writeNode returns the hash of the tree of n and whether it is empty.
+func (b *treeBuilder) writeNode(n *treeNode) (plumbing.Hash, bool, error) {
+	if !n.dirty {
+		return n.hash, false, nil
+	}
+
+	entries := make([]object.TreeEntry, 0, len(n.entries))
+	for name, e := range n.entries {
+		if e.dir != nil {
+			h, empty, err := b.writeNode(e.dir)
+			if err != nil {
+				return plumbing.ZeroHash, false, err
+			}
+			if empty {
+				continue
+			}
+			e.hash = h
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: e.mode, Hash: e.hash})
+	}
+	if len(entries) == 0 && n != b.root {
+		return plumbing.ZeroHash, true, nil
+	}
+	sort.Sort(object.TreeEntrySorter(entries))
+
+	obj := b.st.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	h, err := b.st.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	n.hash, n.dirty = h, false
+	return h, false, nil
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"path"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/git/merge"
+)
+
+// MergeConflict is a path that could not be merged.
+type MergeConflict struct {
+	Path string `json:"path"`
+	// Type is one of content, add/add, modify/delete, file/directory and
+	// mode.
+	Type string `json:"type"`
+	// Hunks are the conflicting regions of text files.
+	Hunks []merge.Conflict `json:"hunks,omitempty"`
+}
+
+// treeMerger merges trees, writing the merged trees and blobs. Renames are
+// not detected.
+type treeMerger struct {
+	st        storer.EncodedObjectStorer
+	conflicts []MergeConflict
+}
+
+// merge merges the changes from base to theirs into ours and returns the
+// resulting tree. base may be the zero hash when there is no common tree. The
+// result is only meaningful if no conflicts were recorded.
+func (m *treeMerger) merge(base, ours, theirs plumbing.Hash) (plumbing.Hash, error) {
+	h, err := m.mergeTrees("", base, ours, theirs)
+	if err != nil || !h.IsZero() {
+		return h, err
+	}
+
+	// Everything was removed.
+	obj := m.st.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return m.st.SetEncodedObject(obj)
+}
+
+// mergeTrees merges the trees at dir, the zero hash standing for a missing
+// tree, and returns the zero hash if the merged tree is empty.
+func (m *treeMerger) mergeTrees(dir string, base, ours, theirs plumbing.Hash) (plumbing.Hash, error) {
+	if h, ok := mergeValue(base, ours, theirs); ok {
+		return h, nil
+	}
+
+	b, err := m.entries(base)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	o, err := m.entries(ours)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	t, err := m.entries(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	names := make(map[string]bool)
+	for _, entries := range []map[string]*object.TreeEntry{b, o, t} {
+		for name := range entries {
+			names[name] = true
+		}
+	}
+
+	var entries []object.TreeEntry
+	for name := range names {
+		e, err := m.mergeEntry(path.Join(dir, name), b[name], o[name], t[name])
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if e != nil {
+			entries = append(entries, object.TreeEntry{Name: name, Mode: e.Mode, Hash: e.Hash})
+		}
+	}
+	if len(entries) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+	sort.Sort(object.TreeEntrySorter(entries))
+
+	obj := m.st.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return m.st.SetEncodedObject(obj)
+}
+
+func (m *treeMerger) entries(h plumbing.Hash) (map[string]*object.TreeEntry, error) {
+	entries := make(map[string]*object.TreeEntry)
+	if h.IsZero() {
+		return entries, nil
+	}
+
+	tree, err := object.GetTree(m.st, h)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tree.Entries {
+		entries[tree.Entries[i].Name] = &tree.Entries[i]
+	}
+	return entries, nil
+}
+
+// mergeEntry merges the entries at p, nil standing for a missing entry, and
+// returns nil if the path is removed or conflicts.
+func (m *treeMerger) mergeEntry(p string, b, o, t *object.TreeEntry) (*object.TreeEntry, error) {
+	switch {
+	case sameEntry(o, t):
+		return o, nil
+	case sameEntry(b, o):
+		return t, nil
+	case sameEntry(b, t):
+		return o, nil
+	case isTreeOrMissing(o) && isTreeOrMissing(t):
+		h, err := m.mergeTrees(p, treeHash(b), treeHash(o), treeHash(t))
+		if err != nil || h.IsZero() {
+			return nil, err
+		}
+		return &object.TreeEntry{Mode: filemode.Dir, Hash: h}, nil
+	case o == nil || t == nil:
+		m.conflict(p, "modify/delete", nil)
+	case o.Mode == filemode.Dir || t.Mode == filemode.Dir:
+		m.conflict(p, "file/directory", nil)
+	default:
+		return m.mergeFiles(p, b, o, t)
+	}
+	return nil, nil
+}
+
+// mergeFiles merges the content and mode of two versions of a file changed
+// on both sides.
+func (m *treeMerger) mergeFiles(p string, b, o, t *object.TreeEntry) (*object.TreeEntry, error) {
+	kind := "content"
+	var baseMode filemode.FileMode
+	var baseHash plumbing.Hash
+	if b != nil && b.Mode != filemode.Dir {
+		baseMode, baseHash = b.Mode, b.Hash
+	} else {
+		kind = "add/add"
+	}
+
+	h, ok := mergeValue(baseHash, o.Hash, t.Hash)
+	if !ok {
+		if !isFile(o.Mode) || !isFile(t.Mode) {
+			m.conflict(p, kind, nil)
+			return nil, nil
+		}
+
+		var err error
+		var conflicts []merge.Conflict
+		h, conflicts, err = m.mergeBlobs(baseHash, o.Hash, t.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if h.IsZero() {
+			m.conflict(p, kind, conflicts)
+			return nil, nil
+		}
+	}
+
+	mode, ok := mergeValue(baseMode, o.Mode, t.Mode)
+	if !ok {
+		if kind == "content" {
+			kind = "mode"
+		}
+		m.conflict(p, kind, nil)
+		return nil, nil
+	}
+
+	return &object.TreeEntry{Mode: mode, Hash: h}, nil
+}
+
+// mergeBlobs merges text files and returns the merged blob, or the zero hash
+// and the conflicting regions if they cannot be merged. Binary files never
+// merge.
+func (m *treeMerger) mergeBlobs(base, ours, theirs plumbing.Hash) (plumbing.Hash, []merge.Conflict, error) {
+	var contents [3][]byte
+	for i, h := range []plumbing.Hash{base, ours, theirs} {
+		if h.IsZero() {
+			continue
+		}
+		content, err := readBlob(m.st, h)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+		if isBinary(content) {
+			return plumbing.ZeroHash, nil, nil
+		}
+		contents[i] = content
+	}
+
+	merged, conflicts := merge.Text(contents[0], contents[1], contents[2])
+	if len(conflicts) > 0 {
+		return plumbing.ZeroHash, conflicts, nil
+	}
+	h, err := writeBlob(m.st, merged)
+	return h, nil, err
+}
+
+func (m *treeMerger) conflict(p, kind string, hunks []merge.Conflict) {
+	m.conflicts = append(m.conflicts, MergeConflict{Path: p, Type: kind, Hunks: hunks})
+}
+
+// pick applies the changes of c relative to its parent, numbered from 1, on
+// top of onto, like git cherry-pick. It returns nil if the changes are already
+// in onto or conflict.
+func (m *treeMerger) pick(onto, c *object.Commit, parent int, committer object.Signature) (*object.Commit, error) {
+	var base plumbing.Hash
+	if len(c.ParentHashes) > 0 {
+		p, err := object.GetCommit(m.st, c.ParentHashes[parent-1])
+		if err != nil {
+			return nil, err
+		}
+		base = p.TreeHash
+	}
+
+	tree, err := m.merge(base, onto.TreeHash, c.TreeHash)
+	if err != nil || len(m.conflicts) > 0 || tree == onto.TreeHash {
+		return nil, err
+	}
+
+	picked := &object.Commit{
+		Author:       c.Author,
+		Committer:    committer,
+		Message:      c.Message,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{onto.Hash},
+	}
+	return picked, writeCommit(m.st, picked)
+}
+
+// mergeValue merges a value changed on one side or on both sides alike.
+func mergeValue[T comparable](base, ours, theirs T) (T, bool) {
+	switch {
+	case ours == theirs, base == theirs:
+		return ours, true
+	case base == ours:
+		return theirs, true
+	}
+	return ours, false
+}
+
+func sameEntry(a, b *object.TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.Hash == b.Hash
+}
+
+func isTreeOrMissing(e *object.TreeEntry) bool {
+	return e == nil || e.Mode == filemode.Dir
+}
+
+// treeHash returns the hash of e if it is a tree, or the zero hash.
+func treeHash(e *object.TreeEntry) plumbing.Hash {
+	if e == nil || e.Mode != filemode.Dir {
+		return plumbing.ZeroHash
+	}
+	return e.Hash
+}
+
+func isFile(mode filemode.FileMode) bool {
+	return mode == filemode.Regular || mode == filemode.Executable || mode == filemode.Deprecated
+}
+
+// isBinary reports whether content looks binary the way Git decides it: it
+// has a NUL byte in its first 8000 bytes.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// infiniteDepth is the depth sent by "git fetch --unshallow".
+const infiniteDepth = 0x7fffffff
+
+// packWindow is the number of objects considered as delta bases for each
+// object of a packfile.
+const packWindow = 10
+
+var errNoWants = errors.New("upload-pack request has no wants")
+
+// uploadRequest is the first section of an upload-pack request: the wants,
+// the shallow commits of the client, how the history should be deepened and
+// which objects should be filtered out.
+// The negotiation (haves) that follows is read by uploadPack.
+type uploadRequest struct {
+	wants        []plumbing.Hash
+	capabilities *capability.List
+	shallows     []plumbing.Hash
+	depth        int
+	deepenSince  time.Time
+	deepenNot    []string
+	filter       *objectFilter
+}
+
+// deepens reports whether the request asks for a shallow update.
+func (r *uploadRequest) deepens() bool {
+	return r.depth > 0 || !r.deepenSince.IsZero() || len(r.deepenNot) > 0
+}
+
+// setUploadPackCapabilities adds the capabilities implemented by uploadPack to
+// an advertisement.
+func setUploadPackCapabilities(c *capability.List) error {
+	for _, cap := range []capability.Capability{
+		capability.MultiACKDetailed,
+		capability.Shallow,
+		capability.DeepenSince,
+		capability.DeepenNot,
+		capability.DeepenRelative,
+		capability.Filter,
+		// Any object reachable from a reference can be wanted, so that
+		// partial clones can fetch the objects that were filtered out.
+		capability.AllowTipSHA1InWant,
+		capability.AllowReachableSHA1InWant,
+	} {
+		if err := c.Set(cap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeUploadRequest reads the wants, shallows, deepen and filter lines of a
+// request, up to the flush-pkt that ends them.
+func decodeUploadRequest(s *pktline.Scanner) (*uploadRequest, error) {
+	req := &uploadRequest{capabilities: capability.NewList()}
+
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+		if len(line) == 0 {
+			if len(req.wants) == 0 {
+				return nil, errNoWants
+			}
+			if req.depth > 0 && (!req.deepenSince.IsZero() || len(req.deepenNot) > 0) {
+				return nil, errors.New("deepen and deepen-since (or deepen-not) cannot be used together")
+			}
+			return req, nil
+		}
+
+		verb, arg, _ := bytes.Cut(line, []byte(" "))
+		switch string(verb) {
+		case "want":
+			hash, caps, _ := bytes.Cut(arg, []byte(" "))
+			h, err := parseHash(hash)
+			if err != nil {
+				return nil, err
+			}
+			if len(req.wants) == 0 && len(caps) > 0 {
+				if err := req.capabilities.Decode(caps); err != nil {
+					return nil, err
+				}
+			}
+			req.wants = append(req.wants, h)
+		case "shallow":
+			h, err := parseHash(arg)
+			if err != nil {
+				return nil, err
+			}
+			req.shallows = append(req.shallows, h)
+		case "deepen":
+			n, err := strconv.Atoi(string(arg))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid deepen: %q", arg)
+			}
+			req.depth = n
+		case "deepen-since":
+			secs, err := strconv.ParseInt(string(arg), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deepen-since: %q", arg)
+			}
+			req.deepenSince = time.Unix(secs, 0)
+		case "deepen-not":
+			req.deepenNot = append(req.deepenNot, string(arg))
+		case "filter":
+			f, err := parseFilter(string(arg))
+			if err != nil {
+				return nil, err
+			}
+			req.filter = f
+		default:
+			return nil, fmt.Errorf("unexpected line in upload-pack request: %q", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func parseHash(b []byte) (plumbing.Hash, error) {
+	if !plumbing.IsHash(string(b)) {
+		return plumbing.ZeroHash, fmt.Errorf("invalid object name: %q", b)
+	}
+	return plumbing.NewHash(string(b)), nil
+}
+
+// uploadPack serves an upload-pack request over the stateless HTTP protocol:
+// it sends the shallow update, acknowledges the haves of the client and, once
+// the client is done negotiating, sends the packfile.
+//
+// Only the multi_ack_detailed negotiation is advertised, but requests without
+// it, as sent by go-git, are answered like git's upload-pack does.
+func uploadPack(st storer.Storer, w io.Writer, req *uploadRequest, s *pktline.Scanner) error {
+	e := pktline.NewEncoder(w)
+
+	// Checking the wants and negotiating only read commits and trees, and
+	// the blobs a filter omits are never read.
+	setPrefetchBlobs(st, false)
+	if err := checkWants(st, req.wants); err != nil {
+		_ = e.Encodef("ERR %s\n", err)
+		return err
+	}
+
+	su, err := computeShallow(st, req)
+	if err != nil {
+		_ = e.Encodef("ERR %s\n", err)
+		return err
+	}
+
+	if req.deepens() {
+		for _, h := range su.shallows {
+			if err := e.Encodef("shallow %s\n", h); err != nil {
+				return err
+			}
+		}
+		for _, h := range su.unshallows {
+			if err := e.Encodef("unshallow %s\n", h); err != nil {
+				return err
+			}
+		}
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+
+	n := &negotiation{
+		st:       st,
+		e:        e,
+		wants:    req.wants,
+		multiAck: req.capabilities.Supports(capability.MultiACKDetailed),
+	}
+
+	done, err := n.run(s)
+	if err != nil || !done {
+		return err
+	}
+
+	setPrefetchBlobs(st, req.filter == nil)
+	haves := append(n.common, su.unshallows...)
+	wants := append(append([]plumbing.Hash(nil), req.wants...), su.parents...)
+
+	hashes, err := objectsToPack(st, wants, haves, su.boundary, req.filter)
+	if err != nil {
+		_ = e.Encodef("ERR %s\n", err)
+		return err
+	}
+
+	_, err = packfile.NewEncoder(w, st, false).Encode(hashes, packWindow)
+	return err
+}
+
+// negotiation finds the commits the client has in common with the server.
+// With the stateless protocol every request carries all the common commits
+// found so far, so nothing is kept between requests.
+type negotiation struct {
+	st       storer.Storer
+	e        *pktline.Encoder
+	wants    []plumbing.Hash
+	multiAck bool
+	common   []plumbing.Hash
+}
+
+// run answers the haves of a request. It returns true once the client sent
+// "done" and is waiting for the packfile.
+func (n *negotiation) run(s *pktline.Scanner) (bool, error) {
+	var haves []plumbing.Hash
+
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+
+		if h, ok := bytes.CutPrefix(line, []byte("have ")); ok {
+			hash, err := parseHash(h)
+			if err != nil {
+				return false, err
+			}
+			haves = append(haves, hash)
+			continue
+		}
+
+		if err := n.ack(haves); err != nil {
+			return false, err
+		}
+		haves = nil
+
+		switch {
+		case len(line) == 0:
+			if err := n.flush(); err != nil {
+				return false, err
+			}
+		case string(line) == "done":
+			return true, n.done()
+		default:
+			return false, fmt.Errorf("unexpected line in upload-pack negotiation: %q", line)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+	return false, n.ack(haves)
+}
+
+// ack acknowledges the haves that are present in the repository. They are
+// looked up in a single batch when the storer supports it.
+func (n *negotiation) ack(haves []plumbing.Hash) error {
+	if len(haves) == 0 {
+		return nil
+	}
+
+	found, err := hasObjects(n.st, haves)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range haves {
+		if !found[h] {
+			continue
+		}
+		n.common = append(n.common, h)
+
+		switch {
+		case n.multiAck:
+			err = n.e.Encodef("ACK %s common\n", h)
+		case len(n.common) == 1:
+			err = n.e.Encodef("ACK %s\n", h)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *negotiation) flush() error {
+	if n.multiAck && len(n.common) > 0 && n.readyToGiveUp() {
+		if err := n.e.Encodef("ACK %s ready\n", n.common[len(n.common)-1]); err != nil {
+			return err
+		}
+	}
+	if len(n.common) == 0 || n.multiAck {
+		return n.e.Encodef("NAK\n")
+	}
+	return nil
+}
+
+func (n *negotiation) done() error {
+	if len(n.common) == 0 {
+		return n.e.Encodef("NAK\n")
+	}
+	if n.multiAck {
+		return n.e.Encodef("ACK %s\n", n.common[len(n.common)-1])
+	}
+	return nil
+}
+
+// readyToGiveUp reports whether every wanted commit has a common commit in its
+// history, in which case more haves would not make the packfile smaller.
+// Commits older than the oldest common commit are not visited.
+func (n *negotiation) readyToGiveUp() bool {
+	common := make(map[plumbing.Hash]bool, len(n.common))
+	var oldest time.Time
+	for _, h := range n.common {
+		common[h] = true
+		if c, err := object.GetCommit(n.st, h); err == nil {
+			if oldest.IsZero() || c.Committer.When.Before(oldest) {
+				oldest = c.Committer.When
+			}
+		}
+	}
+
+	for _, want := range n.wants {
+		c, err := peelToCommit(n.st, want)
+		if err != nil {
+			return false
+		}
+		if !reachesAny(n.st, c, common, oldest) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func reachesAny(st storer.EncodedObjectStorer, from *object.Commit, targets map[plumbing.Hash]bool, cutoff time.Time) bool {
+	seen := map[plumbing.Hash]bool{from.Hash: true}
+	queue := []*object.Commit{from}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+
+		if targets[c.Hash] {
+			return true
+		}
+		if c.Committer.When.Before(cutoff) {
+			continue
+		}
+
+		for _, p := range c.ParentHashes {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			parent, err := object.GetCommit(st, p)
+			if err != nil {
+				continue
+			}
+			queue = append(queue, parent)
+		}
+	}
+
+	return false
+}
+
+// setPrefetchBlobs sets whether st prefetches blobs, if it prefetches objects.
+func setPrefetchBlobs(st storer.Storer, enabled bool) {
+	if p, ok := st.(interface{ PrefetchBlobs(bool) }); ok {
+		p.PrefetchBlobs(enabled)
+	}
+}
+
+// hasObjects returns which of hashes are present in st.
+func hasObjects(st storer.EncodedObjectStorer, hashes []plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	found := make(map[plumbing.Hash]bool, len(hashes))
+
+	if bs, ok := st.(interface {
+		HasEncodedObjects([]plumbing.Hash) ([]plumbing.Hash, error)
+	}); ok {
+		present, err := bs.HasEncodedObjects(hashes)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range present {
+			found[h] = true
+		}
+		return found, nil
+	}
+
+	for _, h := range hashes {
+		err := st.HasEncodedObject(h)
+		if err == nil {
+			found[h] = true
+		} else if err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+	}
+	return found, nil
+}
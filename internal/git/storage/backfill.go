@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/objectstore"
+)
+
+// BackfillObjects brings the loose objects of a repository written by older
+// versions of the server up to date: it records the type and size metadata on
+// objects that lack it and adds missing entries to the object index. Only the
+// object headers are read. It returns the number of objects that were updated.
+func BackfillObjects(ctx context.Context, os *objectstore.ObjectStore, ms *metastore.MetaStore, repoName string) (int, error) {
+	s := newObjectStorage(ctx, os, ms, repoName)
+
+	prefix := fmt.Sprintf("repositories/%s/objects/", repoName)
+	keys, err := os.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return updated, err
+		}
+
+		hash := path.Base(key)
+		_, err := ms.GetObject(ctx, repoName, hash)
+		indexed := err == nil
+		if err != nil && !errors.Is(err, metastore.ErrNotFound) {
+			return updated, fmt.Errorf("failed to look up %s in the object index: %w", hash, err)
+		}
+
+		md, err := os.HeadMetadata(ctx, key)
+		if err != nil {
+			return updated, fmt.Errorf("failed to read metadata of %s: %w", key, err)
+		}
+
+		t, size, ok := parseObjectMetadata(md)
+		if ok && indexed {
+			continue
+		}
+
+		if !ok {
+			t, size, err = s.readObjectHeader(ctx, key)
+			if err != nil {
+				return updated, fmt.Errorf("failed to read header of %s: %w", key, err)
+			}
+
+			if err := os.SetMetadata(ctx, key, objectMetadata(t, size)); err != nil {
+				return updated, fmt.Errorf("failed to set metadata of %s: %w", key, err)
+			}
+		}
+
+		if !indexed {
+			if err := ms.PutObject(ctx, repoName, hash, t.String(), size, key); err != nil {
+				return updated, fmt.Errorf("failed to index %s: %w", hash, err)
+			}
+		}
+
+		slog.Debug("backfilled object", "repo", repoName, "hash", hash, "type", t, "size", size)
+		updated++
+	}
+
+	return updated, nil
+}
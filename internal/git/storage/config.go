@@ -11,13 +11,14 @@ import (
 )
 
 type ConfigStorage struct {
+	ctx      context.Context
 	os       *objectstore.ObjectStore
 	repoName string
 }
 
 func (s *ConfigStorage) Config() (*config.Config, error) {
 	key := fmt.Sprintf("repositories/%s/config", s.repoName)
-	rc, err := s.os.Get(context.Background(), key)
+	rc, err := s.os.Get(s.ctx, key)
 	if err != nil {
 		// If config doesn't exist, return new empty config
 		return config.NewConfig(), nil
@@ -44,5 +45,5 @@ func (s *ConfigStorage) SetConfig(c *config.Config) error {
 	}
 
 	key := fmt.Sprintf("repositories/%s/config", s.repoName)
-	return s.os.Put(context.Background(), key, bytes.NewReader(content))
+	return s.os.Put(s.ctx, key, bytes.NewReader(content))
 }
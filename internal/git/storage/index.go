@@ -11,6 +11,7 @@ import (
 )
 
 type IndexStorage struct {
+	ctx      context.Context
 	os       *objectstore.ObjectStore
 	repoName string
 }
@@ -23,12 +24,12 @@ func (s *IndexStorage) SetIndex(idx *index.Index) error {
 	}
 
 	key := fmt.Sprintf("repositories/%s/index", s.repoName)
-	return s.os.Put(context.Background(), key, &buf)
+	return s.os.Put(s.ctx, key, &buf)
 }
 
 func (s *IndexStorage) Index() (*index.Index, error) {
 	key := fmt.Sprintf("repositories/%s/index", s.repoName)
-	rc, err := s.os.Get(context.Background(), key)
+	rc, err := s.os.Get(s.ctx, key)
 	if err != nil {
 		// If no index, return empty index? Or error?
 		// go-git usually expects an index if not bare.
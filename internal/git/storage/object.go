@@ -1,157 +1,362 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
 	"github.com/npclaudiu/git-server-poc/internal/objectstore"
 )
 
+const (
+	// Metadata keys recorded on every loose object at write time, so that the
+	// type and size of an object can be answered with a HEAD request.
+	metadataType = "git-type"
+	metadataSize = "git-size"
+
+	// maxHeaderLen bounds the length of a loose object header ("type size\0").
+	maxHeaderLen = 64
+
+	// iterBatchSize is the number of index entries fetched per query while
+	// iterating over the objects of a repository.
+	iterBatchSize = 1000
+)
+
+// ObjectStorage stores object content in the object store and keeps an index
+// of every object (type, size and location) in the metastore, which answers
+// existence, size and enumeration queries without touching S3.
+//
+// Writes are uploaded in the background by a bounded pool of workers; Flush
+// waits for them to complete.
+//
+// Objects missing from the repository are looked up in its alternates, the
+// repositories it was forked from, which are loaded from the metastore on
+// first use.
 type ObjectStorage struct {
+	ctx      context.Context
 	os       *objectstore.ObjectStore
+	ms       *metastore.MetaStore
 	repoName string
+	uploads  *uploadQueue
+	prefetch *prefetcher
+
+	mu         sync.Mutex
+	alternates []string
+	loaded     bool
+}
+
+func newObjectStorage(ctx context.Context, os *objectstore.ObjectStore, ms *metastore.MetaStore, repoName string) *ObjectStorage {
+	return &ObjectStorage{
+		ctx:      ctx,
+		os:       os,
+		ms:       ms,
+		repoName: repoName,
+		uploads:  newUploadQueue(os.UploadConcurrency()),
+	}
+}
+
+func (s *ObjectStorage) objectKey(h plumbing.Hash) string {
+	return objectKey(s.repoName, h)
+}
+
+func objectKey(repoName string, h plumbing.Hash) string {
+	return fmt.Sprintf("repositories/%s/objects/%s", repoName, h.String())
 }
 
 func (s *ObjectStorage) NewEncodedObject() plumbing.EncodedObject {
 	return &plumbing.MemoryObject{}
 }
 
+// SetEncodedObject schedules obj for upload and returns as soon as a worker
+// has picked it up. The object can be read back immediately, but it is only
+// durable once Flush has returned without error.
 func (s *ObjectStorage) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	if err := s.uploads.enqueue(obj, s.putEncodedObject); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return obj.Hash(), nil
+}
+
+// Flush waits until every object passed to SetEncodedObject has been uploaded
+// and indexed, and returns the first upload error.
+func (s *ObjectStorage) Flush() error {
+	return s.uploads.wait()
+}
+
+// putEncodedObject uploads obj as a loose object and records it in the object
+// index.
+func (s *ObjectStorage) putEncodedObject(obj plumbing.EncodedObject) error {
 	r, err := obj.Reader()
 	if err != nil {
-		return plumbing.ZeroHash, err
+		return err
 	}
 	defer r.Close()
 
 	h := obj.Hash()
-	key := fmt.Sprintf("repositories/%s/objects/%s", s.repoName, h.String())
+	key := s.objectKey(h)
 
 	// Standard Git loose object header: "type size\0"
 	header := fmt.Sprintf("%s %d\000", obj.Type(), obj.Size())
 	mr := io.MultiReader(strings.NewReader(header), r)
 
-	if err := s.os.Put(context.Background(), key, mr); err != nil {
-		return plumbing.ZeroHash, err
+	ctx := s.ctx
+	if err := s.os.PutWithMetadata(ctx, key, mr, objectMetadata(obj.Type(), obj.Size())); err != nil {
+		return err
 	}
 
-	return h, nil
+	// The index entry is only written once the content is durable, so an
+	// indexed object can always be read back.
+	return s.ms.PutObject(ctx, s.repoName, h.String(), obj.Type().String(), obj.Size(), key)
 }
 
 func (s *ObjectStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
-	key := fmt.Sprintf("repositories/%s/objects/%s", s.repoName, h.String())
-	rc, err := s.os.Get(context.Background(), key)
+	if obj, ok := s.uploads.get(h); ok {
+		return obj, nil
+	}
+
+	if s.prefetch != nil {
+		return s.prefetch.get(h)
+	}
+
+	return s.loadEncodedObject(h)
+}
+
+// EnablePrefetch makes reads of commits and trees speculatively fetch the
+// objects they reference in parallel. It is meant for read-heavy requests such
+// as upload-pack, and must be paired with Close.
+func (s *ObjectStorage) EnablePrefetch() {
+	if s.prefetch == nil {
+		s.prefetch = newPrefetcher(s.loadEncodedObject)
+	}
+}
+
+// PrefetchBlobs sets whether the blobs of the trees that are read are
+// prefetched, which they are by default. It has no effect unless prefetching
+// is enabled.
+func (s *ObjectStorage) PrefetchBlobs(enabled bool) {
+	if s.prefetch != nil {
+		s.prefetch.noBlobs.Store(!enabled)
+	}
+}
+
+// Close releases the resources held for the request, such as the prefetch
+// buffer.
+func (s *ObjectStorage) Close() error {
+	if s.prefetch != nil {
+		s.prefetch.close()
+	}
+	return nil
+}
+
+// loadEncodedObject reads an object from the object store.
+func (s *ObjectStorage) loadEncodedObject(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	rc, err := s.openObject(h)
 	if err != nil {
-		return nil, plumbing.ErrObjectNotFound
+		return nil, err
 	}
 	defer rc.Close()
 
-	// Read header
-	// We need to read byte by byte until null.
-	// Since we can't seek or peek easily on s3 stream without buffering,
-	// we'll read a small chunk or byte by byte.
-
 	// Quick hack: Read entire object into memory.
 	content, err := io.ReadAll(rc)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find null byte
-	nullIdx := -1
-	for i, b := range content {
-		if b == 0 {
-			nullIdx = i
-			break
+	objType, size, n, err := parseObjectHeader(content)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &plumbing.MemoryObject{}
+	o.SetType(objType)
+	o.SetSize(size)
+
+	// Write content
+	if _, err := o.Write(content[n:]); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// openObject opens the loose object h of the repository or, failing that, of
+// the nearest alternate that has it. In a fork, the object index tells which
+// repository stores h, and only objects missing from it are looked up by key
+// in each repository.
+func (s *ObjectStorage) openObject(h plumbing.Hash) (io.ReadCloser, error) {
+	alternates, err := s.Alternates()
+	if err != nil {
+		return nil, err
+	}
+	if len(alternates) > 0 {
+		entry, err := s.ms.GetObject(s.ctx, s.repoName, h.String())
+		if errors.Is(err, metastore.ErrNotFound) {
+			entry, err = s.findShared(h)
+		}
+		if err == nil && entry.LooseKey.Valid {
+			rc, err := s.os.Get(s.ctx, entry.LooseKey.String)
+			if err != nil {
+				return nil, s.notFound(err)
+			}
+			return rc, nil
+		}
+		if err != nil && err != plumbing.ErrObjectNotFound {
+			return nil, err
 		}
 	}
 
+	rc, err := s.os.Get(s.ctx, s.objectKey(h))
+	if err == nil {
+		return rc, nil
+	}
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	for _, name := range alternates {
+		if rc, err = s.os.Get(s.ctx, objectKey(name, h)); err == nil {
+			return rc, nil
+		}
+	}
+	return nil, s.notFound(err)
+}
+
+// notFound maps a failed object store read to plumbing.ErrObjectNotFound,
+// unless the read failed because the request was cancelled or timed out.
+func (s *ObjectStorage) notFound(err error) error {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return plumbing.ErrObjectNotFound
+}
+
+// parseObjectHeader parses the "type size\0" header at the start of a loose
+// object and returns the object type, its size and the length of the header.
+func parseObjectHeader(content []byte) (plumbing.ObjectType, int64, int, error) {
+	nullIdx := bytes.IndexByte(content, 0)
 	if nullIdx == -1 {
-		return nil, fmt.Errorf("invalid object format: no header")
+		return plumbing.InvalidObject, 0, 0, fmt.Errorf("invalid object format: no header")
 	}
 
-	header := string(content[:nullIdx])
-	parts := strings.Split(header, " ")
+	parts := strings.Split(string(content[:nullIdx]), " ")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid header format")
+		return plumbing.InvalidObject, 0, 0, fmt.Errorf("invalid header format")
 	}
 
 	objType, err := plumbing.ParseObjectType(parts[0])
 	if err != nil {
-		return nil, err
+		return plumbing.InvalidObject, 0, 0, err
 	}
 
-	size := int64(0) // parse parts[1]
-	fmt.Sscanf(parts[1], "%d", &size)
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return plumbing.InvalidObject, 0, 0, fmt.Errorf("invalid object size: %w", err)
+	}
 
-	o := &plumbing.MemoryObject{}
-	o.SetType(objType)
-	o.SetSize(size)
+	return objType, size, nullIdx + 1, nil
+}
 
-	// Write content
-	if _, err := o.Write(content[nullIdx+1:]); err != nil {
-		return nil, err
+func objectMetadata(t plumbing.ObjectType, size int64) map[string]string {
+	return map[string]string{
+		metadataType: t.String(),
+		metadataSize: strconv.FormatInt(size, 10),
 	}
+}
 
-	return o, nil
+// parseObjectMetadata extracts the object type and size from metadata written
+// by SetEncodedObject. ok is false for objects stored without it.
+func parseObjectMetadata(md map[string]string) (t plumbing.ObjectType, size int64, ok bool) {
+	t, err := plumbing.ParseObjectType(md[metadataType])
+	if err != nil {
+		return plumbing.InvalidObject, 0, false
+	}
+
+	size, err = strconv.ParseInt(md[metadataSize], 10, 64)
+	if err != nil {
+		return plumbing.InvalidObject, 0, false
+	}
+
+	return t, size, true
 }
 
-func (s *ObjectStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
-	prefix := fmt.Sprintf("repositories/%s/objects/", s.repoName)
-	keys, err := s.os.List(context.Background(), prefix)
+// readObjectHeader fetches only the leading bytes of a loose object and
+// parses its header.
+func (s *ObjectStorage) readObjectHeader(ctx context.Context, key string) (plumbing.ObjectType, int64, error) {
+	rc, err := s.os.GetRange(ctx, key, 0, maxHeaderLen)
 	if err != nil {
-		return nil, err
+		return plumbing.InvalidObject, 0, s.notFound(err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	t, size, _, err := parseObjectHeader(content)
+	return t, size, err
+}
+
+// IterEncodedObjects iterates over the objects stored in the repository
+// itself, not over those shared with its alternates.
+func (s *ObjectStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	typeName := ""
+	if t != plumbing.AnyObject {
+		typeName = t.String()
 	}
 
 	return &EncodedObjectIter{
-		s:    s,
-		t:    t,
-		keys: keys,
-		pos:  0,
+		s:        s,
+		t:        t,
+		typeName: typeName,
 	}, nil
 }
 
+// EncodedObjectIter pages through the object index in hash order and loads
+// each object lazily.
 type EncodedObjectIter struct {
-	s    *ObjectStorage
-	t    plumbing.ObjectType
-	keys []string
-	pos  int
+	s        *ObjectStorage
+	t        plumbing.ObjectType
+	typeName string
+	batch    []pg.Object
+	pos      int
+	after    string
+	done     bool
 }
 
 func (iter *EncodedObjectIter) Next() (plumbing.EncodedObject, error) {
-	for iter.pos < len(iter.keys) {
-		key := iter.keys[iter.pos]
-		iter.pos++
-
-		// Key format: repositories/<repo>/objects/<hash>
-		parts := strings.Split(key, "/")
-		if len(parts) == 0 {
-			continue
-		}
-		hashStr := parts[len(parts)-1]
-		if hashStr == "" {
-			continue
+	if iter.pos == len(iter.batch) {
+		if iter.done {
+			return nil, io.EOF
 		}
 
-		h := plumbing.NewHash(hashStr)
-		obj, err := iter.s.EncodedObject(plumbing.AnyObject, h)
+		batch, err := iter.s.ms.ListObjects(iter.s.ctx, iter.s.repoName, iter.typeName, iter.after, iterBatchSize)
 		if err != nil {
-			// If we can't read an object in the list, we skip or error?
-			// Let's logic: if it's corrupt, we might want to know, but for iteration, maybe skip?
-			// Standard behavior involves returning error if repository is corrupt.
 			return nil, err
 		}
 
-		if iter.t != plumbing.AnyObject && obj.Type() != iter.t {
-			continue
+		iter.batch = batch
+		iter.pos = 0
+		iter.done = len(batch) < iterBatchSize
+		if len(batch) == 0 {
+			return nil, io.EOF
 		}
-
-		return obj, nil
+		iter.after = batch[len(batch)-1].Hash
 	}
-	return nil, io.EOF
+
+	entry := iter.batch[iter.pos]
+	iter.pos++
+
+	return iter.s.EncodedObject(iter.t, plumbing.NewHash(entry.Hash))
 }
 
 func (iter *EncodedObjectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
@@ -173,23 +378,241 @@ func (iter *EncodedObjectIter) ForEach(cb func(plumbing.EncodedObject) error) er
 }
 
 func (iter *EncodedObjectIter) Close() {
-	iter.keys = nil
+	iter.batch = nil
 	iter.pos = 0
+	iter.done = true
 }
 
 func (s *ObjectStorage) HasEncodedObject(h plumbing.Hash) error {
-	key := fmt.Sprintf("repositories/%s/objects/%s", s.repoName, h.String())
-	return s.os.Head(context.Background(), key)
+	if _, ok := s.uploads.get(h); ok {
+		return nil
+	}
+
+	_, err := s.ms.GetObject(s.ctx, s.repoName, h.String())
+	if errors.Is(err, metastore.ErrNotFound) {
+		_, err = s.findShared(h)
+	}
+	if err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
+	// Objects written before the index was introduced are only in S3 until
+	// the repository has been backfilled.
+	if err := s.os.Head(s.ctx, s.objectKey(h)); err != nil {
+		return s.notFound(err)
+	}
+	return nil
+}
+
+// HasEncodedObjects returns the subset of hashes present in the repository,
+// resolved with a single index query.
+func (s *ObjectStorage) HasEncodedObjects(hashes []plumbing.Hash) ([]plumbing.Hash, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	var found []plumbing.Hash
+	var keys []string
+	for _, h := range hashes {
+		if _, ok := s.uploads.get(h); ok {
+			found = append(found, h)
+			continue
+		}
+		keys = append(keys, h.String())
+	}
+
+	entries, err := s.ms.FindObjects(s.ctx, s.repoName, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		missing[k] = true
+	}
+	for _, entry := range entries {
+		found = append(found, plumbing.NewHash(entry.Hash))
+		delete(missing, entry.Hash)
+	}
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	alternates, err := s.Alternates()
+	if err != nil || len(alternates) == 0 {
+		return found, err
+	}
+
+	keys = keys[:0]
+	for k := range missing {
+		keys = append(keys, k)
+	}
+	entries, err = s.ms.FindSharedObjects(s.ctx, alternates, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if missing[entry.Hash] {
+			found = append(found, plumbing.NewHash(entry.Hash))
+			delete(missing, entry.Hash)
+		}
+	}
+	return found, nil
 }
 
+// AddAlternate makes the repository a fork of remote, the name of another
+// repository: objects missing from the repository are then looked up in
+// remote and its own alternates. The link is persisted in the metastore, and a
+// repository can only be forked from a single repository.
 func (s *ObjectStorage) AddAlternate(remote string) error {
+	alternates, err := s.ms.ListAlternates(s.ctx, remote)
+	if err != nil {
+		return err
+	}
+	if remote == s.repoName || slices.Contains(alternates, s.repoName) {
+		return fmt.Errorf("%s cannot be an alternate of itself", s.repoName)
+	}
+
+	if err := s.ms.SetRepositoryParent(s.ctx, s.repoName, remote); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.alternates = append([]string{remote}, alternates...)
+	s.loaded = true
+	s.mu.Unlock()
 	return nil
 }
 
+// Alternates returns the repositories whose objects are shared with this
+// one, nearest first.
+func (s *ObjectStorage) Alternates() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		alternates, err := s.ms.ListAlternates(s.ctx, s.repoName)
+		if err != nil {
+			return nil, err
+		}
+		s.alternates = alternates
+		s.loaded = true
+	}
+	return s.alternates, nil
+}
+
+// findShared returns the index entry of h in the nearest alternate that has
+// it.
+func (s *ObjectStorage) findShared(h plumbing.Hash) (pg.Object, error) {
+	alternates, err := s.Alternates()
+	if err != nil {
+		return pg.Object{}, err
+	}
+	if len(alternates) == 0 {
+		return pg.Object{}, plumbing.ErrObjectNotFound
+	}
+
+	entries, err := s.ms.FindSharedObjects(s.ctx, alternates, []string{h.String()})
+	if err != nil {
+		return pg.Object{}, err
+	}
+	for _, name := range alternates {
+		for _, entry := range entries {
+			if entry.RepoName == name {
+				return entry, nil
+			}
+		}
+	}
+	return pg.Object{}, plumbing.ErrObjectNotFound
+}
+
 func (s *ObjectStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
-	obj, err := s.EncodedObject(plumbing.AnyObject, h)
+	_, size, err := s.EncodedObjectInfo(h)
+	return size, err
+}
+
+// EncodedObjectSizes returns the sizes of hashes, resolved with a single index
+// query for the repository and one for its alternates. Objects missing from
+// the index are looked up one by one like EncodedObjectSize.
+func (s *ObjectStorage) EncodedObjectSizes(hashes []plumbing.Hash) (map[plumbing.Hash]int64, error) {
+	sizes := make(map[plumbing.Hash]int64, len(hashes))
+	var keys []string
+	for _, h := range hashes {
+		if obj, ok := s.uploads.get(h); ok {
+			sizes[h] = obj.Size()
+			continue
+		}
+		keys = append(keys, h.String())
+	}
+	if len(keys) == 0 {
+		return sizes, nil
+	}
+
+	entries, err := s.ms.FindObjects(s.ctx, s.repoName, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	alternates, err := s.Alternates()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) < len(keys) && len(alternates) > 0 {
+		shared, err := s.ms.FindSharedObjects(s.ctx, alternates, keys)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, shared...)
+	}
+	for _, entry := range entries {
+		sizes[plumbing.NewHash(entry.Hash)] = entry.Size
+	}
+
+	for _, h := range hashes {
+		if _, ok := sizes[h]; ok {
+			continue
+		}
+		size, err := s.EncodedObjectSize(h)
+		if err != nil {
+			return nil, err
+		}
+		sizes[h] = size
+	}
+	return sizes, nil
+}
+
+// EncodedObjectInfo returns the type and size of an object without downloading
+// its content. The object index is consulted first; objects missing from it
+// fall back to the S3 metadata and, for objects written before type and size
+// were recorded, to a ranged read of the loose object header.
+func (s *ObjectStorage) EncodedObjectInfo(h plumbing.Hash) (plumbing.ObjectType, int64, error) {
+	if obj, ok := s.uploads.get(h); ok {
+		return obj.Type(), obj.Size(), nil
+	}
+
+	ctx := s.ctx
+
+	entry, err := s.ms.GetObject(ctx, s.repoName, h.String())
+	if errors.Is(err, metastore.ErrNotFound) {
+		entry, err = s.findShared(h)
+	}
+	if err == nil {
+		t, err := plumbing.ParseObjectType(entry.Type)
+		return t, entry.Size, err
+	}
+	if err != plumbing.ErrObjectNotFound {
+		return plumbing.InvalidObject, 0, err
+	}
+
+	key := s.objectKey(h)
+	md, err := s.os.HeadMetadata(ctx, key)
 	if err != nil {
-		return 0, err
+		return plumbing.InvalidObject, 0, s.notFound(err)
 	}
-	return obj.Size(), nil
+
+	if t, size, ok := parseObjectMetadata(md); ok {
+		return t, size, nil
+	}
+
+	return s.readObjectHeader(ctx, key)
 }
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// prefetchWorkers bounds the number of concurrent speculative reads.
+	prefetchWorkers = 16
+
+	// prefetchQueueLen bounds the number of scheduled reads. Further
+	// prefetches are dropped and loaded on demand instead.
+	prefetchQueueLen = 4096
+
+	// prefetchBufferSize bounds the bytes of object content held by the
+	// prefetch buffer of a single request.
+	prefetchBufferSize = 64 << 20
+)
+
+var errNotPrefetched = errors.New("object was not prefetched")
+
+// prefetcher speculatively loads the objects referenced by the commits and
+// trees that are read, so that a revision walk finds them in memory instead of
+// waiting for one object store round trip per object. Its buffer is scoped to
+// a single request.
+//
+// Commits and trees stay buffered because both the revision walk and the
+// packfile encoder read them; blobs are only read by the encoder and are
+// released as soon as they are consumed. Requests that never read blobs, such
+// as history walks and filtered fetches, turn off the prefetching of blobs so
+// that they do not fill the buffer.
+type prefetcher struct {
+	load    func(plumbing.Hash) (plumbing.EncodedObject, error)
+	queue   chan plumbing.Hash
+	done    chan struct{}
+	once    sync.Once
+	noBlobs atomic.Bool
+
+	mu      sync.Mutex
+	entries map[plumbing.Hash]*prefetchEntry
+	size    int64
+}
+
+type prefetchEntry struct {
+	ready chan struct{}
+	obj   plumbing.EncodedObject
+	err   error
+}
+
+func newPrefetcher(load func(plumbing.Hash) (plumbing.EncodedObject, error)) *prefetcher {
+	p := &prefetcher{
+		load:    load,
+		queue:   make(chan plumbing.Hash, prefetchQueueLen),
+		done:    make(chan struct{}),
+		entries: make(map[plumbing.Hash]*prefetchEntry),
+	}
+
+	for i := 0; i < prefetchWorkers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// get returns the object with hash h, waiting for an in-flight prefetch or
+// loading it directly, and schedules the objects it references.
+func (p *prefetcher) get(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := p.wait(h)
+	if errors.Is(err, errNotPrefetched) {
+		obj, err = p.load(h)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.scheduleReferences(obj)
+	return obj, nil
+}
+
+func (p *prefetcher) wait(h plumbing.Hash) (plumbing.EncodedObject, error) {
+	p.mu.Lock()
+	e, ok := p.entries[h]
+	p.mu.Unlock()
+
+	if !ok {
+		return nil, errNotPrefetched
+	}
+
+	select {
+	case <-e.ready:
+	case <-p.done:
+		return nil, errNotPrefetched
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	if e.obj.Type() == plumbing.BlobObject {
+		p.mu.Lock()
+		if p.entries[h] == e {
+			delete(p.entries, h)
+			p.size -= e.obj.Size()
+		}
+		p.mu.Unlock()
+	}
+
+	return e.obj, nil
+}
+
+// scheduleReferences schedules the tree and parents of a commit, or the
+// entries of a tree. Submodule entries point into other repositories and are
+// skipped, like blobs when they are not prefetched.
+func (p *prefetcher) scheduleReferences(obj plumbing.EncodedObject) {
+	switch obj.Type() {
+	case plumbing.CommitObject:
+		c := &object.Commit{}
+		if err := c.Decode(obj); err != nil {
+			return
+		}
+		p.schedule(c.TreeHash)
+		for _, parent := range c.ParentHashes {
+			p.schedule(parent)
+		}
+	case plumbing.TreeObject:
+		t := &object.Tree{}
+		if err := t.Decode(obj); err != nil {
+			return
+		}
+		for _, entry := range t.Entries {
+			if entry.Mode == filemode.Submodule || (entry.Mode != filemode.Dir && p.noBlobs.Load()) {
+				continue
+			}
+			p.schedule(entry.Hash)
+		}
+	}
+}
+
+func (p *prefetcher) schedule(h plumbing.Hash) {
+	p.mu.Lock()
+	if _, ok := p.entries[h]; ok || p.size >= prefetchBufferSize {
+		p.mu.Unlock()
+		return
+	}
+	e := &prefetchEntry{ready: make(chan struct{})}
+	p.entries[h] = e
+	p.mu.Unlock()
+
+	select {
+	case p.queue <- h:
+	default:
+		p.mu.Lock()
+		delete(p.entries, h)
+		p.mu.Unlock()
+
+		e.err = errNotPrefetched
+		close(e.ready)
+	}
+}
+
+func (p *prefetcher) work() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case h := <-p.queue:
+			p.mu.Lock()
+			e, ok := p.entries[h]
+			p.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			obj, err := p.load(h)
+
+			p.mu.Lock()
+			if err == nil {
+				e.obj = obj
+				p.size += obj.Size()
+			} else {
+				// Let the consumer retry a failed speculative read.
+				e.err = errNotPrefetched
+				if p.entries[h] == e {
+					delete(p.entries, h)
+				}
+			}
+			p.mu.Unlock()
+
+			close(e.ready)
+		}
+	}
+}
+
+// close stops the workers and releases the buffer.
+func (p *prefetcher) close() {
+	p.once.Do(func() {
+		close(p.done)
+
+		p.mu.Lock()
+		p.entries = make(map[plumbing.Hash]*prefetchEntry)
+		p.size = 0
+		p.mu.Unlock()
+	})
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/storer"
@@ -9,14 +10,15 @@ import (
 )
 
 type ReferenceStorage struct {
+	ctx      context.Context
 	ms       *metastore.MetaStore
 	repoName string
 }
 
 func (s *ReferenceStorage) SetReference(ref *plumbing.Reference) error {
-	target := ""
+	refType, target := metastore.RefTypeHash, ""
 	if ref.Type() == plumbing.SymbolicReference {
-		target = ref.Target().String()
+		refType, target = metastore.RefTypeSymbolic, ref.Target().String()
 	}
 	// For HashReference, ref.Hash().String()
 	// For Symbolic, ref.Target().String(), hash is empty?
@@ -25,7 +27,7 @@ func (s *ReferenceStorage) SetReference(ref *plumbing.Reference) error {
 		hash = ref.Hash().String()
 	}
 
-	err := s.ms.PutRef(context.Background(), s.repoName, ref.Name().String(), ref.Type().String(), hash, target)
+	err := s.ms.PutRef(s.ctx, s.repoName, ref.Name().String(), refType, hash, target)
 	return err
 }
 
@@ -36,26 +38,29 @@ func (s *ReferenceStorage) CheckAndSetReference(new, old *plumbing.Reference) er
 }
 
 func (s *ReferenceStorage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
-	ref, err := s.ms.GetRef(context.Background(), s.repoName, n.String())
-	if err != nil {
+	ref, err := s.ms.GetRef(s.ctx, s.repoName, n.String())
+	if errors.Is(err, metastore.ErrNotFound) {
 		return nil, plumbing.ErrReferenceNotFound
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	if ref.Type == "symbolic" { // string "symbolic"
+	if ref.Type == metastore.RefTypeSymbolic {
 		return plumbing.NewSymbolicReference(n, plumbing.ReferenceName(ref.Target.String)), nil
 	}
 	return plumbing.NewHashReference(n, plumbing.NewHash(ref.Hash.String)), nil
 }
 
 func (s *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
-	refs, err := s.ms.ListRefs(context.Background(), s.repoName)
+	refs, err := s.ms.ListRefs(s.ctx, s.repoName)
 	if err != nil {
 		return nil, err
 	}
 	// Convert to iterator
 	var r []*plumbing.Reference
 	for _, ref := range refs {
-		if ref.Type == "symbolic" {
+		if ref.Type == metastore.RefTypeSymbolic {
 			r = append(r, plumbing.NewSymbolicReference(plumbing.ReferenceName(ref.RefName), plumbing.ReferenceName(ref.Target.String)))
 		} else {
 			r = append(r, plumbing.NewHashReference(plumbing.ReferenceName(ref.RefName), plumbing.NewHash(ref.Hash.String)))
@@ -65,7 +70,7 @@ func (s *ReferenceStorage) IterReferences() (storer.ReferenceIter, error) {
 }
 
 func (s *ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
-	return s.ms.DeleteRef(context.Background(), s.repoName, n.String())
+	return s.ms.DeleteRef(s.ctx, s.repoName, n.String())
 }
 
 func (s *ReferenceStorage) CountLooseRefs() (int, error) {
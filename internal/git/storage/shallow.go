@@ -11,6 +11,7 @@ import (
 )
 
 type ShallowStorage struct {
+	ctx      context.Context
 	os       *objectstore.ObjectStore
 	repoName string
 }
@@ -22,12 +23,12 @@ func (s *ShallowStorage) SetShallow(commits []plumbing.Hash) error {
 	}
 
 	key := fmt.Sprintf("repositories/%s/shallow", s.repoName)
-	return s.os.Put(context.Background(), key, &buf)
+	return s.os.Put(s.ctx, key, &buf)
 }
 
 func (s *ShallowStorage) Shallow() ([]plumbing.Hash, error) {
 	key := fmt.Sprintf("repositories/%s/shallow", s.repoName)
-	rc, err := s.os.Get(context.Background(), key)
+	rc, err := s.os.Get(s.ctx, key)
 	if err != nil {
 		// If no shallow file, return empty list (not error)
 		return nil, nil
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/npclaudiu/git-server-poc/internal/metastore"
 	"github.com/npclaudiu/git-server-poc/internal/objectstore"
@@ -16,16 +18,36 @@ type Storer struct {
 	*IndexStorage
 }
 
-func NewStorer(os *objectstore.ObjectStore, ms *metastore.MetaStore, repoName string) *Storer {
+// NewStorer returns a Storer for a repository. Every object store and
+// metastore call it makes is bound to ctx, which is usually the context of the
+// request being served: cancelling it aborts in-flight reads and uploads.
+func NewStorer(ctx context.Context, os *objectstore.ObjectStore, ms *metastore.MetaStore, repoName string) *Storer {
 	return &Storer{
-		ObjectStorage:    &ObjectStorage{os: os, repoName: repoName},
-		ReferenceStorage: &ReferenceStorage{ms: ms, repoName: repoName},
-		ShallowStorage:   &ShallowStorage{os: os, repoName: repoName},
-		ConfigStorage:    &ConfigStorage{os: os, repoName: repoName},
-		IndexStorage:     &IndexStorage{os: os, repoName: repoName},
+		ObjectStorage:    newObjectStorage(ctx, os, ms, repoName),
+		ReferenceStorage: &ReferenceStorage{ctx: ctx, ms: ms, repoName: repoName},
+		ShallowStorage:   &ShallowStorage{ctx: ctx, os: os, repoName: repoName},
+		ConfigStorage:    &ConfigStorage{ctx: ctx, os: os, repoName: repoName},
+		IndexStorage:     &IndexStorage{ctx: ctx, os: os, repoName: repoName},
 	}
 }
 
+// SetReference waits for pending object uploads before updating the
+// reference, so that a reference never points at objects that are not durable.
+func (s *Storer) SetReference(ref *plumbing.Reference) error {
+	if err := s.ObjectStorage.Flush(); err != nil {
+		return err
+	}
+	return s.ReferenceStorage.SetReference(ref)
+}
+
+// CheckAndSetReference waits for pending object uploads, like SetReference.
+func (s *Storer) CheckAndSetReference(new, old *plumbing.Reference) error {
+	if err := s.ObjectStorage.Flush(); err != nil {
+		return err
+	}
+	return s.ReferenceStorage.CheckAndSetReference(new, old)
+}
+
 func (s *Storer) Module(name string) (storage.Storer, error) {
 	return nil, fmt.Errorf("module storage not implemented")
 }
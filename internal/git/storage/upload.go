@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// defaultUploadConcurrency is used when the object store does not configure
+// the number of concurrent uploads.
+const defaultUploadConcurrency = 16
+
+// uploadQueue uploads objects in the background with bounded concurrency.
+// Enqueueing blocks while all workers are busy, which applies back-pressure to
+// the producer (usually the packfile parser). Objects remain readable from
+// memory until their upload has completed.
+type uploadQueue struct {
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	pending map[plumbing.Hash]plumbing.EncodedObject
+	err     error
+}
+
+func newUploadQueue(concurrency int) *uploadQueue {
+	if concurrency <= 0 {
+		concurrency = defaultUploadConcurrency
+	}
+
+	return &uploadQueue{
+		sem:     make(chan struct{}, concurrency),
+		pending: make(map[plumbing.Hash]plumbing.EncodedObject),
+	}
+}
+
+// enqueue schedules upload(obj) on a free worker. It fails fast with the first
+// error of a previous upload, so that a failing push stops early.
+func (q *uploadQueue) enqueue(obj plumbing.EncodedObject, upload func(plumbing.EncodedObject) error) error {
+	h := obj.Hash()
+
+	q.mu.Lock()
+	if q.err != nil {
+		err := q.err
+		q.mu.Unlock()
+		return err
+	}
+	if _, ok := q.pending[h]; ok {
+		q.mu.Unlock()
+		return nil
+	}
+	q.pending[h] = obj
+	q.mu.Unlock()
+
+	q.sem <- struct{}{}
+	q.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-q.sem
+			q.wg.Done()
+		}()
+
+		err := upload(obj)
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		delete(q.pending, h)
+		if err != nil && q.err == nil {
+			q.err = err
+		}
+	}()
+
+	return nil
+}
+
+// get returns an object that is still being uploaded.
+func (q *uploadQueue) get(h plumbing.Hash) (plumbing.EncodedObject, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	obj, ok := q.pending[h]
+	return obj, ok
+}
+
+// wait blocks until every enqueued upload has completed and returns the first
+// upload error, if any.
+func (q *uploadQueue) wait() error {
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.err
+}
@@ -2,13 +2,42 @@ package metastore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
 )
 
+// ErrNotFound is returned by single-row lookups when no row matches.
+var ErrNotFound = pgx.ErrNoRows
+
+// ErrHasParent is returned by SetRepositoryParent when the repository is
+// already a fork.
+var ErrHasParent = errors.New("repository is already a fork")
+
+// ErrImportInProgress is returned by CreateImportJob when the repository
+// already has an import job that has not finished.
+var ErrImportInProgress = errors.New("an import is already in progress")
+
+// Types of the references in the refs table.
+const (
+	RefTypeHash     = "hash-reference"
+	RefTypeSymbolic = "symbolic"
+)
+
+// uniqueViolation is the SQLSTATE of unique constraint violations.
+const uniqueViolation = "23505"
+
+// ErrLockTimeout is returned by LockRepository when the lock could not be
+// acquired in time.
+var ErrLockTimeout = errors.New("timed out waiting for the repository lock")
+
 type MetaStore struct {
 	pool    *pgxpool.Pool
 	queries *pg.Queries
@@ -58,8 +87,32 @@ func (m *MetaStore) Ping(ctx context.Context) error {
 	return m.pool.Ping(ctx)
 }
 
-func (m *MetaStore) CreateRepository(ctx context.Context, name string) (pg.Repository, error) {
-	return m.queries.CreateRepository(ctx, name)
+// CreateRepository creates a repository whose HEAD points to defaultBranch, a
+// full reference name. The branch is created by the first push to it.
+func (m *MetaStore) CreateRepository(ctx context.Context, name, defaultBranch string) (pg.Repository, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return pg.Repository{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := m.queries.WithTx(tx)
+	repo, err := q.CreateRepository(ctx, name)
+	if err != nil {
+		return pg.Repository{}, err
+	}
+
+	err = q.PutRef(ctx, pg.PutRefParams{
+		RepoName: name,
+		RefName:  "HEAD",
+		Type:     RefTypeSymbolic,
+		Target:   pgtype.Text{String: defaultBranch, Valid: true},
+	})
+	if err != nil {
+		return pg.Repository{}, err
+	}
+
+	return repo, tx.Commit(ctx)
 }
 
 func (m *MetaStore) ListRepositories(ctx context.Context) ([]pg.Repository, error) {
@@ -81,6 +134,30 @@ func (m *MetaStore) DeleteRepository(ctx context.Context, name string) error {
 	return m.queries.DeleteRepository(ctx, name)
 }
 
+// SetRepositoryParent makes a repository a fork of parent. It returns
+// ErrHasParent if the repository is already a fork.
+func (m *MetaStore) SetRepositoryParent(ctx context.Context, name, parent string) error {
+	n, err := m.queries.SetRepositoryParent(ctx, pg.SetRepositoryParentParams{
+		Name:       name,
+		ParentName: pgtype.Text{String: parent, Valid: true},
+	})
+	if err == nil && n == 0 {
+		return ErrHasParent
+	}
+	return err
+}
+
+// ListAlternates returns the parent of a repository, the parent of its
+// parent and so on, nearest first.
+func (m *MetaStore) ListAlternates(ctx context.Context, name string) ([]string, error) {
+	return m.queries.ListAlternates(ctx, name)
+}
+
+// ListForks returns the direct forks of a repository, sorted by name.
+func (m *MetaStore) ListForks(ctx context.Context, name string) ([]pg.Repository, error) {
+	return m.queries.ListForks(ctx, pgtype.Text{String: name, Valid: true})
+}
+
 func (m *MetaStore) GetRef(ctx context.Context, repoName, refName string) (pg.Ref, error) {
 	return m.queries.GetRef(ctx, pg.GetRefParams{
 		RepoName: repoName,
@@ -92,6 +169,32 @@ func (m *MetaStore) ListRefs(ctx context.Context, repoName string) ([]pg.Ref, er
 	return m.queries.ListRefs(ctx, repoName)
 }
 
+// ListRefsByPrefix returns a page of the references of a repository whose
+// name starts with prefix, sorted by name.
+func (m *MetaStore) ListRefsByPrefix(ctx context.Context, repoName, prefix string, skip, limit int32) ([]pg.Ref, error) {
+	return m.queries.ListRefsByPrefix(ctx, pg.ListRefsByPrefixParams{
+		RepoName: repoName,
+		Prefix:   prefix,
+		Skip:     skip,
+		MaxCount: limit,
+	})
+}
+
+// ListHeads returns the target of the symbolic HEAD of every repository that
+// has one, by repository name.
+func (m *MetaStore) ListHeads(ctx context.Context) (map[string]string, error) {
+	rows, err := m.queries.ListHeads(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	heads := make(map[string]string, len(rows))
+	for _, row := range rows {
+		heads[row.RepoName] = row.Target.String
+	}
+	return heads, nil
+}
+
 func (m *MetaStore) PutRef(ctx context.Context, repoName, refName, refType, hash, target string) error {
 	return m.queries.PutRef(ctx, pg.PutRefParams{
 		RepoName: repoName,
@@ -102,9 +205,414 @@ func (m *MetaStore) PutRef(ctx context.Context, repoName, refName, refType, hash
 	})
 }
 
+// CopyRefs copies the branches and tags of src to dst, which must not have
+// any.
+func (m *MetaStore) CopyRefs(ctx context.Context, src, dst string) error {
+	return m.queries.CopyRefs(ctx, pg.CopyRefsParams{
+		SrcRepoName: src,
+		DstRepoName: dst,
+	})
+}
+
 func (m *MetaStore) DeleteRef(ctx context.Context, repoName, refName string) error {
 	return m.queries.DeleteRef(ctx, pg.DeleteRefParams{
 		RepoName: repoName,
 		RefName:  refName,
 	})
 }
+
+func (m *MetaStore) GetObject(ctx context.Context, repoName, hash string) (pg.Object, error) {
+	return m.queries.GetObject(ctx, pg.GetObjectParams{
+		RepoName: repoName,
+		Hash:     hash,
+	})
+}
+
+// FindObjects returns the index entries of the given hashes that exist in the
+// repository, in a single round trip.
+func (m *MetaStore) FindObjects(ctx context.Context, repoName string, hashes []string) ([]pg.Object, error) {
+	return m.queries.FindObjects(ctx, pg.FindObjectsParams{
+		RepoName: repoName,
+		Hashes:   hashes,
+	})
+}
+
+// FindSharedObjects is FindObjects across several repositories, e.g. a fork
+// and its alternates. An object stored in several of them is returned once
+// per repository.
+func (m *MetaStore) FindSharedObjects(ctx context.Context, repoNames, hashes []string) ([]pg.Object, error) {
+	return m.queries.FindSharedObjects(ctx, pg.FindSharedObjectsParams{
+		RepoNames: repoNames,
+		Hashes:    hashes,
+	})
+}
+
+// ListObjects returns up to limit index entries ordered by hash, starting
+// after the given hash. An empty objType matches objects of any type.
+func (m *MetaStore) ListObjects(ctx context.Context, repoName, objType, after string, limit int32) ([]pg.Object, error) {
+	return m.queries.ListObjects(ctx, pg.ListObjectsParams{
+		RepoName: repoName,
+		Type:     pgtype.Text{String: objType, Valid: objType != ""},
+		After:    after,
+		MaxCount: limit,
+	})
+}
+
+// PutObject records a loose object stored under key in the object index.
+func (m *MetaStore) PutObject(ctx context.Context, repoName, hash, objType string, size int64, key string) error {
+	return m.queries.PutObject(ctx, pg.PutObjectParams{
+		RepoName: repoName,
+		Hash:     hash,
+		Type:     objType,
+		Size:     size,
+		LooseKey: pgtype.Text{String: key, Valid: true},
+	})
+}
+
+// CreateMergeRequest creates a merge request numbered after the last one of
+// the repository and, in the same transaction, points the reference named by
+// headRef after its number to head. Concurrent calls for the same repository
+// must be serialized, e.g. by holding the repository lock.
+func (m *MetaStore) CreateMergeRequest(ctx context.Context, arg pg.CreateMergeRequestParams, headRef func(number int32) string, head string) (pg.MergeRequest, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return pg.MergeRequest{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := m.queries.WithTx(tx)
+	mr, err := q.CreateMergeRequest(ctx, arg)
+	if err != nil {
+		return pg.MergeRequest{}, err
+	}
+
+	err = q.PutRef(ctx, pg.PutRefParams{
+		RepoName: arg.RepoName,
+		RefName:  headRef(mr.Number),
+		Type:     RefTypeHash,
+		Hash:     pgtype.Text{String: head, Valid: true},
+	})
+	if err != nil {
+		return pg.MergeRequest{}, err
+	}
+
+	return mr, tx.Commit(ctx)
+}
+
+func (m *MetaStore) GetMergeRequest(ctx context.Context, repoName string, number int32) (pg.MergeRequest, error) {
+	return m.queries.GetMergeRequest(ctx, pg.GetMergeRequestParams{
+		RepoName: repoName,
+		Number:   number,
+	})
+}
+
+// ListMergeRequests returns up to limit merge requests, newest first,
+// skipping the first skip ones. An empty state matches every state.
+func (m *MetaStore) ListMergeRequests(ctx context.Context, repoName, state string, skip, limit int32) ([]pg.MergeRequest, error) {
+	return m.queries.ListMergeRequests(ctx, pg.ListMergeRequestsParams{
+		RepoName: repoName,
+		State:    pgtype.Text{String: state, Valid: state != ""},
+		Skip:     skip,
+		MaxCount: limit,
+	})
+}
+
+// ListOpenMergeRequestsBySource returns the numbers of the open merge
+// requests of a source branch.
+func (m *MetaStore) ListOpenMergeRequestsBySource(ctx context.Context, repoName, sourceBranch string) ([]int32, error) {
+	return m.queries.ListOpenMergeRequestsBySource(ctx, pg.ListOpenMergeRequestsBySourceParams{
+		RepoName:     repoName,
+		SourceBranch: sourceBranch,
+	})
+}
+
+func (m *MetaStore) UpdateMergeRequest(ctx context.Context, arg pg.UpdateMergeRequestParams) (pg.MergeRequest, error) {
+	return m.queries.UpdateMergeRequest(ctx, arg)
+}
+
+func (m *MetaStore) CreateMergeRequestComment(ctx context.Context, arg pg.CreateMergeRequestCommentParams) (pg.MergeRequestComment, error) {
+	return m.queries.CreateMergeRequestComment(ctx, arg)
+}
+
+func (m *MetaStore) ListMergeRequestComments(ctx context.Context, repoName string, number int32) ([]pg.MergeRequestComment, error) {
+	return m.queries.ListMergeRequestComments(ctx, pg.ListMergeRequestCommentsParams{
+		RepoName: repoName,
+		Number:   number,
+	})
+}
+
+// PutMergeRequestApproval records the approval of a merge request at a
+// commit, replacing any previous approval by the same approver.
+func (m *MetaStore) PutMergeRequestApproval(ctx context.Context, repoName string, number int32, approver, commit string) (pg.MergeRequestApproval, error) {
+	return m.queries.PutMergeRequestApproval(ctx, pg.PutMergeRequestApprovalParams{
+		RepoName:   repoName,
+		Number:     number,
+		Approver:   approver,
+		CommitHash: commit,
+	})
+}
+
+// DeleteMergeRequestApproval withdraws an approval and returns ErrNotFound if
+// there was none.
+func (m *MetaStore) DeleteMergeRequestApproval(ctx context.Context, repoName string, number int32, approver string) error {
+	n, err := m.queries.DeleteMergeRequestApproval(ctx, pg.DeleteMergeRequestApprovalParams{
+		RepoName: repoName,
+		Number:   number,
+		Approver: approver,
+	})
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *MetaStore) ListMergeRequestApprovals(ctx context.Context, repoName string, number int32) ([]pg.MergeRequestApproval, error) {
+	return m.queries.ListMergeRequestApprovals(ctx, pg.ListMergeRequestApprovalsParams{
+		RepoName: repoName,
+		Number:   number,
+	})
+}
+
+// LockRepository acquires the write lock of a repository, waiting up to
+// timeout for it to be released by its current holder. The lock is a
+// session-level Postgres advisory lock held on a dedicated connection, so it
+// serializes writers across every server instance sharing the database. The
+// returned function releases the lock.
+func (m *MetaStore) LockRepository(ctx context.Context, repoName string, timeout time.Duration) (func(), error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := pg.New(conn)
+	deadline := time.Now().Add(timeout)
+	delay := 10 * time.Millisecond
+
+	for {
+		locked, err := q.TryLockRepository(ctx, repoName)
+		if err != nil {
+			conn.Release()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			conn.Release()
+			return nil, ErrLockTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			conn.Release()
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 500*time.Millisecond)
+	}
+
+	return func() {
+		// Unlock even if the request has been cancelled in the meantime.
+		if _, err := q.UnlockRepository(context.Background(), repoName); err != nil {
+			// Closing the connection ends the session, which releases the
+			// lock.
+			slog.Error("failed to release repository lock", "repo", repoName, "err", err)
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}, nil
+}
+
+func (m *MetaStore) CreateCommitStatus(ctx context.Context, arg pg.CreateCommitStatusParams) (pg.CommitStatus, error) {
+	return m.queries.CreateCommitStatus(ctx, arg)
+}
+
+// ListCommitStatuses returns up to limit statuses of a commit, newest first,
+// skipping the first skip ones.
+func (m *MetaStore) ListCommitStatuses(ctx context.Context, repoName, commit string, skip, limit int32) ([]pg.CommitStatus, error) {
+	return m.queries.ListCommitStatuses(ctx, pg.ListCommitStatusesParams{
+		RepoName:   repoName,
+		CommitHash: commit,
+		Skip:       skip,
+		MaxCount:   limit,
+	})
+}
+
+// ListLatestCommitStatuses returns the latest status of each context of a
+// commit, sorted by context.
+func (m *MetaStore) ListLatestCommitStatuses(ctx context.Context, repoName, commit string) ([]pg.CommitStatus, error) {
+	return m.queries.ListLatestCommitStatuses(ctx, pg.ListLatestCommitStatusesParams{
+		RepoName:   repoName,
+		CommitHash: commit,
+	})
+}
+
+// CreateImportJob creates a pending import job. It returns ErrImportInProgress
+// if the repository already has a pending or running one.
+func (m *MetaStore) CreateImportJob(ctx context.Context, repoName, url string) (pg.ImportJob, error) {
+	job, err := m.queries.CreateImportJob(ctx, pg.CreateImportJobParams{
+		RepoName: repoName,
+		Url:      url,
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return job, ErrImportInProgress
+	}
+	return job, err
+}
+
+func (m *MetaStore) GetImportJob(ctx context.Context, repoName string, id int32) (pg.ImportJob, error) {
+	return m.queries.GetImportJob(ctx, pg.GetImportJobParams{
+		RepoName: repoName,
+		ID:       id,
+	})
+}
+
+// ListImportJobs returns up to limit import jobs of a repository, newest
+// first, skipping the first skip ones.
+func (m *MetaStore) ListImportJobs(ctx context.Context, repoName string, skip, limit int32) ([]pg.ImportJob, error) {
+	return m.queries.ListImportJobs(ctx, pg.ListImportJobsParams{
+		RepoName: repoName,
+		Skip:     skip,
+		MaxCount: limit,
+	})
+}
+
+// UpdateImportJobProgress records the state and progress of a job, which also
+// serves as its heartbeat.
+func (m *MetaStore) UpdateImportJobProgress(ctx context.Context, id int32, state, progress string) error {
+	return m.queries.UpdateImportJobProgress(ctx, pg.UpdateImportJobProgressParams{
+		ID:       id,
+		State:    state,
+		Progress: progress,
+	})
+}
+
+func (m *MetaStore) FinishImportJob(ctx context.Context, id int32, state, errMsg string) error {
+	return m.queries.FinishImportJob(ctx, pg.FinishImportJobParams{
+		ID:    id,
+		State: state,
+		Error: errMsg,
+	})
+}
+
+// FailStaleImportJobs marks as failed the pending or running import jobs of a
+// repository that have not been updated for staleAfter, e.g. because the
+// server running them stopped.
+func (m *MetaStore) FailStaleImportJobs(ctx context.Context, repoName string, staleAfter time.Duration, errMsg string) error {
+	return m.queries.FailStaleImportJobs(ctx, pg.FailStaleImportJobsParams{
+		RepoName:     repoName,
+		StaleSeconds: staleAfter.Seconds(),
+		Error:        errMsg,
+	})
+}
+
+// UpsertPullMirror makes a repository a mirror of url, or changes its url and
+// interval. Either way, the mirror is due for synchronisation right away.
+func (m *MetaStore) UpsertPullMirror(ctx context.Context, repoName, url string, interval time.Duration) (pg.PullMirror, error) {
+	return m.queries.UpsertPullMirror(ctx, pg.UpsertPullMirrorParams{
+		RepoName:        repoName,
+		Url:             url,
+		IntervalSeconds: int32(interval.Seconds()),
+	})
+}
+
+func (m *MetaStore) GetPullMirror(ctx context.Context, repoName string) (pg.PullMirror, error) {
+	return m.queries.GetPullMirror(ctx, repoName)
+}
+
+func (m *MetaStore) IsPullMirror(ctx context.Context, repoName string) (bool, error) {
+	return m.queries.IsPullMirror(ctx, repoName)
+}
+
+func (m *MetaStore) DeletePullMirror(ctx context.Context, repoName string) error {
+	n, err := m.queries.DeletePullMirror(ctx, repoName)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ClaimDuePullMirrors returns up to limit mirrors that are due for
+// synchronisation and schedules their next one. Mirrors claimed concurrently,
+// e.g. by another server instance, are skipped.
+func (m *MetaStore) ClaimDuePullMirrors(ctx context.Context, limit int32) ([]pg.PullMirror, error) {
+	return m.queries.ClaimDuePullMirrors(ctx, limit)
+}
+
+// RecordPullMirrorSync records the outcome of a synchronisation. errMsg is
+// empty if it succeeded.
+func (m *MetaStore) RecordPullMirrorSync(ctx context.Context, repoName, state, errMsg string) (pg.PullMirror, error) {
+	return m.queries.RecordPullMirrorSync(ctx, pg.RecordPullMirrorSyncParams{
+		RepoName: repoName,
+		State:    state,
+		Error:    errMsg,
+	})
+}
+
+func (m *MetaStore) CreatePushMirror(ctx context.Context, repoName, url string) (pg.PushMirror, error) {
+	return m.queries.CreatePushMirror(ctx, pg.CreatePushMirrorParams{
+		RepoName: repoName,
+		Url:      url,
+	})
+}
+
+func (m *MetaStore) GetPushMirror(ctx context.Context, repoName string, id int32) (pg.PushMirror, error) {
+	return m.queries.GetPushMirror(ctx, pg.GetPushMirrorParams{
+		RepoName: repoName,
+		ID:       id,
+	})
+}
+
+func (m *MetaStore) ListPushMirrors(ctx context.Context, repoName string) ([]pg.PushMirror, error) {
+	return m.queries.ListPushMirrors(ctx, repoName)
+}
+
+func (m *MetaStore) DeletePushMirror(ctx context.Context, repoName string, id int32) error {
+	n, err := m.queries.DeletePushMirror(ctx, pg.DeletePushMirrorParams{
+		RepoName: repoName,
+		ID:       id,
+	})
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RecordPushMirrorRun adds a run to the history of a push mirror and makes
+// its outcome the state of the mirror.
+func (m *MetaStore) RecordPushMirrorRun(ctx context.Context, arg pg.CreatePushMirrorRunParams) (pg.PushMirrorRun, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return pg.PushMirrorRun{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := m.queries.WithTx(tx)
+	run, err := q.CreatePushMirrorRun(ctx, arg)
+	if err != nil {
+		return pg.PushMirrorRun{}, err
+	}
+
+	err = q.UpdatePushMirrorState(ctx, pg.UpdatePushMirrorStateParams{
+		ID:        arg.MirrorID,
+		LastState: arg.State,
+		LastError: arg.Error,
+	})
+	if err != nil {
+		return pg.PushMirrorRun{}, err
+	}
+
+	return run, tx.Commit(ctx)
+}
+
+// ListPushMirrorRuns returns up to limit runs of a push mirror, newest first,
+// skipping the first skip ones.
+func (m *MetaStore) ListPushMirrorRuns(ctx context.Context, mirrorID, skip, limit int32) ([]pg.PushMirrorRun, error) {
+	return m.queries.ListPushMirrorRuns(ctx, pg.ListPushMirrorRunsParams{
+		MirrorID: mirrorID,
+		Skip:     skip,
+		MaxCount: limit,
+	})
+}
@@ -0,0 +1,16 @@
+-- migrate:up
+CREATE TABLE objects (
+    repo_name VARCHAR(255) NOT NULL REFERENCES repositories(name) ON DELETE CASCADE,
+    hash VARCHAR(40) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    size BIGINT NOT NULL,
+    loose_key VARCHAR(1024),
+    pack_key VARCHAR(1024),
+    pack_offset BIGINT,
+    PRIMARY KEY (repo_name, hash)
+);
+
+CREATE INDEX objects_repo_name_type_idx ON objects (repo_name, type, hash);
+
+-- migrate:down
+DROP TABLE objects;
@@ -0,0 +1,22 @@
+-- migrate:up
+-- Repositories are now created with a symbolic HEAD, which must follow
+-- renames.
+ALTER TABLE refs DROP CONSTRAINT refs_repo_name_fkey;
+ALTER TABLE refs ADD CONSTRAINT refs_repo_name_fkey
+    FOREIGN KEY (repo_name) REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+-- Point the HEAD of existing repositories to main, master or their first
+-- branch, in that order of preference.
+INSERT INTO refs (repo_name, ref_name, type, target)
+SELECT r.name, 'HEAD', 'symbolic', COALESCE(
+    (SELECT ref_name FROM refs WHERE repo_name = r.name AND ref_name = 'refs/heads/main'),
+    (SELECT ref_name FROM refs WHERE repo_name = r.name AND ref_name = 'refs/heads/master'),
+    (SELECT min(ref_name) FROM refs WHERE repo_name = r.name AND ref_name LIKE 'refs/heads/%'),
+    'refs/heads/main')
+FROM repositories r
+WHERE NOT EXISTS (SELECT 1 FROM refs WHERE repo_name = r.name AND ref_name = 'HEAD');
+
+-- migrate:down
+ALTER TABLE refs DROP CONSTRAINT refs_repo_name_fkey;
+ALTER TABLE refs ADD CONSTRAINT refs_repo_name_fkey
+    FOREIGN KEY (repo_name) REFERENCES repositories(name) ON DELETE CASCADE;
@@ -0,0 +1,50 @@
+-- migrate:up
+CREATE TABLE merge_requests (
+    repo_name VARCHAR(255) NOT NULL REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    author VARCHAR(255) NOT NULL,
+    source_branch VARCHAR(255) NOT NULL,
+    target_branch VARCHAR(255) NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'open',
+    base_commit VARCHAR(40),
+    merge_commit VARCHAR(40),
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    closed_at TIMESTAMP,
+    PRIMARY KEY (repo_name, number)
+);
+
+CREATE INDEX merge_requests_source_branch_idx ON merge_requests (repo_name, source_branch) WHERE state = 'open';
+
+CREATE TABLE merge_request_comments (
+    id SERIAL PRIMARY KEY,
+    repo_name VARCHAR(255) NOT NULL,
+    number INTEGER NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    commit_hash VARCHAR(40) NOT NULL,
+    path VARCHAR(4096),
+    line INTEGER,
+    side VARCHAR(10),
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    FOREIGN KEY (repo_name, number) REFERENCES merge_requests(repo_name, number) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX merge_request_comments_number_idx ON merge_request_comments (repo_name, number, id);
+
+CREATE TABLE merge_request_approvals (
+    repo_name VARCHAR(255) NOT NULL,
+    number INTEGER NOT NULL,
+    approver VARCHAR(255) NOT NULL,
+    commit_hash VARCHAR(40) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (repo_name, number, approver),
+    FOREIGN KEY (repo_name, number) REFERENCES merge_requests(repo_name, number) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE merge_request_approvals;
+DROP TABLE merge_request_comments;
+DROP TABLE merge_requests;
@@ -0,0 +1,16 @@
+-- migrate:up
+CREATE TABLE commit_statuses (
+    id SERIAL PRIMARY KEY,
+    repo_name VARCHAR(255) NOT NULL REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    commit_hash VARCHAR(40) NOT NULL,
+    state VARCHAR(20) NOT NULL,
+    context VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    target_url TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX commit_statuses_commit_idx ON commit_statuses (repo_name, commit_hash, context, id);
+
+-- migrate:down
+DROP TABLE commit_statuses;
@@ -0,0 +1,10 @@
+-- migrate:up
+-- A fork shares the objects of its parent, which cannot be deleted while it
+-- has forks.
+ALTER TABLE repositories ADD COLUMN parent_name VARCHAR(255)
+    REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE RESTRICT;
+
+CREATE INDEX repositories_parent_name_idx ON repositories (parent_name);
+
+-- migrate:down
+ALTER TABLE repositories DROP COLUMN parent_name;
@@ -0,0 +1,20 @@
+-- migrate:up
+CREATE TABLE import_jobs (
+    id SERIAL PRIMARY KEY,
+    repo_name VARCHAR(255) NOT NULL REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending',
+    progress TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX import_jobs_repo_name_idx ON import_jobs (repo_name, id);
+
+-- At most one import runs at a time for a repository.
+CREATE UNIQUE INDEX import_jobs_active_idx ON import_jobs (repo_name) WHERE state IN ('pending', 'running');
+
+-- migrate:down
+DROP TABLE import_jobs;
@@ -0,0 +1,18 @@
+-- migrate:up
+CREATE TABLE pull_mirrors (
+    repo_name VARCHAR(255) PRIMARY KEY REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    interval_seconds INTEGER NOT NULL,
+    next_sync_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_sync_at TIMESTAMP,
+    last_sync_state VARCHAR(20) NOT NULL DEFAULT '',
+    last_error TEXT NOT NULL DEFAULT '',
+    last_success_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX pull_mirrors_next_sync_at_idx ON pull_mirrors (next_sync_at);
+
+-- migrate:down
+DROP TABLE pull_mirrors;
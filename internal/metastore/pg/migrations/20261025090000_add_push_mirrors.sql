@@ -0,0 +1,29 @@
+-- migrate:up
+CREATE TABLE push_mirrors (
+    id SERIAL PRIMARY KEY,
+    repo_name VARCHAR(255) NOT NULL REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    last_state VARCHAR(20) NOT NULL DEFAULT '',
+    last_error TEXT NOT NULL DEFAULT '',
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX push_mirrors_repo_name_idx ON push_mirrors (repo_name, id);
+
+CREATE TABLE push_mirror_runs (
+    id SERIAL PRIMARY KEY,
+    mirror_id INTEGER NOT NULL REFERENCES push_mirrors(id) ON DELETE CASCADE,
+    state VARCHAR(20) NOT NULL,
+    refs TEXT[] NOT NULL,
+    attempts INTEGER NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX push_mirror_runs_mirror_id_idx ON push_mirror_runs (mirror_id, id);
+
+-- migrate:down
+DROP TABLE push_mirror_runs;
+DROP TABLE push_mirrors;
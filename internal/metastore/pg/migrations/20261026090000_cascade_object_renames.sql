@@ -0,0 +1,10 @@
+-- migrate:up
+-- The object index must follow renames, like the references do.
+ALTER TABLE objects DROP CONSTRAINT objects_repo_name_fkey;
+ALTER TABLE objects ADD CONSTRAINT objects_repo_name_fkey
+    FOREIGN KEY (repo_name) REFERENCES repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+-- migrate:down
+ALTER TABLE objects DROP CONSTRAINT objects_repo_name_fkey;
+ALTER TABLE objects ADD CONSTRAINT objects_repo_name_fkey
+    FOREIGN KEY (repo_name) REFERENCES repositories(name) ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+-- migrate:up
+-- Symbolic references written through the reference storage were stored with
+-- the type "symbolic-reference", which is not read as symbolic.
+UPDATE refs SET type = 'symbolic' WHERE type = 'symbolic-reference';
+
+-- migrate:down
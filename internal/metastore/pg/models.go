@@ -8,6 +8,110 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type CommitStatus struct {
+	ID          int32
+	RepoName    string
+	CommitHash  string
+	State       string
+	Context     string
+	Description string
+	TargetUrl   string
+	CreatedAt   pgtype.Timestamp
+}
+
+type ImportJob struct {
+	ID         int32
+	RepoName   string
+	Url        string
+	State      string
+	Progress   string
+	Error      string
+	CreatedAt  pgtype.Timestamp
+	UpdatedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
+}
+
+type MergeRequest struct {
+	RepoName     string
+	Number       int32
+	Title        string
+	Description  string
+	Author       string
+	SourceBranch string
+	TargetBranch string
+	State        string
+	BaseCommit   pgtype.Text
+	MergeCommit  pgtype.Text
+	CreatedAt    pgtype.Timestamp
+	UpdatedAt    pgtype.Timestamp
+	ClosedAt     pgtype.Timestamp
+}
+
+type MergeRequestApproval struct {
+	RepoName   string
+	Number     int32
+	Approver   string
+	CommitHash string
+	CreatedAt  pgtype.Timestamp
+}
+
+type MergeRequestComment struct {
+	ID         int32
+	RepoName   string
+	Number     int32
+	Author     string
+	Body       string
+	CommitHash string
+	Path       pgtype.Text
+	Line       pgtype.Int4
+	Side       pgtype.Text
+	CreatedAt  pgtype.Timestamp
+}
+
+type Object struct {
+	RepoName   string
+	Hash       string
+	Type       string
+	Size       int64
+	LooseKey   pgtype.Text
+	PackKey    pgtype.Text
+	PackOffset pgtype.Int8
+}
+
+type PullMirror struct {
+	RepoName        string
+	Url             string
+	IntervalSeconds int32
+	NextSyncAt      pgtype.Timestamp
+	LastSyncAt      pgtype.Timestamp
+	LastSyncState   string
+	LastError       string
+	LastSuccessAt   pgtype.Timestamp
+	CreatedAt       pgtype.Timestamp
+	UpdatedAt       pgtype.Timestamp
+}
+
+type PushMirror struct {
+	ID        int32
+	RepoName  string
+	Url       string
+	LastState string
+	LastError string
+	LastRunAt pgtype.Timestamp
+	CreatedAt pgtype.Timestamp
+}
+
+type PushMirrorRun struct {
+	ID         int32
+	MirrorID   int32
+	State      string
+	Refs       []string
+	Attempts   int32
+	Error      string
+	StartedAt  pgtype.Timestamp
+	FinishedAt pgtype.Timestamp
+}
+
 type Ref struct {
 	RepoName string
 	RefName  string
@@ -17,9 +121,10 @@ type Ref struct {
 }
 
 type Repository struct {
-	ID        int32
-	Name      string
-	CreatedAt pgtype.Timestamp
+	ID         int32
+	Name       string
+	CreatedAt  pgtype.Timestamp
+	ParentName pgtype.Text
 }
 
 type SchemaMigration struct {
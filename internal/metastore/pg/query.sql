@@ -13,11 +13,35 @@ UPDATE repositories SET name = sqlc.arg(new_name) WHERE name = sqlc.arg(old_name
 -- name: DeleteRepository :exec
 DELETE FROM repositories WHERE name = $1;
 
+-- name: SetRepositoryParent :execrows
+UPDATE repositories SET parent_name = sqlc.arg(parent_name)
+WHERE name = sqlc.arg(name) AND parent_name IS NULL;
+
+-- name: ListAlternates :many
+WITH RECURSIVE chain (name, parent_name, depth) AS (
+    SELECT r.name, r.parent_name, 0 FROM repositories r WHERE r.name = $1
+    UNION ALL
+    SELECT r.name, r.parent_name, c.depth + 1 FROM repositories r JOIN chain c ON r.name = c.parent_name
+)
+SELECT chain.name::varchar FROM chain WHERE depth > 0 ORDER BY depth;
+
+-- name: ListForks :many
+SELECT * FROM repositories WHERE parent_name = $1 ORDER BY name;
+
 -- name: GetRef :one
 SELECT * FROM refs WHERE repo_name = $1 AND ref_name = $2;
 
 -- name: ListRefs :many
-SELECT * FROM refs WHERE repo_name = $1;
+SELECT * FROM refs WHERE repo_name = $1 ORDER BY ref_name;
+
+-- name: ListRefsByPrefix :many
+SELECT * FROM refs
+WHERE repo_name = $1 AND starts_with(ref_name, sqlc.arg(prefix))
+ORDER BY ref_name
+LIMIT sqlc.arg(max_count) OFFSET sqlc.arg(skip);
+
+-- name: ListHeads :many
+SELECT repo_name, target FROM refs WHERE ref_name = 'HEAD' AND type = 'symbolic';
 
 -- name: PutRef :exec
 INSERT INTO refs (repo_name, ref_name, type, hash, target)
@@ -25,5 +49,188 @@ VALUES ($1, $2, $3, $4, $5)
 ON CONFLICT (repo_name, ref_name)
 DO UPDATE SET type = EXCLUDED.type, hash = EXCLUDED.hash, target = EXCLUDED.target;
 
+-- name: CopyRefs :exec
+INSERT INTO refs (repo_name, ref_name, type, hash, target)
+SELECT sqlc.arg(dst_repo_name), r.ref_name, r.type, r.hash, r.target FROM refs r
+WHERE r.repo_name = sqlc.arg(src_repo_name) AND (r.ref_name LIKE 'refs/heads/%' OR r.ref_name LIKE 'refs/tags/%');
+
 -- name: DeleteRef :exec
 DELETE FROM refs WHERE repo_name = $1 AND ref_name = $2;
+
+-- name: GetObject :one
+SELECT * FROM objects WHERE repo_name = $1 AND hash = $2;
+
+-- name: FindObjects :many
+SELECT * FROM objects WHERE repo_name = $1 AND hash = ANY(sqlc.arg(hashes)::varchar[]);
+
+-- name: FindSharedObjects :many
+SELECT * FROM objects
+WHERE repo_name = ANY(sqlc.arg(repo_names)::varchar[]) AND hash = ANY(sqlc.arg(hashes)::varchar[]);
+
+-- name: ListObjects :many
+SELECT * FROM objects
+WHERE repo_name = $1
+  AND (sqlc.narg(type)::varchar IS NULL OR type = sqlc.narg(type))
+  AND hash > sqlc.arg(after)
+ORDER BY hash
+LIMIT sqlc.arg(max_count);
+
+-- name: PutObject :exec
+INSERT INTO objects (repo_name, hash, type, size, loose_key, pack_key, pack_offset)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (repo_name, hash)
+DO UPDATE SET type = EXCLUDED.type, size = EXCLUDED.size, loose_key = EXCLUDED.loose_key,
+    pack_key = EXCLUDED.pack_key, pack_offset = EXCLUDED.pack_offset;
+
+-- name: TryLockRepository :one
+SELECT pg_try_advisory_lock(hashtextextended(sqlc.arg(repo_name)::text, 0))::boolean;
+
+-- name: UnlockRepository :one
+SELECT pg_advisory_unlock(hashtextextended(sqlc.arg(repo_name)::text, 0))::boolean;
+
+-- name: CreateMergeRequest :one
+INSERT INTO merge_requests (repo_name, number, title, description, author, source_branch, target_branch)
+SELECT sqlc.arg(repo_name), COALESCE(MAX(number), 0)::integer + 1, sqlc.arg(title), sqlc.arg(description),
+    sqlc.arg(author), sqlc.arg(source_branch), sqlc.arg(target_branch)
+FROM merge_requests WHERE repo_name = sqlc.arg(repo_name)
+RETURNING *;
+
+-- name: GetMergeRequest :one
+SELECT * FROM merge_requests WHERE repo_name = $1 AND number = $2;
+
+-- name: ListMergeRequests :many
+SELECT * FROM merge_requests
+WHERE repo_name = $1
+  AND (sqlc.narg(state)::varchar IS NULL OR state = sqlc.narg(state))
+ORDER BY number DESC
+LIMIT sqlc.arg(max_count) OFFSET sqlc.arg(skip);
+
+-- name: ListOpenMergeRequestsBySource :many
+SELECT number FROM merge_requests WHERE repo_name = $1 AND source_branch = $2 AND state = 'open';
+
+-- name: UpdateMergeRequest :one
+UPDATE merge_requests
+SET title = $3, description = $4, target_branch = $5, state = $6, base_commit = $7,
+    merge_commit = $8, closed_at = $9, updated_at = now()
+WHERE repo_name = $1 AND number = $2
+RETURNING *;
+
+-- name: CreateMergeRequestComment :one
+INSERT INTO merge_request_comments (repo_name, number, author, body, commit_hash, path, line, side)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: ListMergeRequestComments :many
+SELECT * FROM merge_request_comments WHERE repo_name = $1 AND number = $2 ORDER BY id;
+
+-- name: PutMergeRequestApproval :one
+INSERT INTO merge_request_approvals (repo_name, number, approver, commit_hash)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (repo_name, number, approver)
+DO UPDATE SET commit_hash = EXCLUDED.commit_hash, created_at = now()
+RETURNING *;
+
+-- name: DeleteMergeRequestApproval :execrows
+DELETE FROM merge_request_approvals WHERE repo_name = $1 AND number = $2 AND approver = $3;
+
+-- name: ListMergeRequestApprovals :many
+SELECT * FROM merge_request_approvals WHERE repo_name = $1 AND number = $2 ORDER BY created_at, approver;
+
+-- name: CreateCommitStatus :one
+INSERT INTO commit_statuses (repo_name, commit_hash, state, context, description, target_url)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListCommitStatuses :many
+SELECT * FROM commit_statuses
+WHERE repo_name = $1 AND commit_hash = $2
+ORDER BY id DESC
+LIMIT sqlc.arg(max_count) OFFSET sqlc.arg(skip);
+
+-- name: ListLatestCommitStatuses :many
+SELECT DISTINCT ON (context) * FROM commit_statuses
+WHERE repo_name = $1 AND commit_hash = $2
+ORDER BY context, id DESC;
+
+-- name: CreateImportJob :one
+INSERT INTO import_jobs (repo_name, url) VALUES ($1, $2) RETURNING *;
+
+-- name: GetImportJob :one
+SELECT * FROM import_jobs WHERE repo_name = $1 AND id = $2;
+
+-- name: ListImportJobs :many
+SELECT * FROM import_jobs
+WHERE repo_name = $1
+ORDER BY id DESC
+LIMIT sqlc.arg(max_count) OFFSET sqlc.arg(skip);
+
+-- name: UpdateImportJobProgress :exec
+UPDATE import_jobs SET state = $2, progress = $3, updated_at = now() WHERE id = $1;
+
+-- name: FinishImportJob :exec
+UPDATE import_jobs SET state = $2, error = $3, updated_at = now(), finished_at = now() WHERE id = $1;
+
+-- name: FailStaleImportJobs :exec
+UPDATE import_jobs SET state = 'failed', error = sqlc.arg(error), finished_at = now()
+WHERE repo_name = sqlc.arg(repo_name) AND state IN ('pending', 'running') AND updated_at < now() - make_interval(secs => sqlc.arg(stale_seconds)::float8);
+
+-- name: UpsertPullMirror :one
+INSERT INTO pull_mirrors (repo_name, url, interval_seconds) VALUES ($1, $2, $3)
+ON CONFLICT (repo_name) DO UPDATE
+SET url = EXCLUDED.url, interval_seconds = EXCLUDED.interval_seconds, next_sync_at = now(), updated_at = now()
+RETURNING *;
+
+-- name: GetPullMirror :one
+SELECT * FROM pull_mirrors WHERE repo_name = $1;
+
+-- name: IsPullMirror :one
+SELECT EXISTS (SELECT 1 FROM pull_mirrors WHERE repo_name = $1);
+
+-- name: DeletePullMirror :execrows
+DELETE FROM pull_mirrors WHERE repo_name = $1;
+
+-- name: ClaimDuePullMirrors :many
+UPDATE pull_mirrors SET next_sync_at = now() + make_interval(secs => interval_seconds)
+WHERE repo_name IN (
+    SELECT repo_name FROM pull_mirrors
+    WHERE next_sync_at <= now()
+    ORDER BY next_sync_at
+    LIMIT sqlc.arg(max_count)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RecordPullMirrorSync :one
+UPDATE pull_mirrors
+SET last_sync_at = now(),
+    last_sync_state = sqlc.arg(state),
+    last_error = sqlc.arg(error),
+    last_success_at = CASE WHEN sqlc.arg(error)::text = '' THEN now() ELSE last_success_at END
+WHERE repo_name = sqlc.arg(repo_name)
+RETURNING *;
+
+-- name: CreatePushMirror :one
+INSERT INTO push_mirrors (repo_name, url) VALUES ($1, $2) RETURNING *;
+
+-- name: GetPushMirror :one
+SELECT * FROM push_mirrors WHERE repo_name = $1 AND id = $2;
+
+-- name: ListPushMirrors :many
+SELECT * FROM push_mirrors WHERE repo_name = $1 ORDER BY id;
+
+-- name: DeletePushMirror :execrows
+DELETE FROM push_mirrors WHERE repo_name = $1 AND id = $2;
+
+-- name: CreatePushMirrorRun :one
+INSERT INTO push_mirror_runs (mirror_id, state, refs, attempts, error, started_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: UpdatePushMirrorState :exec
+UPDATE push_mirrors SET last_state = $2, last_error = $3, last_run_at = now() WHERE id = $1;
+
+-- name: ListPushMirrorRuns :many
+SELECT * FROM push_mirror_runs
+WHERE mirror_id = $1
+ORDER BY id DESC
+LIMIT sqlc.arg(max_count) OFFSET sqlc.arg(skip);
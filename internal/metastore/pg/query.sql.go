@@ -11,75 +11,1148 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const claimDuePullMirrors = `-- name: ClaimDuePullMirrors :many
+UPDATE pull_mirrors SET next_sync_at = now() + make_interval(secs => interval_seconds)
+WHERE repo_name IN (
+    SELECT repo_name FROM pull_mirrors
+    WHERE next_sync_at <= now()
+    ORDER BY next_sync_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING repo_name, url, interval_seconds, next_sync_at, last_sync_at, last_sync_state, last_error, last_success_at, created_at, updated_at
+`
+
+func (q *Queries) ClaimDuePullMirrors(ctx context.Context, maxCount int32) ([]PullMirror, error) {
+	rows, err := q.db.Query(ctx, claimDuePullMirrors, maxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PullMirror
+	for rows.Next() {
+		var i PullMirror
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Url,
+			&i.IntervalSeconds,
+			&i.NextSyncAt,
+			&i.LastSyncAt,
+			&i.LastSyncState,
+			&i.LastError,
+			&i.LastSuccessAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const copyRefs = `-- name: CopyRefs :exec
+INSERT INTO refs (repo_name, ref_name, type, hash, target)
+SELECT $1, r.ref_name, r.type, r.hash, r.target FROM refs r
+WHERE r.repo_name = $2 AND (r.ref_name LIKE 'refs/heads/%' OR r.ref_name LIKE 'refs/tags/%')
+`
+
+type CopyRefsParams struct {
+	DstRepoName string
+	SrcRepoName string
+}
+
+func (q *Queries) CopyRefs(ctx context.Context, arg CopyRefsParams) error {
+	_, err := q.db.Exec(ctx, copyRefs, arg.DstRepoName, arg.SrcRepoName)
+	return err
+}
+
+const createCommitStatus = `-- name: CreateCommitStatus :one
+INSERT INTO commit_statuses (repo_name, commit_hash, state, context, description, target_url)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, repo_name, commit_hash, state, context, description, target_url, created_at
+`
+
+type CreateCommitStatusParams struct {
+	RepoName    string
+	CommitHash  string
+	State       string
+	Context     string
+	Description string
+	TargetUrl   string
+}
+
+func (q *Queries) CreateCommitStatus(ctx context.Context, arg CreateCommitStatusParams) (CommitStatus, error) {
+	row := q.db.QueryRow(ctx, createCommitStatus,
+		arg.RepoName,
+		arg.CommitHash,
+		arg.State,
+		arg.Context,
+		arg.Description,
+		arg.TargetUrl,
+	)
+	var i CommitStatus
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.CommitHash,
+		&i.State,
+		&i.Context,
+		&i.Description,
+		&i.TargetUrl,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createImportJob = `-- name: CreateImportJob :one
+INSERT INTO import_jobs (repo_name, url) VALUES ($1, $2) RETURNING id, repo_name, url, state, progress, error, created_at, updated_at, finished_at
+`
+
+type CreateImportJobParams struct {
+	RepoName string
+	Url      string
+}
+
+func (q *Queries) CreateImportJob(ctx context.Context, arg CreateImportJobParams) (ImportJob, error) {
+	row := q.db.QueryRow(ctx, createImportJob, arg.RepoName, arg.Url)
+	var i ImportJob
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.Url,
+		&i.State,
+		&i.Progress,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const createMergeRequest = `-- name: CreateMergeRequest :one
+INSERT INTO merge_requests (repo_name, number, title, description, author, source_branch, target_branch)
+SELECT $1, COALESCE(MAX(number), 0)::integer + 1, $2, $3,
+    $4, $5, $6
+FROM merge_requests WHERE repo_name = $1
+RETURNING repo_name, number, title, description, author, source_branch, target_branch, state, base_commit, merge_commit, created_at, updated_at, closed_at
+`
+
+type CreateMergeRequestParams struct {
+	RepoName     string
+	Title        string
+	Description  string
+	Author       string
+	SourceBranch string
+	TargetBranch string
+}
+
+func (q *Queries) CreateMergeRequest(ctx context.Context, arg CreateMergeRequestParams) (MergeRequest, error) {
+	row := q.db.QueryRow(ctx, createMergeRequest,
+		arg.RepoName,
+		arg.Title,
+		arg.Description,
+		arg.Author,
+		arg.SourceBranch,
+		arg.TargetBranch,
+	)
+	var i MergeRequest
+	err := row.Scan(
+		&i.RepoName,
+		&i.Number,
+		&i.Title,
+		&i.Description,
+		&i.Author,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.State,
+		&i.BaseCommit,
+		&i.MergeCommit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ClosedAt,
+	)
+	return i, err
+}
+
+const createMergeRequestComment = `-- name: CreateMergeRequestComment :one
+INSERT INTO merge_request_comments (repo_name, number, author, body, commit_hash, path, line, side)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, repo_name, number, author, body, commit_hash, path, line, side, created_at
+`
+
+type CreateMergeRequestCommentParams struct {
+	RepoName   string
+	Number     int32
+	Author     string
+	Body       string
+	CommitHash string
+	Path       pgtype.Text
+	Line       pgtype.Int4
+	Side       pgtype.Text
+}
+
+func (q *Queries) CreateMergeRequestComment(ctx context.Context, arg CreateMergeRequestCommentParams) (MergeRequestComment, error) {
+	row := q.db.QueryRow(ctx, createMergeRequestComment,
+		arg.RepoName,
+		arg.Number,
+		arg.Author,
+		arg.Body,
+		arg.CommitHash,
+		arg.Path,
+		arg.Line,
+		arg.Side,
+	)
+	var i MergeRequestComment
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.Number,
+		&i.Author,
+		&i.Body,
+		&i.CommitHash,
+		&i.Path,
+		&i.Line,
+		&i.Side,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createPushMirror = `-- name: CreatePushMirror :one
+INSERT INTO push_mirrors (repo_name, url) VALUES ($1, $2) RETURNING id, repo_name, url, last_state, last_error, last_run_at, created_at
+`
+
+type CreatePushMirrorParams struct {
+	RepoName string
+	Url      string
+}
+
+func (q *Queries) CreatePushMirror(ctx context.Context, arg CreatePushMirrorParams) (PushMirror, error) {
+	row := q.db.QueryRow(ctx, createPushMirror, arg.RepoName, arg.Url)
+	var i PushMirror
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.Url,
+		&i.LastState,
+		&i.LastError,
+		&i.LastRunAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createPushMirrorRun = `-- name: CreatePushMirrorRun :one
+INSERT INTO push_mirror_runs (mirror_id, state, refs, attempts, error, started_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, mirror_id, state, refs, attempts, error, started_at, finished_at
+`
+
+type CreatePushMirrorRunParams struct {
+	MirrorID  int32
+	State     string
+	Refs      []string
+	Attempts  int32
+	Error     string
+	StartedAt pgtype.Timestamp
+}
+
+func (q *Queries) CreatePushMirrorRun(ctx context.Context, arg CreatePushMirrorRunParams) (PushMirrorRun, error) {
+	row := q.db.QueryRow(ctx, createPushMirrorRun,
+		arg.MirrorID,
+		arg.State,
+		arg.Refs,
+		arg.Attempts,
+		arg.Error,
+		arg.StartedAt,
+	)
+	var i PushMirrorRun
+	err := row.Scan(
+		&i.ID,
+		&i.MirrorID,
+		&i.State,
+		&i.Refs,
+		&i.Attempts,
+		&i.Error,
+		&i.StartedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
 const createRepository = `-- name: CreateRepository :one
-INSERT INTO repositories (name) VALUES ($1) RETURNING id, name, created_at
+INSERT INTO repositories (name) VALUES ($1) RETURNING id, name, created_at, parent_name
+`
+
+func (q *Queries) CreateRepository(ctx context.Context, name string) (Repository, error) {
+	row := q.db.QueryRow(ctx, createRepository, name)
+	var i Repository
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.ParentName,
+	)
+	return i, err
+}
+
+const deleteMergeRequestApproval = `-- name: DeleteMergeRequestApproval :execrows
+DELETE FROM merge_request_approvals WHERE repo_name = $1 AND number = $2 AND approver = $3
+`
+
+type DeleteMergeRequestApprovalParams struct {
+	RepoName string
+	Number   int32
+	Approver string
+}
+
+func (q *Queries) DeleteMergeRequestApproval(ctx context.Context, arg DeleteMergeRequestApprovalParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteMergeRequestApproval, arg.RepoName, arg.Number, arg.Approver)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePullMirror = `-- name: DeletePullMirror :execrows
+DELETE FROM pull_mirrors WHERE repo_name = $1
+`
+
+func (q *Queries) DeletePullMirror(ctx context.Context, repoName string) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePullMirror, repoName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePushMirror = `-- name: DeletePushMirror :execrows
+DELETE FROM push_mirrors WHERE repo_name = $1 AND id = $2
+`
+
+type DeletePushMirrorParams struct {
+	RepoName string
+	ID       int32
+}
+
+func (q *Queries) DeletePushMirror(ctx context.Context, arg DeletePushMirrorParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePushMirror, arg.RepoName, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteRef = `-- name: DeleteRef :exec
+DELETE FROM refs WHERE repo_name = $1 AND ref_name = $2
+`
+
+type DeleteRefParams struct {
+	RepoName string
+	RefName  string
+}
+
+func (q *Queries) DeleteRef(ctx context.Context, arg DeleteRefParams) error {
+	_, err := q.db.Exec(ctx, deleteRef, arg.RepoName, arg.RefName)
+	return err
+}
+
+const deleteRepository = `-- name: DeleteRepository :exec
+DELETE FROM repositories WHERE name = $1
+`
+
+func (q *Queries) DeleteRepository(ctx context.Context, name string) error {
+	_, err := q.db.Exec(ctx, deleteRepository, name)
+	return err
+}
+
+const failStaleImportJobs = `-- name: FailStaleImportJobs :exec
+UPDATE import_jobs SET state = 'failed', error = $1, finished_at = now()
+WHERE repo_name = $2 AND state IN ('pending', 'running') AND updated_at < now() - make_interval(secs => $3::float8)
+`
+
+type FailStaleImportJobsParams struct {
+	Error        string
+	RepoName     string
+	StaleSeconds float64
+}
+
+func (q *Queries) FailStaleImportJobs(ctx context.Context, arg FailStaleImportJobsParams) error {
+	_, err := q.db.Exec(ctx, failStaleImportJobs, arg.Error, arg.RepoName, arg.StaleSeconds)
+	return err
+}
+
+const findObjects = `-- name: FindObjects :many
+SELECT repo_name, hash, type, size, loose_key, pack_key, pack_offset FROM objects WHERE repo_name = $1 AND hash = ANY($2::varchar[])
+`
+
+type FindObjectsParams struct {
+	RepoName string
+	Hashes   []string
+}
+
+func (q *Queries) FindObjects(ctx context.Context, arg FindObjectsParams) ([]Object, error) {
+	rows, err := q.db.Query(ctx, findObjects, arg.RepoName, arg.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Object
+	for rows.Next() {
+		var i Object
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Hash,
+			&i.Type,
+			&i.Size,
+			&i.LooseKey,
+			&i.PackKey,
+			&i.PackOffset,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findSharedObjects = `-- name: FindSharedObjects :many
+SELECT repo_name, hash, type, size, loose_key, pack_key, pack_offset FROM objects
+WHERE repo_name = ANY($1::varchar[]) AND hash = ANY($2::varchar[])
+`
+
+type FindSharedObjectsParams struct {
+	RepoNames []string
+	Hashes    []string
+}
+
+func (q *Queries) FindSharedObjects(ctx context.Context, arg FindSharedObjectsParams) ([]Object, error) {
+	rows, err := q.db.Query(ctx, findSharedObjects, arg.RepoNames, arg.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Object
+	for rows.Next() {
+		var i Object
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Hash,
+			&i.Type,
+			&i.Size,
+			&i.LooseKey,
+			&i.PackKey,
+			&i.PackOffset,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const finishImportJob = `-- name: FinishImportJob :exec
+UPDATE import_jobs SET state = $2, error = $3, updated_at = now(), finished_at = now() WHERE id = $1
+`
+
+type FinishImportJobParams struct {
+	ID    int32
+	State string
+	Error string
+}
+
+func (q *Queries) FinishImportJob(ctx context.Context, arg FinishImportJobParams) error {
+	_, err := q.db.Exec(ctx, finishImportJob, arg.ID, arg.State, arg.Error)
+	return err
+}
+
+const getImportJob = `-- name: GetImportJob :one
+SELECT id, repo_name, url, state, progress, error, created_at, updated_at, finished_at FROM import_jobs WHERE repo_name = $1 AND id = $2
+`
+
+type GetImportJobParams struct {
+	RepoName string
+	ID       int32
+}
+
+func (q *Queries) GetImportJob(ctx context.Context, arg GetImportJobParams) (ImportJob, error) {
+	row := q.db.QueryRow(ctx, getImportJob, arg.RepoName, arg.ID)
+	var i ImportJob
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.Url,
+		&i.State,
+		&i.Progress,
+		&i.Error,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const getMergeRequest = `-- name: GetMergeRequest :one
+SELECT repo_name, number, title, description, author, source_branch, target_branch, state, base_commit, merge_commit, created_at, updated_at, closed_at FROM merge_requests WHERE repo_name = $1 AND number = $2
+`
+
+type GetMergeRequestParams struct {
+	RepoName string
+	Number   int32
+}
+
+func (q *Queries) GetMergeRequest(ctx context.Context, arg GetMergeRequestParams) (MergeRequest, error) {
+	row := q.db.QueryRow(ctx, getMergeRequest, arg.RepoName, arg.Number)
+	var i MergeRequest
+	err := row.Scan(
+		&i.RepoName,
+		&i.Number,
+		&i.Title,
+		&i.Description,
+		&i.Author,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.State,
+		&i.BaseCommit,
+		&i.MergeCommit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ClosedAt,
+	)
+	return i, err
+}
+
+const getObject = `-- name: GetObject :one
+SELECT repo_name, hash, type, size, loose_key, pack_key, pack_offset FROM objects WHERE repo_name = $1 AND hash = $2
+`
+
+type GetObjectParams struct {
+	RepoName string
+	Hash     string
+}
+
+func (q *Queries) GetObject(ctx context.Context, arg GetObjectParams) (Object, error) {
+	row := q.db.QueryRow(ctx, getObject, arg.RepoName, arg.Hash)
+	var i Object
+	err := row.Scan(
+		&i.RepoName,
+		&i.Hash,
+		&i.Type,
+		&i.Size,
+		&i.LooseKey,
+		&i.PackKey,
+		&i.PackOffset,
+	)
+	return i, err
+}
+
+const getPullMirror = `-- name: GetPullMirror :one
+SELECT repo_name, url, interval_seconds, next_sync_at, last_sync_at, last_sync_state, last_error, last_success_at, created_at, updated_at FROM pull_mirrors WHERE repo_name = $1
+`
+
+func (q *Queries) GetPullMirror(ctx context.Context, repoName string) (PullMirror, error) {
+	row := q.db.QueryRow(ctx, getPullMirror, repoName)
+	var i PullMirror
+	err := row.Scan(
+		&i.RepoName,
+		&i.Url,
+		&i.IntervalSeconds,
+		&i.NextSyncAt,
+		&i.LastSyncAt,
+		&i.LastSyncState,
+		&i.LastError,
+		&i.LastSuccessAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getPushMirror = `-- name: GetPushMirror :one
+SELECT id, repo_name, url, last_state, last_error, last_run_at, created_at FROM push_mirrors WHERE repo_name = $1 AND id = $2
+`
+
+type GetPushMirrorParams struct {
+	RepoName string
+	ID       int32
+}
+
+func (q *Queries) GetPushMirror(ctx context.Context, arg GetPushMirrorParams) (PushMirror, error) {
+	row := q.db.QueryRow(ctx, getPushMirror, arg.RepoName, arg.ID)
+	var i PushMirror
+	err := row.Scan(
+		&i.ID,
+		&i.RepoName,
+		&i.Url,
+		&i.LastState,
+		&i.LastError,
+		&i.LastRunAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRef = `-- name: GetRef :one
+SELECT repo_name, ref_name, type, hash, target FROM refs WHERE repo_name = $1 AND ref_name = $2
+`
+
+type GetRefParams struct {
+	RepoName string
+	RefName  string
+}
+
+func (q *Queries) GetRef(ctx context.Context, arg GetRefParams) (Ref, error) {
+	row := q.db.QueryRow(ctx, getRef, arg.RepoName, arg.RefName)
+	var i Ref
+	err := row.Scan(
+		&i.RepoName,
+		&i.RefName,
+		&i.Type,
+		&i.Hash,
+		&i.Target,
+	)
+	return i, err
+}
+
+const getRepository = `-- name: GetRepository :one
+SELECT id, name, created_at, parent_name FROM repositories WHERE name = $1
+`
+
+func (q *Queries) GetRepository(ctx context.Context, name string) (Repository, error) {
+	row := q.db.QueryRow(ctx, getRepository, name)
+	var i Repository
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.ParentName,
+	)
+	return i, err
+}
+
+const isPullMirror = `-- name: IsPullMirror :one
+SELECT EXISTS (SELECT 1 FROM pull_mirrors WHERE repo_name = $1)
+`
+
+func (q *Queries) IsPullMirror(ctx context.Context, repoName string) (bool, error) {
+	row := q.db.QueryRow(ctx, isPullMirror, repoName)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listAlternates = `-- name: ListAlternates :many
+WITH RECURSIVE chain (name, parent_name, depth) AS (
+    SELECT r.name, r.parent_name, 0 FROM repositories r WHERE r.name = $1
+    UNION ALL
+    SELECT r.name, r.parent_name, c.depth + 1 FROM repositories r JOIN chain c ON r.name = c.parent_name
+)
+SELECT chain.name::varchar FROM chain WHERE depth > 0 ORDER BY depth
+`
+
+func (q *Queries) ListAlternates(ctx context.Context, name string) ([]string, error) {
+	rows, err := q.db.Query(ctx, listAlternates, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var chain_name string
+		if err := rows.Scan(&chain_name); err != nil {
+			return nil, err
+		}
+		items = append(items, chain_name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCommitStatuses = `-- name: ListCommitStatuses :many
+SELECT id, repo_name, commit_hash, state, context, description, target_url, created_at FROM commit_statuses
+WHERE repo_name = $1 AND commit_hash = $2
+ORDER BY id DESC
+LIMIT $4 OFFSET $3
+`
+
+type ListCommitStatusesParams struct {
+	RepoName   string
+	CommitHash string
+	Skip       int32
+	MaxCount   int32
+}
+
+func (q *Queries) ListCommitStatuses(ctx context.Context, arg ListCommitStatusesParams) ([]CommitStatus, error) {
+	rows, err := q.db.Query(ctx, listCommitStatuses,
+		arg.RepoName,
+		arg.CommitHash,
+		arg.Skip,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CommitStatus
+	for rows.Next() {
+		var i CommitStatus
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoName,
+			&i.CommitHash,
+			&i.State,
+			&i.Context,
+			&i.Description,
+			&i.TargetUrl,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listForks = `-- name: ListForks :many
+SELECT id, name, created_at, parent_name FROM repositories WHERE parent_name = $1 ORDER BY name
+`
+
+func (q *Queries) ListForks(ctx context.Context, parentName pgtype.Text) ([]Repository, error) {
+	rows, err := q.db.Query(ctx, listForks, parentName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Repository
+	for rows.Next() {
+		var i Repository
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.ParentName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHeads = `-- name: ListHeads :many
+SELECT repo_name, target FROM refs WHERE ref_name = 'HEAD' AND type = 'symbolic'
+`
+
+type ListHeadsRow struct {
+	RepoName string
+	Target   pgtype.Text
+}
+
+func (q *Queries) ListHeads(ctx context.Context) ([]ListHeadsRow, error) {
+	rows, err := q.db.Query(ctx, listHeads)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListHeadsRow
+	for rows.Next() {
+		var i ListHeadsRow
+		if err := rows.Scan(&i.RepoName, &i.Target); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImportJobs = `-- name: ListImportJobs :many
+SELECT id, repo_name, url, state, progress, error, created_at, updated_at, finished_at FROM import_jobs
+WHERE repo_name = $1
+ORDER BY id DESC
+LIMIT $3 OFFSET $2
+`
+
+type ListImportJobsParams struct {
+	RepoName string
+	Skip     int32
+	MaxCount int32
+}
+
+func (q *Queries) ListImportJobs(ctx context.Context, arg ListImportJobsParams) ([]ImportJob, error) {
+	rows, err := q.db.Query(ctx, listImportJobs, arg.RepoName, arg.Skip, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ImportJob
+	for rows.Next() {
+		var i ImportJob
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoName,
+			&i.Url,
+			&i.State,
+			&i.Progress,
+			&i.Error,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLatestCommitStatuses = `-- name: ListLatestCommitStatuses :many
+SELECT DISTINCT ON (context) id, repo_name, commit_hash, state, context, description, target_url, created_at FROM commit_statuses
+WHERE repo_name = $1 AND commit_hash = $2
+ORDER BY context, id DESC
+`
+
+type ListLatestCommitStatusesParams struct {
+	RepoName   string
+	CommitHash string
+}
+
+func (q *Queries) ListLatestCommitStatuses(ctx context.Context, arg ListLatestCommitStatusesParams) ([]CommitStatus, error) {
+	rows, err := q.db.Query(ctx, listLatestCommitStatuses, arg.RepoName, arg.CommitHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CommitStatus
+	for rows.Next() {
+		var i CommitStatus
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoName,
+			&i.CommitHash,
+			&i.State,
+			&i.Context,
+			&i.Description,
+			&i.TargetUrl,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMergeRequestApprovals = `-- name: ListMergeRequestApprovals :many
+SELECT repo_name, number, approver, commit_hash, created_at FROM merge_request_approvals WHERE repo_name = $1 AND number = $2 ORDER BY created_at, approver
+`
+
+type ListMergeRequestApprovalsParams struct {
+	RepoName string
+	Number   int32
+}
+
+func (q *Queries) ListMergeRequestApprovals(ctx context.Context, arg ListMergeRequestApprovalsParams) ([]MergeRequestApproval, error) {
+	rows, err := q.db.Query(ctx, listMergeRequestApprovals, arg.RepoName, arg.Number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MergeRequestApproval
+	for rows.Next() {
+		var i MergeRequestApproval
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Number,
+			&i.Approver,
+			&i.CommitHash,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMergeRequestComments = `-- name: ListMergeRequestComments :many
+SELECT id, repo_name, number, author, body, commit_hash, path, line, side, created_at FROM merge_request_comments WHERE repo_name = $1 AND number = $2 ORDER BY id
+`
+
+type ListMergeRequestCommentsParams struct {
+	RepoName string
+	Number   int32
+}
+
+func (q *Queries) ListMergeRequestComments(ctx context.Context, arg ListMergeRequestCommentsParams) ([]MergeRequestComment, error) {
+	rows, err := q.db.Query(ctx, listMergeRequestComments, arg.RepoName, arg.Number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MergeRequestComment
+	for rows.Next() {
+		var i MergeRequestComment
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoName,
+			&i.Number,
+			&i.Author,
+			&i.Body,
+			&i.CommitHash,
+			&i.Path,
+			&i.Line,
+			&i.Side,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMergeRequests = `-- name: ListMergeRequests :many
+SELECT repo_name, number, title, description, author, source_branch, target_branch, state, base_commit, merge_commit, created_at, updated_at, closed_at FROM merge_requests
+WHERE repo_name = $1
+  AND ($2::varchar IS NULL OR state = $2)
+ORDER BY number DESC
+LIMIT $4 OFFSET $3
 `
 
-func (q *Queries) CreateRepository(ctx context.Context, name string) (Repository, error) {
-	row := q.db.QueryRow(ctx, createRepository, name)
-	var i Repository
-	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
-	return i, err
+type ListMergeRequestsParams struct {
+	RepoName string
+	State    pgtype.Text
+	Skip     int32
+	MaxCount int32
 }
 
-const deleteRef = `-- name: DeleteRef :exec
-DELETE FROM refs WHERE repo_name = $1 AND ref_name = $2
+func (q *Queries) ListMergeRequests(ctx context.Context, arg ListMergeRequestsParams) ([]MergeRequest, error) {
+	rows, err := q.db.Query(ctx, listMergeRequests,
+		arg.RepoName,
+		arg.State,
+		arg.Skip,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MergeRequest
+	for rows.Next() {
+		var i MergeRequest
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Number,
+			&i.Title,
+			&i.Description,
+			&i.Author,
+			&i.SourceBranch,
+			&i.TargetBranch,
+			&i.State,
+			&i.BaseCommit,
+			&i.MergeCommit,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ClosedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listObjects = `-- name: ListObjects :many
+SELECT repo_name, hash, type, size, loose_key, pack_key, pack_offset FROM objects
+WHERE repo_name = $1
+  AND ($2::varchar IS NULL OR type = $2)
+  AND hash > $3
+ORDER BY hash
+LIMIT $4
 `
 
-type DeleteRefParams struct {
+type ListObjectsParams struct {
 	RepoName string
-	RefName  string
+	Type     pgtype.Text
+	After    string
+	MaxCount int32
 }
 
-func (q *Queries) DeleteRef(ctx context.Context, arg DeleteRefParams) error {
-	_, err := q.db.Exec(ctx, deleteRef, arg.RepoName, arg.RefName)
-	return err
+func (q *Queries) ListObjects(ctx context.Context, arg ListObjectsParams) ([]Object, error) {
+	rows, err := q.db.Query(ctx, listObjects,
+		arg.RepoName,
+		arg.Type,
+		arg.After,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Object
+	for rows.Next() {
+		var i Object
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.Hash,
+			&i.Type,
+			&i.Size,
+			&i.LooseKey,
+			&i.PackKey,
+			&i.PackOffset,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const deleteRepository = `-- name: DeleteRepository :exec
-DELETE FROM repositories WHERE name = $1
+const listOpenMergeRequestsBySource = `-- name: ListOpenMergeRequestsBySource :many
+SELECT number FROM merge_requests WHERE repo_name = $1 AND source_branch = $2 AND state = 'open'
 `
 
-func (q *Queries) DeleteRepository(ctx context.Context, name string) error {
-	_, err := q.db.Exec(ctx, deleteRepository, name)
-	return err
+type ListOpenMergeRequestsBySourceParams struct {
+	RepoName     string
+	SourceBranch string
 }
 
-const getRef = `-- name: GetRef :one
-SELECT repo_name, ref_name, type, hash, target FROM refs WHERE repo_name = $1 AND ref_name = $2
+func (q *Queries) ListOpenMergeRequestsBySource(ctx context.Context, arg ListOpenMergeRequestsBySourceParams) ([]int32, error) {
+	rows, err := q.db.Query(ctx, listOpenMergeRequestsBySource, arg.RepoName, arg.SourceBranch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int32
+	for rows.Next() {
+		var number int32
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		items = append(items, number)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPushMirrorRuns = `-- name: ListPushMirrorRuns :many
+SELECT id, mirror_id, state, refs, attempts, error, started_at, finished_at FROM push_mirror_runs
+WHERE mirror_id = $1
+ORDER BY id DESC
+LIMIT $3 OFFSET $2
 `
 
-type GetRefParams struct {
-	RepoName string
-	RefName  string
+type ListPushMirrorRunsParams struct {
+	MirrorID int32
+	Skip     int32
+	MaxCount int32
 }
 
-func (q *Queries) GetRef(ctx context.Context, arg GetRefParams) (Ref, error) {
-	row := q.db.QueryRow(ctx, getRef, arg.RepoName, arg.RefName)
-	var i Ref
-	err := row.Scan(
-		&i.RepoName,
-		&i.RefName,
-		&i.Type,
-		&i.Hash,
-		&i.Target,
-	)
-	return i, err
+func (q *Queries) ListPushMirrorRuns(ctx context.Context, arg ListPushMirrorRunsParams) ([]PushMirrorRun, error) {
+	rows, err := q.db.Query(ctx, listPushMirrorRuns, arg.MirrorID, arg.Skip, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PushMirrorRun
+	for rows.Next() {
+		var i PushMirrorRun
+		if err := rows.Scan(
+			&i.ID,
+			&i.MirrorID,
+			&i.State,
+			&i.Refs,
+			&i.Attempts,
+			&i.Error,
+			&i.StartedAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getRepository = `-- name: GetRepository :one
-SELECT id, name, created_at FROM repositories WHERE name = $1
+const listPushMirrors = `-- name: ListPushMirrors :many
+SELECT id, repo_name, url, last_state, last_error, last_run_at, created_at FROM push_mirrors WHERE repo_name = $1 ORDER BY id
 `
 
-func (q *Queries) GetRepository(ctx context.Context, name string) (Repository, error) {
-	row := q.db.QueryRow(ctx, getRepository, name)
-	var i Repository
-	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
-	return i, err
+func (q *Queries) ListPushMirrors(ctx context.Context, repoName string) ([]PushMirror, error) {
+	rows, err := q.db.Query(ctx, listPushMirrors, repoName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PushMirror
+	for rows.Next() {
+		var i PushMirror
+		if err := rows.Scan(
+			&i.ID,
+			&i.RepoName,
+			&i.Url,
+			&i.LastState,
+			&i.LastError,
+			&i.LastRunAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const listRefs = `-- name: ListRefs :many
-SELECT repo_name, ref_name, type, hash, target FROM refs WHERE repo_name = $1
+SELECT repo_name, ref_name, type, hash, target FROM refs WHERE repo_name = $1 ORDER BY ref_name
 `
 
 func (q *Queries) ListRefs(ctx context.Context, repoName string) ([]Ref, error) {
@@ -108,8 +1181,53 @@ func (q *Queries) ListRefs(ctx context.Context, repoName string) ([]Ref, error)
 	return items, nil
 }
 
+const listRefsByPrefix = `-- name: ListRefsByPrefix :many
+SELECT repo_name, ref_name, type, hash, target FROM refs
+WHERE repo_name = $1 AND starts_with(ref_name, $2)
+ORDER BY ref_name
+LIMIT $4 OFFSET $3
+`
+
+type ListRefsByPrefixParams struct {
+	RepoName string
+	Prefix   string
+	Skip     int32
+	MaxCount int32
+}
+
+func (q *Queries) ListRefsByPrefix(ctx context.Context, arg ListRefsByPrefixParams) ([]Ref, error) {
+	rows, err := q.db.Query(ctx, listRefsByPrefix,
+		arg.RepoName,
+		arg.Prefix,
+		arg.Skip,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ref
+	for rows.Next() {
+		var i Ref
+		if err := rows.Scan(
+			&i.RepoName,
+			&i.RefName,
+			&i.Type,
+			&i.Hash,
+			&i.Target,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listRepositories = `-- name: ListRepositories :many
-SELECT id, name, created_at FROM repositories ORDER BY name
+SELECT id, name, created_at, parent_name FROM repositories ORDER BY name
 `
 
 func (q *Queries) ListRepositories(ctx context.Context) ([]Repository, error) {
@@ -121,7 +1239,12 @@ func (q *Queries) ListRepositories(ctx context.Context) ([]Repository, error) {
 	var items []Repository
 	for rows.Next() {
 		var i Repository
-		if err := rows.Scan(&i.ID, &i.Name, &i.CreatedAt); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.ParentName,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -132,6 +1255,70 @@ func (q *Queries) ListRepositories(ctx context.Context) ([]Repository, error) {
 	return items, nil
 }
 
+const putMergeRequestApproval = `-- name: PutMergeRequestApproval :one
+INSERT INTO merge_request_approvals (repo_name, number, approver, commit_hash)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (repo_name, number, approver)
+DO UPDATE SET commit_hash = EXCLUDED.commit_hash, created_at = now()
+RETURNING repo_name, number, approver, commit_hash, created_at
+`
+
+type PutMergeRequestApprovalParams struct {
+	RepoName   string
+	Number     int32
+	Approver   string
+	CommitHash string
+}
+
+func (q *Queries) PutMergeRequestApproval(ctx context.Context, arg PutMergeRequestApprovalParams) (MergeRequestApproval, error) {
+	row := q.db.QueryRow(ctx, putMergeRequestApproval,
+		arg.RepoName,
+		arg.Number,
+		arg.Approver,
+		arg.CommitHash,
+	)
+	var i MergeRequestApproval
+	err := row.Scan(
+		&i.RepoName,
+		&i.Number,
+		&i.Approver,
+		&i.CommitHash,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const putObject = `-- name: PutObject :exec
+INSERT INTO objects (repo_name, hash, type, size, loose_key, pack_key, pack_offset)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (repo_name, hash)
+DO UPDATE SET type = EXCLUDED.type, size = EXCLUDED.size, loose_key = EXCLUDED.loose_key,
+    pack_key = EXCLUDED.pack_key, pack_offset = EXCLUDED.pack_offset
+`
+
+type PutObjectParams struct {
+	RepoName   string
+	Hash       string
+	Type       string
+	Size       int64
+	LooseKey   pgtype.Text
+	PackKey    pgtype.Text
+	PackOffset pgtype.Int8
+}
+
+func (q *Queries) PutObject(ctx context.Context, arg PutObjectParams) error {
+	_, err := q.db.Exec(ctx, putObject,
+		arg.RepoName,
+		arg.Hash,
+		arg.Type,
+		arg.Size,
+		arg.LooseKey,
+		arg.PackKey,
+		arg.PackOffset,
+	)
+	return err
+}
+
 const putRef = `-- name: PutRef :exec
 INSERT INTO refs (repo_name, ref_name, type, hash, target)
 VALUES ($1, $2, $3, $4, $5)
@@ -158,8 +1345,163 @@ func (q *Queries) PutRef(ctx context.Context, arg PutRefParams) error {
 	return err
 }
 
+const recordPullMirrorSync = `-- name: RecordPullMirrorSync :one
+UPDATE pull_mirrors
+SET last_sync_at = now(),
+    last_sync_state = $1,
+    last_error = $2,
+    last_success_at = CASE WHEN $2::text = '' THEN now() ELSE last_success_at END
+WHERE repo_name = $3
+RETURNING repo_name, url, interval_seconds, next_sync_at, last_sync_at, last_sync_state, last_error, last_success_at, created_at, updated_at
+`
+
+type RecordPullMirrorSyncParams struct {
+	State    string
+	Error    string
+	RepoName string
+}
+
+func (q *Queries) RecordPullMirrorSync(ctx context.Context, arg RecordPullMirrorSyncParams) (PullMirror, error) {
+	row := q.db.QueryRow(ctx, recordPullMirrorSync, arg.State, arg.Error, arg.RepoName)
+	var i PullMirror
+	err := row.Scan(
+		&i.RepoName,
+		&i.Url,
+		&i.IntervalSeconds,
+		&i.NextSyncAt,
+		&i.LastSyncAt,
+		&i.LastSyncState,
+		&i.LastError,
+		&i.LastSuccessAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const setRepositoryParent = `-- name: SetRepositoryParent :execrows
+UPDATE repositories SET parent_name = $1
+WHERE name = $2 AND parent_name IS NULL
+`
+
+type SetRepositoryParentParams struct {
+	ParentName pgtype.Text
+	Name       string
+}
+
+func (q *Queries) SetRepositoryParent(ctx context.Context, arg SetRepositoryParentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setRepositoryParent, arg.ParentName, arg.Name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const tryLockRepository = `-- name: TryLockRepository :one
+SELECT pg_try_advisory_lock(hashtextextended($1::text, 0))::boolean
+`
+
+func (q *Queries) TryLockRepository(ctx context.Context, repoName string) (bool, error) {
+	row := q.db.QueryRow(ctx, tryLockRepository, repoName)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const unlockRepository = `-- name: UnlockRepository :one
+SELECT pg_advisory_unlock(hashtextextended($1::text, 0))::boolean
+`
+
+func (q *Queries) UnlockRepository(ctx context.Context, repoName string) (bool, error) {
+	row := q.db.QueryRow(ctx, unlockRepository, repoName)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const updateImportJobProgress = `-- name: UpdateImportJobProgress :exec
+UPDATE import_jobs SET state = $2, progress = $3, updated_at = now() WHERE id = $1
+`
+
+type UpdateImportJobProgressParams struct {
+	ID       int32
+	State    string
+	Progress string
+}
+
+func (q *Queries) UpdateImportJobProgress(ctx context.Context, arg UpdateImportJobProgressParams) error {
+	_, err := q.db.Exec(ctx, updateImportJobProgress, arg.ID, arg.State, arg.Progress)
+	return err
+}
+
+const updateMergeRequest = `-- name: UpdateMergeRequest :one
+UPDATE merge_requests
+SET title = $3, description = $4, target_branch = $5, state = $6, base_commit = $7,
+    merge_commit = $8, closed_at = $9, updated_at = now()
+WHERE repo_name = $1 AND number = $2
+RETURNING repo_name, number, title, description, author, source_branch, target_branch, state, base_commit, merge_commit, created_at, updated_at, closed_at
+`
+
+type UpdateMergeRequestParams struct {
+	RepoName     string
+	Number       int32
+	Title        string
+	Description  string
+	TargetBranch string
+	State        string
+	BaseCommit   pgtype.Text
+	MergeCommit  pgtype.Text
+	ClosedAt     pgtype.Timestamp
+}
+
+func (q *Queries) UpdateMergeRequest(ctx context.Context, arg UpdateMergeRequestParams) (MergeRequest, error) {
+	row := q.db.QueryRow(ctx, updateMergeRequest,
+		arg.RepoName,
+		arg.Number,
+		arg.Title,
+		arg.Description,
+		arg.TargetBranch,
+		arg.State,
+		arg.BaseCommit,
+		arg.MergeCommit,
+		arg.ClosedAt,
+	)
+	var i MergeRequest
+	err := row.Scan(
+		&i.RepoName,
+		&i.Number,
+		&i.Title,
+		&i.Description,
+		&i.Author,
+		&i.SourceBranch,
+		&i.TargetBranch,
+		&i.State,
+		&i.BaseCommit,
+		&i.MergeCommit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ClosedAt,
+	)
+	return i, err
+}
+
+const updatePushMirrorState = `-- name: UpdatePushMirrorState :exec
+UPDATE push_mirrors SET last_state = $2, last_error = $3, last_run_at = now() WHERE id = $1
+`
+
+type UpdatePushMirrorStateParams struct {
+	ID        int32
+	LastState string
+	LastError string
+}
+
+func (q *Queries) UpdatePushMirrorState(ctx context.Context, arg UpdatePushMirrorStateParams) error {
+	_, err := q.db.Exec(ctx, updatePushMirrorState, arg.ID, arg.LastState, arg.LastError)
+	return err
+}
+
 const updateRepository = `-- name: UpdateRepository :one
-UPDATE repositories SET name = $1 WHERE name = $2 RETURNING id, name, created_at
+UPDATE repositories SET name = $1 WHERE name = $2 RETURNING id, name, created_at, parent_name
 `
 
 type UpdateRepositoryParams struct {
@@ -170,6 +1512,42 @@ type UpdateRepositoryParams struct {
 func (q *Queries) UpdateRepository(ctx context.Context, arg UpdateRepositoryParams) (Repository, error) {
 	row := q.db.QueryRow(ctx, updateRepository, arg.NewName, arg.OldName)
 	var i Repository
-	err := row.Scan(&i.ID, &i.Name, &i.CreatedAt)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.ParentName,
+	)
+	return i, err
+}
+
+const upsertPullMirror = `-- name: UpsertPullMirror :one
+INSERT INTO pull_mirrors (repo_name, url, interval_seconds) VALUES ($1, $2, $3)
+ON CONFLICT (repo_name) DO UPDATE
+SET url = EXCLUDED.url, interval_seconds = EXCLUDED.interval_seconds, next_sync_at = now(), updated_at = now()
+RETURNING repo_name, url, interval_seconds, next_sync_at, last_sync_at, last_sync_state, last_error, last_success_at, created_at, updated_at
+`
+
+type UpsertPullMirrorParams struct {
+	RepoName        string
+	Url             string
+	IntervalSeconds int32
+}
+
+func (q *Queries) UpsertPullMirror(ctx context.Context, arg UpsertPullMirrorParams) (PullMirror, error) {
+	row := q.db.QueryRow(ctx, upsertPullMirror, arg.RepoName, arg.Url, arg.IntervalSeconds)
+	var i PullMirror
+	err := row.Scan(
+		&i.RepoName,
+		&i.Url,
+		&i.IntervalSeconds,
+		&i.NextSyncAt,
+		&i.LastSyncAt,
+		&i.LastSyncState,
+		&i.LastError,
+		&i.LastSuccessAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
 	return i, err
 }
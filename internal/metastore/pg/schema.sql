@@ -19,6 +19,255 @@ SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: commit_statuses; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.commit_statuses (
+    id integer NOT NULL,
+    repo_name character varying(255) NOT NULL,
+    commit_hash character varying(40) NOT NULL,
+    state character varying(20) NOT NULL,
+    context character varying(255) NOT NULL,
+    description text DEFAULT ''::text NOT NULL,
+    target_url text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: commit_statuses_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.commit_statuses_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: commit_statuses_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.commit_statuses_id_seq OWNED BY public.commit_statuses.id;
+
+
+--
+-- Name: import_jobs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.import_jobs (
+    id integer NOT NULL,
+    repo_name character varying(255) NOT NULL,
+    url text NOT NULL,
+    state character varying(20) DEFAULT 'pending'::character varying NOT NULL,
+    progress text DEFAULT ''::text NOT NULL,
+    error text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    updated_at timestamp without time zone DEFAULT now() NOT NULL,
+    finished_at timestamp without time zone
+);
+
+
+--
+-- Name: import_jobs_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.import_jobs_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: import_jobs_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.import_jobs_id_seq OWNED BY public.import_jobs.id;
+
+
+--
+-- Name: merge_request_approvals; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.merge_request_approvals (
+    repo_name character varying(255) NOT NULL,
+    number integer NOT NULL,
+    approver character varying(255) NOT NULL,
+    commit_hash character varying(40) NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: merge_request_comments; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.merge_request_comments (
+    id integer NOT NULL,
+    repo_name character varying(255) NOT NULL,
+    number integer NOT NULL,
+    author character varying(255) NOT NULL,
+    body text NOT NULL,
+    commit_hash character varying(40) NOT NULL,
+    path character varying(4096),
+    line integer,
+    side character varying(10),
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: merge_request_comments_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.merge_request_comments_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: merge_request_comments_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.merge_request_comments_id_seq OWNED BY public.merge_request_comments.id;
+
+
+--
+-- Name: merge_requests; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.merge_requests (
+    repo_name character varying(255) NOT NULL,
+    number integer NOT NULL,
+    title character varying(255) NOT NULL,
+    description text DEFAULT ''::text NOT NULL,
+    author character varying(255) NOT NULL,
+    source_branch character varying(255) NOT NULL,
+    target_branch character varying(255) NOT NULL,
+    state character varying(20) DEFAULT 'open'::character varying NOT NULL,
+    base_commit character varying(40),
+    merge_commit character varying(40),
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    updated_at timestamp without time zone DEFAULT now() NOT NULL,
+    closed_at timestamp without time zone
+);
+
+
+--
+-- Name: objects; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.objects (
+    repo_name character varying(255) NOT NULL,
+    hash character varying(40) NOT NULL,
+    type character varying(20) NOT NULL,
+    size bigint NOT NULL,
+    loose_key character varying(1024),
+    pack_key character varying(1024),
+    pack_offset bigint
+);
+
+
+--
+-- Name: pull_mirrors; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.pull_mirrors (
+    repo_name character varying(255) NOT NULL,
+    url text NOT NULL,
+    interval_seconds integer NOT NULL,
+    next_sync_at timestamp without time zone DEFAULT now() NOT NULL,
+    last_sync_at timestamp without time zone,
+    last_sync_state character varying(20) DEFAULT ''::character varying NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    last_success_at timestamp without time zone,
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    updated_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: push_mirror_runs; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.push_mirror_runs (
+    id integer NOT NULL,
+    mirror_id integer NOT NULL,
+    state character varying(20) NOT NULL,
+    refs text[] NOT NULL,
+    attempts integer NOT NULL,
+    error text DEFAULT ''::text NOT NULL,
+    started_at timestamp without time zone NOT NULL,
+    finished_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: push_mirror_runs_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.push_mirror_runs_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: push_mirror_runs_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.push_mirror_runs_id_seq OWNED BY public.push_mirror_runs.id;
+
+
+--
+-- Name: push_mirrors; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.push_mirrors (
+    id integer NOT NULL,
+    repo_name character varying(255) NOT NULL,
+    url text NOT NULL,
+    last_state character varying(20) DEFAULT ''::character varying NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    last_run_at timestamp without time zone,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: push_mirrors_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+CREATE SEQUENCE public.push_mirrors_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: push_mirrors_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: -
+--
+
+ALTER SEQUENCE public.push_mirrors_id_seq OWNED BY public.push_mirrors.id;
+
+
 --
 -- Name: refs; Type: TABLE; Schema: public; Owner: -
 --
@@ -39,7 +288,8 @@ CREATE TABLE public.refs (
 CREATE TABLE public.repositories (
     id integer NOT NULL,
     name character varying(255) NOT NULL,
-    created_at timestamp without time zone DEFAULT now()
+    created_at timestamp without time zone DEFAULT now(),
+    parent_name character varying(255)
 );
 
 
@@ -72,6 +322,41 @@ CREATE TABLE public.schema_migrations (
 );
 
 
+--
+-- Name: commit_statuses id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.commit_statuses ALTER COLUMN id SET DEFAULT nextval('public.commit_statuses_id_seq'::regclass);
+
+
+--
+-- Name: import_jobs id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.import_jobs ALTER COLUMN id SET DEFAULT nextval('public.import_jobs_id_seq'::regclass);
+
+
+--
+-- Name: merge_request_comments id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_comments ALTER COLUMN id SET DEFAULT nextval('public.merge_request_comments_id_seq'::regclass);
+
+
+--
+-- Name: push_mirror_runs id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirror_runs ALTER COLUMN id SET DEFAULT nextval('public.push_mirror_runs_id_seq'::regclass);
+
+
+--
+-- Name: push_mirrors id; Type: DEFAULT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirrors ALTER COLUMN id SET DEFAULT nextval('public.push_mirrors_id_seq'::regclass);
+
+
 --
 -- Name: repositories id; Type: DEFAULT; Schema: public; Owner: -
 --
@@ -79,6 +364,78 @@ CREATE TABLE public.schema_migrations (
 ALTER TABLE ONLY public.repositories ALTER COLUMN id SET DEFAULT nextval('public.repositories_id_seq'::regclass);
 
 
+--
+-- Name: commit_statuses commit_statuses_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.commit_statuses
+    ADD CONSTRAINT commit_statuses_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: import_jobs import_jobs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.import_jobs
+    ADD CONSTRAINT import_jobs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: merge_request_approvals merge_request_approvals_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_approvals
+    ADD CONSTRAINT merge_request_approvals_pkey PRIMARY KEY (repo_name, number, approver);
+
+
+--
+-- Name: merge_request_comments merge_request_comments_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_comments
+    ADD CONSTRAINT merge_request_comments_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: merge_requests merge_requests_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_requests
+    ADD CONSTRAINT merge_requests_pkey PRIMARY KEY (repo_name, number);
+
+
+--
+-- Name: objects objects_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.objects
+    ADD CONSTRAINT objects_pkey PRIMARY KEY (repo_name, hash);
+
+
+--
+-- Name: pull_mirrors pull_mirrors_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.pull_mirrors
+    ADD CONSTRAINT pull_mirrors_pkey PRIMARY KEY (repo_name);
+
+
+--
+-- Name: push_mirror_runs push_mirror_runs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirror_runs
+    ADD CONSTRAINT push_mirror_runs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: push_mirrors push_mirrors_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirrors
+    ADD CONSTRAINT push_mirrors_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: refs refs_pkey; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -111,12 +468,162 @@ ALTER TABLE ONLY public.schema_migrations
     ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (version);
 
 
+--
+-- Name: commit_statuses_commit_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX commit_statuses_commit_idx ON public.commit_statuses USING btree (repo_name, commit_hash, context, id);
+
+
+--
+-- Name: import_jobs_active_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE UNIQUE INDEX import_jobs_active_idx ON public.import_jobs USING btree (repo_name) WHERE ((state)::text = ANY ((ARRAY['pending'::character varying, 'running'::character varying])::text[]));
+
+
+--
+-- Name: import_jobs_repo_name_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX import_jobs_repo_name_idx ON public.import_jobs USING btree (repo_name, id);
+
+
+--
+-- Name: merge_request_comments_number_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX merge_request_comments_number_idx ON public.merge_request_comments USING btree (repo_name, number, id);
+
+
+--
+-- Name: merge_requests_source_branch_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX merge_requests_source_branch_idx ON public.merge_requests USING btree (repo_name, source_branch) WHERE ((state)::text = 'open'::text);
+
+
+--
+-- Name: objects_repo_name_type_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX objects_repo_name_type_idx ON public.objects USING btree (repo_name, type, hash);
+
+
+--
+-- Name: pull_mirrors_next_sync_at_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX pull_mirrors_next_sync_at_idx ON public.pull_mirrors USING btree (next_sync_at);
+
+
+--
+-- Name: push_mirror_runs_mirror_id_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX push_mirror_runs_mirror_id_idx ON public.push_mirror_runs USING btree (mirror_id, id);
+
+
+--
+-- Name: push_mirrors_repo_name_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX push_mirrors_repo_name_idx ON public.push_mirrors USING btree (repo_name, id);
+
+
+--
+-- Name: repositories_parent_name_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX repositories_parent_name_idx ON public.repositories USING btree (parent_name);
+
+
+--
+-- Name: commit_statuses commit_statuses_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.commit_statuses
+    ADD CONSTRAINT commit_statuses_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: import_jobs import_jobs_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.import_jobs
+    ADD CONSTRAINT import_jobs_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: merge_request_approvals merge_request_approvals_repo_name_number_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_approvals
+    ADD CONSTRAINT merge_request_approvals_repo_name_number_fkey FOREIGN KEY (repo_name, number) REFERENCES public.merge_requests(repo_name, number) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: merge_request_comments merge_request_comments_repo_name_number_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_request_comments
+    ADD CONSTRAINT merge_request_comments_repo_name_number_fkey FOREIGN KEY (repo_name, number) REFERENCES public.merge_requests(repo_name, number) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: merge_requests merge_requests_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.merge_requests
+    ADD CONSTRAINT merge_requests_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: objects objects_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.objects
+    ADD CONSTRAINT objects_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: pull_mirrors pull_mirrors_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.pull_mirrors
+    ADD CONSTRAINT pull_mirrors_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: push_mirror_runs push_mirror_runs_mirror_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirror_runs
+    ADD CONSTRAINT push_mirror_runs_mirror_id_fkey FOREIGN KEY (mirror_id) REFERENCES public.push_mirrors(id) ON DELETE CASCADE;
+
+
+--
+-- Name: push_mirrors push_mirrors_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.push_mirrors
+    ADD CONSTRAINT push_mirrors_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: refs refs_repo_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
 
 ALTER TABLE ONLY public.refs
-    ADD CONSTRAINT refs_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON DELETE CASCADE;
+    ADD CONSTRAINT refs_repo_name_fkey FOREIGN KEY (repo_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: repositories repositories_parent_name_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.repositories
+    ADD CONSTRAINT repositories_parent_name_fkey FOREIGN KEY (parent_name) REFERENCES public.repositories(name) ON UPDATE CASCADE ON DELETE RESTRICT;
 
 
 --
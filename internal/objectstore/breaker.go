@@ -0,0 +1,104 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// ErrCircuitOpen is returned without contacting the object store while the
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("object store circuit breaker is open")
+
+// circuitBreaker stops sending requests to the object store after a number of
+// consecutive failures. Once the cooldown has elapsed, a single probe request
+// is let through: its success closes the circuit, its failure re-opens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	probing  bool
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a request may be sent. Every allowed request must be
+// followed by a call to done.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return nil
+	}
+
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+
+	b.probing = true
+	return nil
+}
+
+// done records the outcome of an allowed request.
+func (b *circuitBreaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !failed {
+		if b.open {
+			slog.Info("object store circuit breaker closed")
+		}
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		if !b.open {
+			slog.Warn("object store circuit breaker opened", "failures", b.failures)
+		}
+		b.open = true
+		b.openedAt = time.Now()
+	}
+}
+
+// degraded reports whether the circuit is open and still cooling down.
+func (b *circuitBreaker) degraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.open && time.Since(b.openedAt) < b.cooldown
+}
+
+// isStoreFailure reports whether err means that the object store is failing,
+// as opposed to a request being rejected (e.g. a missing key) or abandoned by
+// the caller.
+func isStoreFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode() >= 500
+	}
+
+	return true
+}
@@ -2,20 +2,28 @@ package objectstore
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type ObjectStore struct {
-	client   *s3.Client
-	uploader *manager.Uploader
-	bucket   string
+	client            *s3.Client
+	uploader          *manager.Uploader
+	bucket            string
+	uploadConcurrency int
+	timeouts          TimeoutOptions
+	breaker           *circuitBreaker
 }
 
 type Options struct {
@@ -24,9 +32,88 @@ type Options struct {
 	SecretKey string
 	Bucket    string
 	Region    string
+	// UploadConcurrency is the maximum number of objects uploaded in parallel
+	// by a single push. Zero selects the default.
+	UploadConcurrency int
+	Retry             RetryOptions
+	Timeouts          TimeoutOptions
+	CircuitBreaker    CircuitBreakerOptions
+}
+
+// RetryOptions configures the retries of failed requests, with an exponential
+// backoff (starting at InitialBackoff and capped at MaxBackoff) and full
+// jitter. Zero values select the defaults.
+type RetryOptions struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// TimeoutOptions bounds the duration of each kind of operation, retries
+// included. The timeout of a Get covers reading the returned body, the one of
+// a List applies to each page. Zero values select the defaults.
+type TimeoutOptions struct {
+	Head time.Duration
+	Get  time.Duration
+	Put  time.Duration
+	List time.Duration
+}
+
+// CircuitBreakerOptions configures the number of consecutive failures after
+// which requests fail fast, and for how long. Zero values select the
+// defaults.
+type CircuitBreakerOptions struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Retry.MaxAttempts == 0 {
+		opts.Retry.MaxAttempts = 3
+	}
+	if opts.Retry.InitialBackoff == 0 {
+		opts.Retry.InitialBackoff = 100 * time.Millisecond
+	}
+	if opts.Retry.MaxBackoff == 0 {
+		opts.Retry.MaxBackoff = 5 * time.Second
+	}
+	if opts.Timeouts.Head == 0 {
+		opts.Timeouts.Head = 10 * time.Second
+	}
+	if opts.Timeouts.Get == 0 {
+		opts.Timeouts.Get = 2 * time.Minute
+	}
+	if opts.Timeouts.Put == 0 {
+		opts.Timeouts.Put = 2 * time.Minute
+	}
+	if opts.Timeouts.List == 0 {
+		opts.Timeouts.List = time.Minute
+	}
+	if opts.CircuitBreaker.FailureThreshold == 0 {
+		opts.CircuitBreaker.FailureThreshold = 5
+	}
+	if opts.CircuitBreaker.Cooldown == 0 {
+		opts.CircuitBreaker.Cooldown = 30 * time.Second
+	}
+}
+
+// backoff returns an exponential backoff delay with full jitter.
+func (r RetryOptions) backoff(attempt int, _ error) (time.Duration, error) {
+	// Doubling stops before it can exceed MaxBackoff, so it cannot overflow.
+	d := r.InitialBackoff
+	for i := 1; i < attempt && d <= r.MaxBackoff/2; i++ {
+		d <<= 1
+	}
+	d = min(d, r.MaxBackoff)
+	if d <= 0 {
+		return 0, nil
+	}
+	return rand.N(d) + 1, nil
 }
 
 func New(ctx context.Context, opts Options) (*ObjectStore, error) {
+	opts.setDefaults()
+
 	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(opts.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, "")),
@@ -41,21 +128,60 @@ func New(ctx context.Context, opts Options) (*ObjectStore, error) {
 			o.BaseEndpoint = aws.String(opts.Endpoint)
 		}
 		o.UsePathStyle = true
-		o.Retryer = aws.NopRetryer{}
+		o.Retryer = retry.NewStandard(func(so *retry.StandardOptions) {
+			so.MaxAttempts = opts.Retry.MaxAttempts
+			so.MaxBackoff = opts.Retry.MaxBackoff
+			so.Backoff = retry.BackoffDelayerFunc(opts.Retry.backoff)
+			// The circuit breaker takes care of a failing object store;
+			// don't also give up after a client-side retry budget.
+			so.RateLimiter = ratelimit.None
+		})
 	})
 
 	return &ObjectStore{
-		client:   client,
-		uploader: manager.NewUploader(client),
-		bucket:   opts.Bucket,
+		client:            client,
+		uploader:          manager.NewUploader(client),
+		bucket:            opts.Bucket,
+		uploadConcurrency: opts.UploadConcurrency,
+		timeouts:          opts.Timeouts,
+		breaker:           newCircuitBreaker(opts.CircuitBreaker.FailureThreshold, opts.CircuitBreaker.Cooldown),
 	}, nil
 }
 
+// Degraded reports whether the object store has been failing and requests are
+// currently rejected with ErrCircuitOpen.
+func (o *ObjectStore) Degraded() bool {
+	return o.breaker.degraded()
+}
+
+// do runs op under the given timeout and records its outcome in the circuit
+// breaker.
+func (o *ObjectStore) do(ctx context.Context, timeout time.Duration, op func(ctx context.Context) error) error {
+	if err := o.breaker.allow(); err != nil {
+		return err
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := op(opCtx)
+	o.breaker.done(isStoreFailure(ctx, err))
+	return err
+}
+
+// UploadConcurrency returns the configured number of parallel uploads per
+// push, or zero if the default should be used.
+func (o *ObjectStore) UploadConcurrency() int {
+	return o.uploadConcurrency
+}
+
 func (o *ObjectStore) Ping(ctx context.Context) error {
-	_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(o.bucket),
+	return o.do(ctx, o.timeouts.Head, func(ctx context.Context) error {
+		_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket: aws.String(o.bucket),
+		})
+		return err
 	})
-	return err
 }
 
 func (o *ObjectStore) EnsureBucket(ctx context.Context) error {
@@ -85,44 +211,128 @@ func (o *ObjectStore) EnsureBucket(ctx context.Context) error {
 }
 
 func (o *ObjectStore) Head(ctx context.Context, key string) error {
-	_, err := o.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(o.bucket),
-		Key:    aws.String(key),
-	})
+	_, err := o.HeadMetadata(ctx, key)
 	return err
 }
 
+// HeadMetadata returns the user-defined metadata stored alongside key. Keys
+// are returned in lower case, as normalized by S3.
+func (o *ObjectStore) HeadMetadata(ctx context.Context, key string) (map[string]string, error) {
+	var metadata map[string]string
+	err := o.do(ctx, o.timeouts.Head, func(ctx context.Context) error {
+		out, err := o.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		metadata = out.Metadata
+		return nil
+	})
+	return metadata, err
+}
+
 func (o *ObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
-	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{
+	return o.get(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(o.bucket),
 		Key:    aws.String(key),
 	})
+}
+
+// get issues a GetObject request whose timeout keeps running until the
+// returned body is closed.
+func (o *ObjectStore) get(ctx context.Context, in *s3.GetObjectInput) (io.ReadCloser, error) {
+	if err := o.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, o.timeouts.Get)
+
+	out, err := o.client.GetObject(opCtx, in)
+	o.breaker.done(isStoreFailure(ctx, err))
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-	return out.Body, nil
+
+	return &cancelOnClose{ReadCloser: out.Body, cancel: cancel}, nil
 }
 
-func (o *ObjectStore) Put(ctx context.Context, key string, r io.Reader) error {
-	_, err := o.uploader.Upload(ctx, &s3.PutObjectInput{
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// GetRange returns length bytes of key starting at offset. The returned reader
+// may yield fewer bytes if the object is shorter.
+func (o *ObjectStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return o.get(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(o.bucket),
 		Key:    aws.String(key),
-		Body:   r,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+}
+
+func (o *ObjectStore) Put(ctx context.Context, key string, r io.Reader) error {
+	return o.PutWithMetadata(ctx, key, r, nil)
+}
+
+// PutWithMetadata uploads r to key and attaches the given user-defined
+// metadata, which can later be read back with HeadMetadata.
+func (o *ObjectStore) PutWithMetadata(ctx context.Context, key string, r io.Reader, metadata map[string]string) error {
+	return o.do(ctx, o.timeouts.Put, func(ctx context.Context) error {
+		_, err := o.uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:   aws.String(o.bucket),
+			Key:      aws.String(key),
+			Body:     r,
+			Metadata: metadata,
+		})
+		return err
+	})
+}
+
+// SetMetadata replaces the user-defined metadata of an existing key with a
+// server-side copy, without transferring the object content.
+func (o *ObjectStore) SetMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	return o.do(ctx, o.timeouts.Put, func(ctx context.Context) error {
+		_, err := o.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(o.bucket),
+			Key:               aws.String(key),
+			CopySource:        aws.String(o.bucket + "/" + key),
+			Metadata:          metadata,
+			MetadataDirective: types.MetadataDirectiveReplace,
+		})
+		return err
 	})
-	return err
 }
 
 func (o *ObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
-	out, err := o.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+	p := s3.NewListObjectsV2Paginator(o.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(o.bucket),
 		Prefix: aws.String(prefix),
 	})
-	if err != nil {
-		return nil, err
-	}
+
 	var keys []string
-	for _, obj := range out.Contents {
-		keys = append(keys, *obj.Key)
+	for p.HasMorePages() {
+		err := o.do(ctx, o.timeouts.List, func(ctx context.Context) error {
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			for _, obj := range out.Contents {
+				keys = append(keys, *obj.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 	return keys, nil
 }
@@ -3,18 +3,25 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/npclaudiu/git-server-poc/internal/config"
+	"github.com/npclaudiu/git-server-poc/internal/git/archive"
 	gitserver "github.com/npclaudiu/git-server-poc/internal/git/server"
+	"github.com/npclaudiu/git-server-poc/internal/git/storage"
 	"github.com/npclaudiu/git-server-poc/internal/metastore"
+	"github.com/npclaudiu/git-server-poc/internal/metastore/pg"
 	"github.com/npclaudiu/git-server-poc/internal/objectstore"
 )
 
@@ -23,14 +30,25 @@ type Server struct {
 	metaStore   *metastore.MetaStore
 	objectStore *objectstore.ObjectStore
 	gitHandler  *gitserver.GitHandler
-	wg          sync.WaitGroup
+	// defaultBranch is the default branch of new repositories.
+	defaultBranch string
+	wg            sync.WaitGroup
 }
 
 func New(cfg *config.Config, ms *metastore.MetaStore, os *objectstore.ObjectStore) *Server {
 	s := &Server{
 		metaStore:   ms,
 		objectStore: os,
-		gitHandler:  gitserver.New(ms, os),
+		gitHandler: gitserver.New(ms, os, gitserver.Options{
+			PushLockTimeout:    cfg.Server.PushLockTimeout,
+			MirrorInterval:     cfg.Mirrors.Interval,
+			MirrorPollInterval: cfg.Mirrors.PollInterval,
+			LocalRemoteRoot:    cfg.Server.LocalRemoteRoot,
+		}),
+		defaultBranch: cfg.Server.DefaultBranch,
+	}
+	if s.defaultBranch == "" {
+		s.defaultBranch = "main"
 	}
 
 	r := chi.NewRouter()
@@ -47,10 +65,64 @@ func New(cfg *config.Config, ms *metastore.MetaStore, os *objectstore.ObjectStor
 	r.Get("/repositories/{repository_id}", s.handleGetRepository)
 	r.Put("/repositories/{repository_id}", s.handleUpdateRepository)
 	r.Delete("/repositories/{repository_id}", s.handleDeleteRepository)
+	r.Get("/repositories/{repository_id}/forks", s.handleListForks)
+	r.Post("/repositories/{repository_id}/forks", s.handleCreateFork)
 	// Git Smart HTTP endpoints
 	r.Get("/repositories/{repository_id}.git/info/refs", s.handleGitInfoRefs)
 	r.Post("/repositories/{repository_id}.git/git-upload-pack", s.handleGitUploadPack)
 	r.Post("/repositories/{repository_id}.git/git-receive-pack", s.handleGitReceivePack)
+	// Git bundles
+	r.Get("/repositories/{repository_id}/bundle", s.handleGetBundle)
+	r.Post("/repositories/{repository_id}/bundle", s.handleImportBundle)
+	// Archives
+	r.Get("/repositories/{repository_id}/archive/*", s.handleGetArchive)
+	// Repository contents
+	r.Get("/repositories/{repository_id}/tree/*", s.handleGetTree)
+	r.Get("/repositories/{repository_id}/blob/*", s.handleGetBlob)
+	r.Get("/repositories/{repository_id}/blame/*", s.handleGetBlame)
+	r.Get("/repositories/{repository_id}/commits", s.handleListCommits)
+	r.Post("/repositories/{repository_id}/commits", s.handleCreateCommit)
+	r.Get("/repositories/{repository_id}/compare/*", s.handleCompare)
+	// References
+	r.Get("/repositories/{repository_id}/refs", s.handleListRefs)
+	r.Post("/repositories/{repository_id}/refs", s.handleCreateRef)
+	r.Get("/repositories/{repository_id}/refs/*", s.handleGetRef)
+	r.Patch("/repositories/{repository_id}/refs/*", s.handleUpdateRef)
+	r.Delete("/repositories/{repository_id}/refs/*", s.handleDeleteRef)
+	// Merges
+	r.Post("/repositories/{repository_id}/merges", s.handleMerge)
+	r.Post("/repositories/{repository_id}/cherry-picks", s.handleCherryPick)
+	// Commit statuses
+	r.Post("/repositories/{repository_id}/statuses/*", s.handleCreateStatus)
+	r.Get("/repositories/{repository_id}/statuses/*", s.handleListStatuses)
+	r.Get("/repositories/{repository_id}/status/*", s.handleGetCombinedStatus)
+	// Merge requests
+	r.Get("/repositories/{repository_id}/merge-requests", s.handleListMergeRequests)
+	r.Post("/repositories/{repository_id}/merge-requests", s.handleCreateMergeRequest)
+	r.Get("/repositories/{repository_id}/merge-requests/{number}", s.handleGetMergeRequest)
+	r.Patch("/repositories/{repository_id}/merge-requests/{number}", s.handleUpdateMergeRequest)
+	r.Get("/repositories/{repository_id}/merge-requests/{number}/diff", s.handleMergeRequestDiff)
+	r.Get("/repositories/{repository_id}/merge-requests/{number}/comments", s.handleListReviewComments)
+	r.Post("/repositories/{repository_id}/merge-requests/{number}/comments", s.handleCreateReviewComment)
+	r.Post("/repositories/{repository_id}/merge-requests/{number}/approvals", s.handleApprove)
+	r.Delete("/repositories/{repository_id}/merge-requests/{number}/approvals/{approver}", s.handleUnapprove)
+	r.Post("/repositories/{repository_id}/merge-requests/{number}/merge", s.handleAcceptMergeRequest)
+	// Imports
+	r.Get("/repositories/{repository_id}/import", s.handleListImportJobs)
+	r.Post("/repositories/{repository_id}/import", s.handleImport)
+	r.Get("/repositories/{repository_id}/import/{job_id}", s.handleGetImportJob)
+	// Pull mirrors
+	r.Get("/repositories/{repository_id}/mirror", s.handleGetMirror)
+	r.Put("/repositories/{repository_id}/mirror", s.handleSetMirror)
+	r.Delete("/repositories/{repository_id}/mirror", s.handleDeleteMirror)
+	r.Post("/repositories/{repository_id}/mirror/sync", s.handleSyncMirror)
+	// Push mirrors
+	r.Get("/repositories/{repository_id}/push-mirrors", s.handleListPushMirrors)
+	r.Post("/repositories/{repository_id}/push-mirrors", s.handleCreatePushMirror)
+	r.Get("/repositories/{repository_id}/push-mirrors/{mirror_id}", s.handleGetPushMirror)
+	r.Delete("/repositories/{repository_id}/push-mirrors/{mirror_id}", s.handleDeletePushMirror)
+	r.Post("/repositories/{repository_id}/push-mirrors/{mirror_id}/sync", s.handleSyncPushMirror)
+	r.Get("/repositories/{repository_id}/push-mirrors/{mirror_id}/runs", s.handleListPushMirrorRuns)
 
 	s.httpServer = &http.Server{
 		Addr:    net.JoinHostPort(cfg.Server.Host, cfg.Server.Port),
@@ -106,7 +178,465 @@ func (s *Server) handleGitReceivePack(w http.ResponseWriter, r *http.Request) {
 	s.gitHandler.ReceivePack(w, r, id)
 }
 
+func (s *Server) handleGetBundle(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Bundle(w, r, id)
+}
+
+func (s *Server) handleImportBundle(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ImportBundle(w, r, id)
+}
+
+func (s *Server) handleGetArchive(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	rev, format, err := archive.ParseName(chi.URLParam(r, "*"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.gitHandler.Archive(w, r, id, rev, format)
+}
+
+func (s *Server) handleGetTree(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Tree(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Blob(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleGetBlame(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Blame(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleListCommits(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Commits(w, r, id)
+}
+
+func (s *Server) handleCreateCommit(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreateCommit(w, r, id)
+}
+
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	base, head, ok := strings.Cut(chi.URLParam(r, "*"), "...")
+	if !ok || base == "" || head == "" {
+		http.Error(w, "expected {base}...{head}", http.StatusBadRequest)
+		return
+	}
+
+	s.gitHandler.Compare(w, r, id, base, head)
+}
+
+func (s *Server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Merge(w, r, id)
+}
+
+func (s *Server) handleCherryPick(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CherryPick(w, r, id)
+}
+
+func (s *Server) handleListRefs(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ListReferences(w, r, id)
+}
+
+func (s *Server) handleCreateRef(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreateReference(w, r, id)
+}
+
+func (s *Server) handleGetRef(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.GetReference(w, r, id, refName(r))
+}
+
+func (s *Server) handleUpdateRef(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.UpdateReference(w, r, id, refName(r))
+}
+
+func (s *Server) handleDeleteRef(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.DeleteReference(w, r, id, refName(r))
+}
+
+func (s *Server) handleCreateStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreateStatus(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleListStatuses(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Statuses(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleGetCombinedStatus(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CombinedStatus(w, r, id, chi.URLParam(r, "*"))
+}
+
+func (s *Server) handleListMergeRequests(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ListMergeRequests(w, r, id)
+}
+
+func (s *Server) handleCreateMergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreateMergeRequest(w, r, id)
+}
+
+func (s *Server) handleGetMergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.GetMergeRequest(w, r, id, number)
+}
+
+func (s *Server) handleUpdateMergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.UpdateMergeRequest(w, r, id, number)
+}
+
+func (s *Server) handleMergeRequestDiff(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.MergeRequestDiff(w, r, id, number)
+}
+
+func (s *Server) handleListReviewComments(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ListReviewComments(w, r, id, number)
+}
+
+func (s *Server) handleCreateReviewComment(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreateReviewComment(w, r, id, number)
+}
+
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Approve(w, r, id, number)
+}
+
+func (s *Server) handleUnapprove(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Unapprove(w, r, id, number, chi.URLParam(r, "approver"))
+}
+
+func (s *Server) handleAcceptMergeRequest(w http.ResponseWriter, r *http.Request) {
+	id, number, ok := s.mergeRequestNumber(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.AcceptMergeRequest(w, r, id, number)
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.Import(w, r, id)
+}
+
+func (s *Server) handleListImportJobs(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ImportJobs(w, r, id)
+}
+
+func (s *Server) handleGetImportJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	jobID, err := strconv.ParseInt(chi.URLParam(r, "job_id"), 10, 32)
+	if err != nil || jobID < 1 {
+		http.Error(w, "invalid import job id", http.StatusBadRequest)
+		return
+	}
+
+	s.gitHandler.GetImportJob(w, r, id, int32(jobID))
+}
+
+func (s *Server) handleGetMirror(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.GetMirror(w, r, id)
+}
+
+func (s *Server) handleSetMirror(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.SetMirror(w, r, id)
+}
+
+func (s *Server) handleDeleteMirror(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.DeleteMirror(w, r, id)
+}
+
+func (s *Server) handleSyncMirror(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.SyncMirror(w, r, id)
+}
+
+func (s *Server) handleListPushMirrors(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.ListPushMirrors(w, r, id)
+}
+
+func (s *Server) handleCreatePushMirror(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.CreatePushMirror(w, r, id)
+}
+
+func (s *Server) handleGetPushMirror(w http.ResponseWriter, r *http.Request) {
+	id, mirrorID, ok := s.pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.GetPushMirror(w, r, id, mirrorID)
+}
+
+func (s *Server) handleDeletePushMirror(w http.ResponseWriter, r *http.Request) {
+	id, mirrorID, ok := s.pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.DeletePushMirror(w, r, id, mirrorID)
+}
+
+func (s *Server) handleSyncPushMirror(w http.ResponseWriter, r *http.Request) {
+	id, mirrorID, ok := s.pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.SyncPushMirror(w, r, id, mirrorID)
+}
+
+func (s *Server) handleListPushMirrorRuns(w http.ResponseWriter, r *http.Request) {
+	id, mirrorID, ok := s.pushMirrorID(w, r)
+	if !ok {
+		return
+	}
+
+	s.gitHandler.PushMirrorRuns(w, r, id, mirrorID)
+}
+
+// refName returns the full name of the reference addressed by a
+// /refs/{name} route.
+func refName(r *http.Request) plumbing.ReferenceName {
+	return plumbing.ReferenceName("refs/" + chi.URLParam(r, "*"))
+}
+
+// repositoryID returns the id of the repository addressed by the request. If
+// the id is invalid or the repository does not exist, it writes an error
+// response and returns false.
+func (s *Server) repositoryID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := chi.URLParam(r, "repository_id")
+	if !isValidRepoName(id) {
+		http.Error(w, "invalid repository id", http.StatusBadRequest)
+		return "", false
+	}
+
+	if _, err := s.metaStore.GetRepository(r.Context(), id); err != nil {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return "", false
+	}
+
+	return id, true
+}
+
+// mergeRequestNumber returns the repository id and the number of the merge
+// request addressed by the request, writing an error response and returning
+// false if either is invalid.
+func (s *Server) mergeRequestNumber(w http.ResponseWriter, r *http.Request) (string, int32, bool) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return "", 0, false
+	}
+
+	number, err := strconv.ParseInt(chi.URLParam(r, "number"), 10, 32)
+	if err != nil || number < 1 {
+		http.Error(w, "invalid merge request number", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	return id, int32(number), true
+}
+
+// pushMirrorID returns the repository id and the id of the push mirror
+// addressed by the request, writing an error response and returning false if
+// either is invalid.
+func (s *Server) pushMirrorID(w http.ResponseWriter, r *http.Request) (string, int32, bool) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return "", 0, false
+	}
+
+	mirrorID, err := strconv.ParseInt(chi.URLParam(r, "mirror_id"), 10, 32)
+	if err != nil || mirrorID < 1 {
+		http.Error(w, "invalid push mirror id", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	return id, int32(mirrorID), true
+}
+
 func (s *Server) Run() error {
+	s.gitHandler.Start()
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -128,6 +658,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		return err
 	}
 
+	s.gitHandler.Close()
 	s.wg.Wait()
 	return nil
 }
@@ -146,6 +677,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	errors := 0
+	degraded := false
 
 	if err := s.metaStore.Ping(r.Context()); err != nil {
 		slog.Error("metastore health check failed", "err", err)
@@ -153,10 +685,20 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		resp.MetaStore = "down"
 	}
 
-	if err := s.objectStore.Ping(r.Context()); err != nil {
+	// While the object store circuit breaker is open, requests fail fast and
+	// pinging would not tell anything new.
+	if s.objectStore.Degraded() {
+		degraded = true
+		resp.ObjectStore = "degraded"
+	} else if err := s.objectStore.Ping(r.Context()); err != nil {
 		slog.Error("objectstore health check failed", "err", err)
-		errors++
-		resp.ObjectStore = "down"
+		if err == objectstore.ErrCircuitOpen {
+			degraded = true
+			resp.ObjectStore = "degraded"
+		} else {
+			errors++
+			resp.ObjectStore = "down"
+		}
 	}
 
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -167,25 +709,55 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if errors > 0 {
 		resp.Status = "error"
 		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if degraded {
+		resp.Status = "degraded"
 	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Repository is the REST representation of a repository. DefaultBranch is
+// the branch HEAD points to.
+type Repository struct {
+	pg.Repository
+	DefaultBranch string `json:"default_branch"`
+}
+
 type CreateRepositoryRequest struct {
 	Name string `json:"name"`
+	// DefaultBranch overrides the configured default branch.
+	DefaultBranch string `json:"default_branch"`
 }
 
-type UpdateRepositoryRequest struct {
+type CreateForkRequest struct {
 	Name string `json:"name"`
 }
 
+type UpdateRepositoryRequest struct {
+	Name          string `json:"name"`
+	DefaultBranch string `json:"default_branch"`
+}
+
 var validNameRegex = regexp.MustCompile(`^[a-z0-9\-_]+$`)
 
 func isValidRepoName(name string) bool {
 	return validNameRegex.MatchString(name)
 }
 
+func isValidBranchName(name string) bool {
+	return plumbing.NewBranchReferenceName(name).Validate() == nil
+}
+
+// defaultBranch returns the short name of the branch HEAD points to, or an
+// empty string if HEAD is missing or not a branch.
+func defaultBranch(head string) string {
+	name, ok := strings.CutPrefix(head, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return name
+}
+
 func (s *Server) handleCreateRepository(w http.ResponseWriter, r *http.Request) {
 	var req CreateRepositoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -203,7 +775,16 @@ func (s *Server) handleCreateRepository(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	repo, err := s.metaStore.CreateRepository(r.Context(), req.Name)
+	if req.DefaultBranch == "" {
+		req.DefaultBranch = s.defaultBranch
+	}
+	if !isValidBranchName(req.DefaultBranch) {
+		http.Error(w, "invalid default branch", http.StatusBadRequest)
+		return
+	}
+
+	head := plumbing.NewBranchReferenceName(req.DefaultBranch).String()
+	repo, err := s.metaStore.CreateRepository(r.Context(), req.Name, head)
 	if err != nil {
 		slog.Error("failed to create repository", "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -212,7 +793,7 @@ func (s *Server) handleCreateRepository(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(repo)
+	json.NewEncoder(w).Encode(Repository{Repository: repo, DefaultBranch: req.DefaultBranch})
 }
 
 func (s *Server) handleListRepositories(w http.ResponseWriter, r *http.Request) {
@@ -223,8 +804,20 @@ func (s *Server) handleListRepositories(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	heads, err := s.metaStore.ListHeads(r.Context())
+	if err != nil {
+		slog.Error("failed to list repository heads", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]Repository, 0, len(repos))
+	for _, repo := range repos {
+		resp = append(resp, Repository{Repository: repo, DefaultBranch: defaultBranch(heads[repo.Name])})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repos)
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleGetRepository(w http.ResponseWriter, r *http.Request) {
@@ -242,8 +835,15 @@ func (s *Server) handleGetRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resp, err := s.repositoryResponse(r.Context(), repo)
+	if err != nil {
+		slog.Error("failed to get repository head", "id", id, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repo)
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleUpdateRepository(w http.ResponseWriter, r *http.Request) {
@@ -259,26 +859,70 @@ func (s *Server) handleUpdateRepository(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	if req.Name == "" && req.DefaultBranch == "" {
+		http.Error(w, "name or default_branch is required", http.StatusBadRequest)
 		return
 	}
 
-	if !isValidRepoName(req.Name) {
+	if req.Name != "" && !isValidRepoName(req.Name) {
 		http.Error(w, "invalid repository name", http.StatusBadRequest)
 		return
 	}
 
-	repo, err := s.metaStore.UpdateRepository(r.Context(), id, req.Name)
+	if req.DefaultBranch != "" && !isValidBranchName(req.DefaultBranch) {
+		http.Error(w, "invalid default branch", http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.metaStore.GetRepository(r.Context(), id)
 	if err != nil {
-		slog.Error("failed to update repository", "id", id, "err", err)
-		// TODO: handle ErrNoRows specifically
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+
+	if req.Name != "" {
+		repo, err = s.metaStore.UpdateRepository(r.Context(), id, req.Name)
+		if err != nil {
+			slog.Error("failed to update repository", "id", id, "err", err)
+			// TODO: handle ErrNoRows specifically
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.DefaultBranch != "" {
+		err := s.gitHandler.SetDefaultBranch(r.Context(), repo.Name, plumbing.NewBranchReferenceName(req.DefaultBranch))
+		if errors.Is(err, metastore.ErrLockTimeout) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			slog.Error("failed to update default branch", "id", id, "err", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp, err := s.repositoryResponse(r.Context(), repo)
+	if err != nil {
+		slog.Error("failed to get repository head", "id", id, "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repo)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) repositoryResponse(ctx context.Context, repo pg.Repository) (Repository, error) {
+	head, err := s.metaStore.GetRef(ctx, repo.Name, plumbing.HEAD.String())
+	if errors.Is(err, metastore.ErrNotFound) {
+		return Repository{Repository: repo}, nil
+	}
+	if err != nil {
+		return Repository{}, err
+	}
+	return Repository{Repository: repo, DefaultBranch: defaultBranch(head.Target.String)}, nil
 }
 
 func (s *Server) handleDeleteRepository(w http.ResponseWriter, r *http.Request) {
@@ -288,6 +932,18 @@ func (s *Server) handleDeleteRepository(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Forks read their objects from their parent.
+	forks, err := s.metaStore.ListForks(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to list forks", "id", id, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(forks) > 0 {
+		http.Error(w, "repository has forks", http.StatusConflict)
+		return
+	}
+
 	if err := s.metaStore.DeleteRepository(r.Context(), id); err != nil {
 		slog.Error("failed to delete repository", "id", id, "err", err)
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -296,3 +952,95 @@ func (s *Server) handleDeleteRepository(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// handleCreateFork creates a repository with the branches, tags and default
+// branch of its parent. Objects are not copied: the fork reads the objects it
+// does not have from its parent, and only stores those pushed to it.
+func (s *Server) handleCreateFork(w http.ResponseWriter, r *http.Request) {
+	parent, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateForkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidRepoName(req.Name) {
+		http.Error(w, "invalid repository name", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.metaStore.GetRepository(r.Context(), req.Name); err == nil {
+		http.Error(w, "repository already exists", http.StatusConflict)
+		return
+	}
+
+	head := plumbing.NewBranchReferenceName(s.defaultBranch).String()
+	if ref, err := s.metaStore.GetRef(r.Context(), parent, plumbing.HEAD.String()); err == nil && ref.Target.Valid {
+		head = ref.Target.String
+	}
+
+	repo, err := s.fork(r.Context(), parent, req.Name, head)
+	if err != nil {
+		slog.Error("failed to fork repository", "id", parent, "fork", req.Name, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Repository{Repository: repo, DefaultBranch: defaultBranch(head)})
+}
+
+// fork creates the repository name as a fork of parent. The fork is linked
+// to its parent before its references are copied, so that they never point
+// to objects it cannot read. A partially created fork is deleted.
+func (s *Server) fork(ctx context.Context, parent, name, head string) (pg.Repository, error) {
+	if _, err := s.metaStore.CreateRepository(ctx, name, head); err != nil {
+		return pg.Repository{}, err
+	}
+
+	st := storage.NewStorer(ctx, s.objectStore, s.metaStore, name)
+	err := st.AddAlternate(parent)
+	if err == nil {
+		err = s.metaStore.CopyRefs(ctx, parent, name)
+	}
+	if err != nil {
+		if err := s.metaStore.DeleteRepository(context.WithoutCancel(ctx), name); err != nil {
+			slog.Error("failed to delete partial fork", "fork", name, "err", err)
+		}
+		return pg.Repository{}, err
+	}
+
+	return s.metaStore.GetRepository(ctx, name)
+}
+
+func (s *Server) handleListForks(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.repositoryID(w, r)
+	if !ok {
+		return
+	}
+
+	forks, err := s.metaStore.ListForks(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to list forks", "id", id, "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	heads, err := s.metaStore.ListHeads(r.Context())
+	if err != nil {
+		slog.Error("failed to list repository heads", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]Repository, 0, len(forks))
+	for _, repo := range forks {
+		resp = append(resp, Repository{Repository: repo, DefaultBranch: defaultBranch(heads[repo.Name])})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-archive-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running archive smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	t.Log("Pushing content...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "dir"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "dir", "file.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "run.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.Symlink("dir/file.txt", filepath.Join(srcDir, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial commit")
+	runGit(t, srcDir, "tag", "-a", "v1.0", "-m", "Version 1.0")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main", "v1.0")
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			archivePath := filepath.Join(tmpDir, "archive."+format)
+			downloadFile(t, fmt.Sprintf("%s/repositories/%s/archive/v1.0.%s?prefix=project-1.0/", serverURL, repoName, format), archivePath)
+
+			outDir := filepath.Join(tmpDir, "out-"+format)
+			if err := os.MkdirAll(outDir, 0755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+			var cmd *exec.Cmd
+			if format == "zip" {
+				cmd = exec.Command("unzip", "-q", archivePath, "-d", outDir)
+			} else {
+				cmd = exec.Command("tar", "-xf", archivePath, "-C", outDir)
+			}
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("failed to extract archive: %v\nOutput: %s", err, out)
+			}
+
+			root := filepath.Join(outDir, "project-1.0")
+			assertFile(t, root, "dir/file.txt", "hello\n")
+
+			info, err := os.Stat(filepath.Join(root, "run.sh"))
+			if err != nil {
+				t.Fatalf("failed to stat run.sh: %v", err)
+			}
+			if info.Mode().Perm()&0100 == 0 {
+				t.Errorf("expected run.sh to be executable, got mode %v", info.Mode())
+			}
+
+			target, err := os.Readlink(filepath.Join(root, "link"))
+			if err != nil {
+				t.Fatalf("failed to read symlink: %v", err)
+			}
+			if target != "dir/file.txt" {
+				t.Errorf("expected link to point to dir/file.txt, got %q", target)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package smoke
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlameSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-blame-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running blame smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	t.Log("Pushing history...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	writeFile(t, srcDir, "config.yaml", "a: 1\nb: 2\nc: 3\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Add config")
+	first := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+
+	writeFile(t, srcDir, "config.yaml", "a: 1\nb: 20\nc: 3\n")
+	runGit(t, srcDir, "commit", "-am", "Change b")
+	second := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main")
+
+	resp, err := http.Get(fmt.Sprintf("%s/repositories/%s/blame/main/config.yaml", serverURL, repoName))
+	if err != nil {
+		t.Fatalf("failed to blame: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	var blame struct {
+		Ranges []struct {
+			StartLine int    `json:"start_line"`
+			EndLine   int    `json:"end_line"`
+			Commit    string `json:"commit"`
+		} `json:"ranges"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&blame); err != nil {
+		t.Fatalf("failed to decode blame: %v", err)
+	}
+
+	var got []string
+	for _, r := range blame.Ranges {
+		got = append(got, fmt.Sprintf("%d-%d %s", r.StartLine, r.EndLine, r.Commit))
+	}
+	want := []string{"1-1 " + first, "2-2 " + second, "3-3 " + first}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,146 @@
+package smoke
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	srcRepo := fmt.Sprintf("smoke-bundle-src-%d", rng.Int())
+	dstRepo := fmt.Sprintf("smoke-bundle-dst-%d", rng.Int())
+	t.Logf("Running bundle smoke test with repos: %s, %s", srcRepo, dstRepo)
+
+	createRepo(t, serverURL, srcRepo)
+	defer deleteRepo(t, serverURL, srcRepo)
+	createRepo(t, serverURL, dstRepo)
+	defer deleteRepo(t, serverURL, dstRepo)
+
+	tmpDir := t.TempDir()
+	srcURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, srcRepo)
+	dstURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, dstRepo)
+
+	t.Log("Pushing history...")
+	workDir := filepath.Join(tmpDir, "work")
+	runGit(t, tmpDir, "init", "-b", "main", workDir)
+	for i := 1; i <= 3; i++ {
+		file := filepath.Join(workDir, fmt.Sprintf("file-%d.txt", i))
+		if err := os.WriteFile(file, []byte(fmt.Sprintf("commit %d\n", i)), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		runGit(t, workDir, "add", ".")
+		runGit(t, workDir, "commit", "-m", fmt.Sprintf("Commit %d", i))
+	}
+	runGit(t, workDir, "tag", "-a", "v1", "-m", "Version 1")
+	runGit(t, workDir, "remote", "add", "origin", srcURL)
+	runGit(t, workDir, "push", "origin", "main", "v1")
+
+	t.Log("Downloading bundle...")
+	bundlePath := filepath.Join(tmpDir, "full.bundle")
+	downloadFile(t, fmt.Sprintf("%s/repositories/%s/bundle?version=3", serverURL, srcRepo), bundlePath)
+	runGit(t, workDir, "bundle", "verify", bundlePath)
+
+	cloneDir := filepath.Join(tmpDir, "from-bundle")
+	runGit(t, tmpDir, "clone", bundlePath, cloneDir)
+	assertHistory(t, cloneDir, 3)
+
+	t.Log("Importing bundle...")
+	status := importBundle(t, fmt.Sprintf("%s/repositories/%s/bundle", serverURL, dstRepo), bundlePath)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 importing bundle, got %d", status)
+	}
+
+	importedDir := filepath.Join(tmpDir, "imported")
+	runGit(t, tmpDir, "clone", dstURL, importedDir)
+	assertHistory(t, importedDir, 3)
+	for _, rev := range []string{"main", "v1"} {
+		want := runGitOutput(t, workDir, "rev-parse", rev)
+		got := runGitOutput(t, importedDir, "rev-parse", rev)
+		if string(got) != string(want) {
+			t.Errorf("expected %s at %s, got %s", rev, want, got)
+		}
+	}
+
+	t.Log("Importing incremental bundle...")
+	basis := strings.TrimSpace(string(runGitOutput(t, workDir, "rev-parse", "main")))
+	if err := os.WriteFile(filepath.Join(workDir, "file-4.txt"), []byte("commit 4\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	runGit(t, workDir, "add", ".")
+	runGit(t, workDir, "commit", "-m", "Commit 4")
+	runGit(t, workDir, "push", "origin", "main")
+
+	incrPath := filepath.Join(tmpDir, "incremental.bundle")
+	downloadFile(t, fmt.Sprintf("%s/repositories/%s/bundle?ref=main&basis=%s", serverURL, srcRepo, basis), incrPath)
+	status = importBundle(t, fmt.Sprintf("%s/repositories/%s/bundle", serverURL, dstRepo), incrPath)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 importing incremental bundle, got %d", status)
+	}
+
+	runGit(t, importedDir, "pull", "origin", "main")
+	assertHistory(t, importedDir, 4)
+
+	t.Log("Importing a stale bundle...")
+	status = importBundle(t, fmt.Sprintf("%s/repositories/%s/bundle", serverURL, dstRepo), bundlePath)
+	if status != http.StatusConflict {
+		t.Errorf("expected status 409 importing a stale bundle, got %d", status)
+	}
+}
+
+func downloadFile(t *testing.T, url, path string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		t.Fatalf("failed to download file: %v", err)
+	}
+}
+
+// importBundle uploads the bundle at path and returns the response status.
+func importBundle(t *testing.T, url, path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bundle file: %v", err)
+	}
+	defer f.Close()
+
+	resp, err := http.Post(url, "application/x-git-bundle", f)
+	if err != nil {
+		t.Fatalf("failed to import bundle: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]any
+	json.NewDecoder(resp.Body).Decode(&result)
+	t.Logf("Bundle import: status %d, %v", resp.StatusCode, result)
+
+	return resp.StatusCode
+}
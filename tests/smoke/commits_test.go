@@ -0,0 +1,93 @@
+package smoke
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommitsSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-commits-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running commits smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	apiURL := fmt.Sprintf("%s/repositories/%s/commits", serverURL, repoName)
+
+	// Push five commits, one day apart, alternating between two files.
+	t.Log("Pushing history...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		file := filepath.Join(srcDir, fmt.Sprintf("file-%d.txt", i%2))
+		if err := os.WriteFile(file, []byte(fmt.Sprintf("commit %d\n", i)), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		runGit(t, srcDir, "add", ".")
+		commitAt(t, srcDir, fmt.Sprintf("Commit %d", i), base.AddDate(0, 0, i))
+	}
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main")
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+		next  bool
+	}{
+		{"all", "", []string{"Commit 5", "Commit 4", "Commit 3", "Commit 2", "Commit 1"}, false},
+		{"path", "?path=file-1.txt", []string{"Commit 5", "Commit 3", "Commit 1"}, false},
+		{"since", "?since=2024-01-04", []string{"Commit 5", "Commit 4", "Commit 3"}, false},
+		{"until", "?ref=main&until=2024-01-03", []string{"Commit 1"}, false},
+		{"author", "?author=smoke@", []string{"Commit 5", "Commit 4", "Commit 3", "Commit 2", "Commit 1"}, false},
+		{"first page", "?per_page=2", []string{"Commit 5", "Commit 4"}, true},
+		{"last page", "?per_page=2&page=3", []string{"Commit 1"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(apiURL + tt.query)
+			if err != nil {
+				t.Fatalf("failed to list commits: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+
+			var commits []struct {
+				Message string `json:"message"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&commits); err != nil {
+				t.Fatalf("failed to decode commits: %v", err)
+			}
+
+			var got []string
+			for _, c := range commits {
+				got = append(got, strings.TrimSpace(c.Message))
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			if next := resp.Header.Get("Link") != ""; next != tt.next {
+				t.Errorf("expected next page %v, got Link %q", tt.next, resp.Header.Get("Link"))
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package smoke
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-compare-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running compare smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	t.Log("Pushing branches...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	var lines strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&lines, "line %d\n", i)
+	}
+	writeFile(t, srcDir, "old.txt", lines.String())
+	writeFile(t, srcDir, "keep.txt", "keep\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial commit")
+
+	runGit(t, srcDir, "checkout", "-b", "feature/compare")
+	runGit(t, srcDir, "mv", "old.txt", "new.txt")
+	writeFile(t, srcDir, "keep.txt", "keep\nchanged\n")
+	runGit(t, srcDir, "commit", "-am", "Rename and change")
+
+	runGit(t, srcDir, "checkout", "main")
+	writeFile(t, srcDir, "main.txt", "main\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Main only")
+
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main", "feature/compare")
+
+	resp, err := http.Get(fmt.Sprintf("%s/repositories/%s/compare/main...feature/compare", serverURL, repoName))
+	if err != nil {
+		t.Fatalf("failed to compare: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	var cmp struct {
+		MergeBase string `json:"merge_base"`
+		AheadBy   int    `json:"ahead_by"`
+		BehindBy  int    `json:"behind_by"`
+		Files     []struct {
+			Path       string `json:"path"`
+			OldPath    string `json:"old_path"`
+			Status     string `json:"status"`
+			Similarity int    `json:"similarity"`
+			Additions  int    `json:"additions"`
+			Patch      string `json:"patch"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cmp); err != nil {
+		t.Fatalf("failed to decode comparison: %v", err)
+	}
+
+	mergeBase := strings.TrimSpace(string(runGitOutput(t, srcDir, "merge-base", "main", "feature/compare")))
+	if cmp.MergeBase != mergeBase {
+		t.Errorf("expected merge base %s, got %s", mergeBase, cmp.MergeBase)
+	}
+	if cmp.AheadBy != 1 || cmp.BehindBy != 1 {
+		t.Errorf("expected 1 ahead and 1 behind, got %d and %d", cmp.AheadBy, cmp.BehindBy)
+	}
+	if len(cmp.Files) != 2 {
+		t.Fatalf("expected 2 changed files, got %+v", cmp.Files)
+	}
+	for _, f := range cmp.Files {
+		switch f.Path {
+		case "keep.txt":
+			if f.Status != "modified" || f.Additions != 1 || !strings.Contains(f.Patch, "+changed") {
+				t.Errorf("unexpected diff of keep.txt: %+v", f)
+			}
+		case "new.txt":
+			if f.Status != "renamed" || f.OldPath != "old.txt" || f.Similarity != 100 {
+				t.Errorf("unexpected diff of new.txt: %+v", f)
+			}
+		default:
+			t.Errorf("unexpected changed file: %+v", f)
+		}
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
@@ -0,0 +1,115 @@
+package smoke
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContentsSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-contents-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running contents smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	apiURL := fmt.Sprintf("%s/repositories/%s", serverURL, repoName)
+
+	t.Log("Pushing content...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "feature/contents", srcDir)
+	if err := os.MkdirAll(filepath.Join(srcDir, "config"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "config", "app.json"), []byte(`{"name": "app"}`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "README"), []byte("read me\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial commit")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "feature/contents")
+
+	t.Run("tree", func(t *testing.T) {
+		resp, err := http.Get(apiURL + "/tree/feature/contents")
+		if err != nil {
+			t.Fatalf("failed to list tree: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+
+		var entries []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+			Size *int64 `json:"size"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode tree: %v", err)
+		}
+		if len(entries) != 2 || entries[0].Name != "README" || entries[0].Type != "blob" ||
+			entries[0].Size == nil || *entries[0].Size != 8 || entries[1].Name != "config" || entries[1].Type != "tree" {
+			t.Errorf("unexpected tree: %+v", entries)
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		resp, err := http.Get(apiURL + "/blob/feature/contents/config/app.json")
+		if err != nil {
+			t.Fatalf("failed to read blob: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != `{"name": "app"}` {
+			t.Errorf("unexpected blob: status %d, body %q", resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected application/json, got %q", ct)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, apiURL+"/blob/feature/contents/README", nil)
+		req.Header.Set("Range", "bytes=5-")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to read blob: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "me\n" {
+			t.Errorf("unexpected range: status %d, body %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp, err := http.Get(apiURL + "/blob/feature/contents/missing")
+		if err != nil {
+			t.Fatalf("failed to read blob: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultBranchSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-default-branch-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running default branch smoke test with repo: %s", repoName)
+
+	apiURL := fmt.Sprintf("%s/repositories", serverURL)
+	status := sendJSON(t, http.MethodPost, apiURL, map[string]string{"name": repoName, "default_branch": "trunk"}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("failed to create repo: status %d", status)
+	}
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	t.Log("Pushing branches...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "develop", srcDir)
+	writeFile(t, srcDir, "file.txt", "content\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial commit")
+	runGit(t, srcDir, "branch", "trunk")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "develop", "trunk")
+
+	assertClonedBranch(t, tmpDir, repoURL, "trunk-clone", "trunk")
+
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	status = sendJSON(t, http.MethodPut, apiURL+"/"+repoName, map[string]string{"default_branch": "develop"}, &repo)
+	if status != http.StatusOK || repo.DefaultBranch != "develop" {
+		t.Fatalf("failed to change default branch: status %d, %+v", status, repo)
+	}
+
+	assertClonedBranch(t, tmpDir, repoURL, "develop-clone", "develop")
+}
+
+// assertClonedBranch clones url into dir and checks the branch checked out.
+func assertClonedBranch(t *testing.T, tmpDir, url, dir, branch string) {
+	cloneDir := filepath.Join(tmpDir, dir)
+	runGit(t, tmpDir, "clone", url, cloneDir)
+
+	out := runGitOutput(t, cloneDir, "symbolic-ref", "--short", "HEAD")
+	if got := strings.TrimSpace(string(out)); got != branch {
+		t.Errorf("expected %s to be checked out, got %s", branch, got)
+	}
+}
@@ -0,0 +1,137 @@
+package smoke
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileCommitsSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-file-commits-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running file commits smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	commitsURL := fmt.Sprintf("%s/repositories/%s/commits", serverURL, repoName)
+	author := map[string]string{"name": "Config Bot", "email": "bot@test.local"}
+	content := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	t.Log("Creating the first commit...")
+	var first struct {
+		Hash    string   `json:"hash"`
+		Parents []string `json:"parents"`
+	}
+	status := sendJSON(t, http.MethodPost, commitsURL, map[string]any{
+		"branch":  "main",
+		"message": "Add configuration",
+		"author":  author,
+		"actions": []map[string]any{
+			{"action": "create", "path": "config/app.yaml", "content": content("replicas: 1\n")},
+			{"action": "create", "path": "deploy.sh", "content": content("#!/bin/sh\n"), "executable": true},
+			{"action": "create", "path": "old.txt", "content": content("old\n")},
+		},
+	}, &first)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 creating a root commit, got %d", status)
+	}
+	if len(first.Parents) != 0 {
+		t.Errorf("expected a root commit, got parents %v", first.Parents)
+	}
+
+	t.Log("Updating, moving and deleting files...")
+	var second struct {
+		Hash    string   `json:"hash"`
+		Parents []string `json:"parents"`
+	}
+	status = sendJSON(t, http.MethodPost, commitsURL, map[string]any{
+		"branch":        "main",
+		"expected_head": first.Hash,
+		"message":       "Scale up",
+		"author":        author,
+		"actions": []map[string]any{
+			{"action": "update", "path": "config/app.yaml", "content": content("replicas: 3\n")},
+			{"action": "move", "previous_path": "deploy.sh", "path": "scripts/deploy.sh"},
+			{"action": "delete", "path": "old.txt"},
+		},
+	}, &second)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 updating files, got %d", status)
+	}
+	if len(second.Parents) != 1 || second.Parents[0] != first.Hash {
+		t.Errorf("expected parent %s, got %v", first.Hash, second.Parents)
+	}
+
+	status = sendJSON(t, http.MethodPost, commitsURL, map[string]any{
+		"branch":        "main",
+		"expected_head": first.Hash,
+		"message":       "Stale",
+		"author":        author,
+		"actions":       []map[string]any{{"action": "delete", "path": "config/app.yaml"}},
+	}, nil)
+	if status != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 with a stale expected_head, got %d", status)
+	}
+
+	status = sendJSON(t, http.MethodPost, commitsURL, map[string]any{
+		"branch":  "main",
+		"message": "Create again",
+		"author":  author,
+		"actions": []map[string]any{{"action": "create", "path": "config/app.yaml", "content": content("x\n")}},
+	}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 creating an existing file, got %d", status)
+	}
+
+	t.Log("Starting a branch from main...")
+	status = sendJSON(t, http.MethodPost, commitsURL, map[string]any{
+		"branch":      "feature",
+		"base_branch": "main",
+		"message":     "Add feature flag",
+		"author":      author,
+		"actions":     []map[string]any{{"action": "create", "path": "config/flags.yaml", "content": content("beta: true\n")}},
+	}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 creating a branch, got %d", status)
+	}
+
+	t.Log("Verifying the clone...")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", repoURL, cloneDir)
+	runGit(t, cloneDir, "fsck", "--strict")
+
+	head := strings.TrimSpace(string(runGitOutput(t, cloneDir, "rev-parse", "HEAD")))
+	if head != second.Hash {
+		t.Errorf("expected main at %s, got %s", second.Hash, head)
+	}
+	files := strings.Fields(string(runGitOutput(t, cloneDir, "ls-tree", "-r", "--name-only", "HEAD")))
+	if strings.Join(files, " ") != "config/app.yaml scripts/deploy.sh" {
+		t.Errorf("unexpected files: %v", files)
+	}
+	if out := string(runGitOutput(t, cloneDir, "ls-tree", "HEAD", "scripts/deploy.sh")); !strings.HasPrefix(out, "100755 ") {
+		t.Errorf("expected the moved script to stay executable, got %q", out)
+	}
+	if b, err := os.ReadFile(filepath.Join(cloneDir, "config", "app.yaml")); err != nil || string(b) != "replicas: 3\n" {
+		t.Errorf("unexpected config/app.yaml: %q, %v", b, err)
+	}
+
+	base := strings.TrimSpace(string(runGitOutput(t, cloneDir, "merge-base", "HEAD", "origin/feature")))
+	if base != second.Hash {
+		t.Errorf("expected feature to start from %s, got %s", second.Hash, base)
+	}
+}
@@ -0,0 +1,110 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForksSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	suffix := rand.New(rand.NewSource(time.Now().UnixNano())).Int()
+	repoName := fmt.Sprintf("smoke-forks-%d", suffix)
+	forkName := fmt.Sprintf("smoke-forks-%d-fork", suffix)
+	t.Logf("Running forks smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	forkURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, forkName)
+	forksURL := fmt.Sprintf("%s/repositories/%s/forks", serverURL, repoName)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "trunk", srcDir)
+	writeFile(t, srcDir, "file.txt", "content\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+	runGit(t, srcDir, "tag", "v1")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "trunk:trunk", "v1")
+	status := sendJSON(t, http.MethodPut, fmt.Sprintf("%s/repositories/%s", serverURL, repoName), map[string]any{"default_branch": "trunk"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 setting the default branch, got %d", status)
+	}
+
+	t.Log("Forking...")
+	var fork struct {
+		Name          string `json:"Name"`
+		ParentName    string `json:"ParentName"`
+		DefaultBranch string `json:"default_branch"`
+	}
+	if status := sendJSON(t, http.MethodPost, forksURL, map[string]any{"name": forkName}, &fork); status != http.StatusCreated {
+		t.Fatalf("expected status 201 forking, got %d", status)
+	}
+	defer deleteRepo(t, serverURL, forkName)
+	if fork.ParentName != repoName || fork.DefaultBranch != "trunk" {
+		t.Errorf("unexpected fork: %+v", fork)
+	}
+	if status := sendJSON(t, http.MethodPost, forksURL, map[string]any{"name": forkName}, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409 forking to an existing repository, got %d", status)
+	}
+
+	var forks []struct {
+		Name string `json:"Name"`
+	}
+	if status := sendJSON(t, http.MethodGet, forksURL, nil, &forks); status != http.StatusOK || len(forks) != 1 || forks[0].Name != forkName {
+		t.Errorf("unexpected forks: %+v (status %d)", forks, status)
+	}
+
+	t.Log("Cloning the fork...")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", forkURL, cloneDir)
+	runGit(t, cloneDir, "fsck", "--strict")
+	assertFile(t, cloneDir, "file.txt", "content\n")
+	if out := runGitOutput(t, cloneDir, "tag"); strings.TrimSpace(string(out)) != "v1" {
+		t.Errorf("expected tag v1 in the fork, got %q", out)
+	}
+
+	t.Log("Diverging...")
+	writeFile(t, cloneDir, "fork.txt", "fork\n")
+	runGit(t, cloneDir, "add", ".")
+	runGit(t, cloneDir, "commit", "-m", "Change the fork")
+	runGit(t, cloneDir, "push", "origin", "trunk")
+
+	writeFile(t, srcDir, "parent.txt", "parent\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Change the parent")
+	runGit(t, srcDir, "push", "origin", "trunk")
+
+	parentDir := filepath.Join(tmpDir, "parent")
+	runGit(t, tmpDir, "clone", repoURL, parentDir)
+	if _, err := os.Stat(filepath.Join(parentDir, "fork.txt")); err == nil {
+		t.Errorf("expected the parent not to see the changes of the fork")
+	}
+	forkDir := filepath.Join(tmpDir, "fork")
+	runGit(t, tmpDir, "clone", forkURL, forkDir)
+	runGit(t, forkDir, "fsck", "--strict")
+	assertFile(t, forkDir, "fork.txt", "fork\n")
+	if _, err := os.Stat(filepath.Join(forkDir, "parent.txt")); err == nil {
+		t.Errorf("expected the fork not to see the changes of the parent")
+	}
+
+	t.Log("Deleting...")
+	status = sendJSON(t, http.MethodDelete, fmt.Sprintf("%s/repositories/%s", serverURL, repoName), nil, nil)
+	if status != http.StatusConflict {
+		t.Errorf("expected status 409 deleting a repository with forks, got %d", status)
+	}
+}
@@ -0,0 +1,133 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	suffix := rand.New(rand.NewSource(time.Now().UnixNano())).Int()
+	srcName := fmt.Sprintf("smoke-import-src-%d", suffix)
+	repoName := fmt.Sprintf("smoke-import-%d", suffix)
+	t.Logf("Running import smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, srcName)
+	defer deleteRepo(t, serverURL, srcName)
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	srcURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, srcName)
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	importURL := fmt.Sprintf("%s/repositories/%s/import", serverURL, repoName)
+
+	t.Log("Pushing to the source repository...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "trunk", srcDir)
+	writeFile(t, srcDir, "file.txt", "one\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+	runGit(t, srcDir, "tag", "-a", "v1.0", "-m", "v1.0")
+	runGit(t, srcDir, "checkout", "-q", "-b", "feature")
+	writeFile(t, srcDir, "feature.txt", "feature\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Add feature.txt")
+	runGit(t, srcDir, "remote", "add", "origin", srcURL)
+	runGit(t, srcDir, "push", "origin", "trunk", "feature", "v1.0")
+	status := sendJSON(t, http.MethodPut, fmt.Sprintf("%s/repositories/%s", serverURL, srcName), map[string]any{"default_branch": "trunk"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 setting the default branch, got %d", status)
+	}
+
+	if status := sendJSON(t, http.MethodPost, importURL, map[string]any{"url": "relative/path"}, nil); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a relative path, got %d", status)
+	}
+
+	t.Log("Importing...")
+	job := runImport(t, importURL, srcURL, false)
+	if job.State != "succeeded" {
+		t.Fatalf("expected the import to succeed, got %+v", job)
+	}
+
+	var jobs []importJob
+	if status := sendJSON(t, http.MethodGet, importURL, nil, &jobs); status != http.StatusOK || len(jobs) != 1 {
+		t.Errorf("expected one import job, got %d with status %d", len(jobs), status)
+	}
+
+	t.Log("Cloning the imported repository...")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", repoURL, cloneDir)
+	for _, ref := range []string{"origin/trunk", "origin/feature", "v1.0"} {
+		want := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", strings.TrimPrefix(ref, "origin/"))))
+		if got := strings.TrimSpace(string(runGitOutput(t, cloneDir, "rev-parse", ref))); got != want {
+			t.Errorf("expected %s at %s, got %s", ref, want, got)
+		}
+	}
+	if branch := strings.TrimSpace(string(runGitOutput(t, cloneDir, "branch", "--show-current"))); branch != "trunk" {
+		t.Errorf("expected the default branch trunk, got %s", branch)
+	}
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if status := sendJSON(t, http.MethodGet, fmt.Sprintf("%s/repositories/%s", serverURL, repoName), nil, &repo); status != http.StatusOK || repo.DefaultBranch != "trunk" {
+		t.Errorf("expected the repaired default branch trunk, got %+v with status %d", repo, status)
+	}
+
+	t.Log("Importing diverged history...")
+	runGit(t, srcDir, "checkout", "-q", "trunk")
+	runGit(t, srcDir, "commit", "--amend", "-m", "Rewritten")
+	runGit(t, srcDir, "push", "--force", "origin", "trunk")
+
+	if job := runImport(t, importURL, srcURL, false); job.State != "failed" || !strings.Contains(job.Error, "refs/heads/trunk") {
+		t.Errorf("expected the import to reject trunk, got %+v", job)
+	}
+	if job := runImport(t, importURL, srcURL, true); job.State != "succeeded" {
+		t.Errorf("expected the forced import to succeed, got %+v", job)
+	}
+	runGit(t, cloneDir, "fetch", "origin")
+	want := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "trunk")))
+	if got := strings.TrimSpace(string(runGitOutput(t, cloneDir, "rev-parse", "origin/trunk"))); got != want {
+		t.Errorf("expected trunk at %s, got %s", want, got)
+	}
+}
+
+type importJob struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+	Error string `json:"error"`
+}
+
+// runImport starts an import of url and polls the job until it finishes.
+func runImport(t *testing.T, importURL, url string, force bool) importJob {
+	t.Helper()
+
+	var job importJob
+	if status := sendJSON(t, http.MethodPost, importURL, map[string]any{"url": url, "force": force}, &job); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 starting an import, got %d", status)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for job.State == "pending" || job.State == "running" {
+		if time.Now().After(deadline) {
+			t.Fatalf("import did not finish: %+v", job)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if status := sendJSON(t, http.MethodGet, fmt.Sprintf("%s/%d", importURL, job.ID), nil, &job); status != http.StatusOK {
+			t.Fatalf("expected status 200 getting the import job, got %d", status)
+		}
+	}
+	return job
+}
@@ -0,0 +1,174 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeRequestsSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-merge-requests-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running merge requests smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	mrsURL := fmt.Sprintf("%s/repositories/%s/merge-requests", serverURL, repoName)
+
+	t.Log("Pushing branches...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	writeFile(t, srcDir, "file.txt", "one\ntwo\nthree\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+	runGit(t, srcDir, "checkout", "-q", "-b", "feature")
+	writeFile(t, srcDir, "file.txt", "one\nTWO\nthree\nfour\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Change file.txt")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main", "feature")
+
+	type mergeRequest struct {
+		Number      int    `json:"number"`
+		State       string `json:"state"`
+		HeadCommit  string `json:"head_commit"`
+		MergeCommit string `json:"merge_commit"`
+		Approvals   []struct {
+			Approver string `json:"approver"`
+			Commit   string `json:"commit"`
+		} `json:"approvals"`
+	}
+
+	t.Log("Opening a merge request...")
+	var mr mergeRequest
+	status := sendJSON(t, http.MethodPost, mrsURL, map[string]any{
+		"title": "Add four", "author": "alice", "source_branch": "feature", "target_branch": "main",
+	}, &mr)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 opening a merge request, got %d", status)
+	}
+	if mr.Number != 1 || mr.State != "open" {
+		t.Fatalf("unexpected merge request: %+v", mr)
+	}
+	mrURL := fmt.Sprintf("%s/%d", mrsURL, mr.Number)
+
+	status = sendJSON(t, http.MethodPost, mrsURL, map[string]any{
+		"title": "Missing", "author": "alice", "source_branch": "missing", "target_branch": "main",
+	}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for a missing source branch, got %d", status)
+	}
+
+	var diff struct {
+		Files []struct {
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	if status := sendJSON(t, http.MethodGet, mrURL+"/diff", nil, &diff); status != http.StatusOK {
+		t.Fatalf("expected status 200 getting the diff, got %d", status)
+	}
+	if len(diff.Files) != 1 || diff.Files[0].Path != "file.txt" {
+		t.Errorf("unexpected diff: %+v", diff.Files)
+	}
+
+	t.Log("Reviewing...")
+	status = sendJSON(t, http.MethodPost, mrURL+"/comments", map[string]any{
+		"author": "bob", "body": "Why four?", "path": "file.txt", "line": 4,
+	}, nil)
+	if status != http.StatusCreated {
+		t.Errorf("expected status 201 commenting on a line, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPost, mrURL+"/comments", map[string]any{
+		"author": "bob", "body": "Out of range", "path": "file.txt", "line": 4, "side": "old",
+	}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 commenting past the end of the old file, got %d", status)
+	}
+	var comments []struct {
+		Line int `json:"line"`
+	}
+	if status := sendJSON(t, http.MethodGet, mrURL+"/comments", nil, &comments); status != http.StatusOK || len(comments) != 1 {
+		t.Errorf("expected one comment, got %d with status %d", len(comments), status)
+	}
+
+	if status := sendJSON(t, http.MethodPost, mrURL+"/approvals", map[string]any{"approver": "alice"}, nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 approving one's own merge request, got %d", status)
+	}
+	if status := sendJSON(t, http.MethodPost, mrURL+"/approvals", map[string]any{"approver": "bob"}, nil); status != http.StatusCreated {
+		t.Errorf("expected status 201 approving, got %d", status)
+	}
+
+	t.Log("Pushing to the source branch...")
+	writeFile(t, srcDir, "feature.txt", "feature\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Add feature.txt")
+	runGit(t, srcDir, "push", "origin", "feature")
+	head := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+
+	if status := sendJSON(t, http.MethodGet, mrURL, nil, &mr); status != http.StatusOK {
+		t.Fatalf("expected status 200 getting the merge request, got %d", status)
+	}
+	if mr.HeadCommit != head {
+		t.Errorf("expected head %s, got %s", head, mr.HeadCommit)
+	}
+	if len(mr.Approvals) != 1 || mr.Approvals[0].Commit == head {
+		t.Errorf("expected the approval to stay on the previous head: %+v", mr.Approvals)
+	}
+
+	status = sendJSON(t, http.MethodPost, fmt.Sprintf("%s/repositories/%s/refs", serverURL, repoName), map[string]any{
+		"name": "refs/merge-requests/2/head", "hash": head,
+	}, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("expected status 403 creating a hidden ref, got %d", status)
+	}
+
+	t.Log("Merging...")
+	status = sendJSON(t, http.MethodPost, mrURL+"/merge", map[string]any{
+		"committer": map[string]string{"name": "Bob", "email": "bob@test.local"}, "expected_head": head,
+	}, &mr)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 merging, got %d", status)
+	}
+	if mr.State != "merged" || mr.MergeCommit == "" {
+		t.Fatalf("unexpected merged merge request: %+v", mr)
+	}
+	if status := sendJSON(t, http.MethodPost, mrURL+"/merge", map[string]any{}, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409 merging again, got %d", status)
+	}
+	if status := sendJSON(t, http.MethodPatch, mrURL, map[string]any{"state": "open"}, nil); status != http.StatusConflict {
+		t.Errorf("expected status 409 reopening a merged merge request, got %d", status)
+	}
+
+	var open []mergeRequest
+	if status := sendJSON(t, http.MethodGet, mrsURL, nil, &open); status != http.StatusOK || len(open) != 0 {
+		t.Errorf("expected no open merge requests, got %d with status %d", len(open), status)
+	}
+
+	t.Log("Fetching the hidden head...")
+	runGit(t, srcDir, "push", "origin", "--delete", "feature")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", repoURL, cloneDir)
+	runGit(t, cloneDir, "fetch", "origin", "refs/merge-requests/1/head")
+	out := runGitOutput(t, cloneDir, "rev-parse", "FETCH_HEAD")
+	if got := strings.TrimSpace(string(out)); got != head {
+		t.Errorf("expected the hidden head %s, got %s", head, got)
+	}
+	out = runGitOutput(t, cloneDir, "rev-parse", "HEAD")
+	if got := strings.TrimSpace(string(out)); got != mr.MergeCommit {
+		t.Errorf("expected main at the merge commit %s, got %s", mr.MergeCommit, got)
+	}
+}
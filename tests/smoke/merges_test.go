@@ -0,0 +1,167 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergesSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-merges-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running merges smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	mergesURL := fmt.Sprintf("%s/repositories/%s/merges", serverURL, repoName)
+	cherryPicksURL := fmt.Sprintf("%s/repositories/%s/cherry-picks", serverURL, repoName)
+	committer := map[string]string{"name": "Merge Queue", "email": "queue@test.local"}
+
+	// Each branch changes a different line of file.txt, away from the
+	// others, except conflict, which changes the same line as top.
+	t.Log("Pushing branches...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	writeFile(t, srcDir, "file.txt", "one\ntwo\nthree\nfour\nfive\nsix\nseven\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+
+	branch := func(name, content, file, fileContent string) string {
+		runGit(t, srcDir, "checkout", "-q", "-b", name, "main")
+		writeFile(t, srcDir, "file.txt", content)
+		if file != "" {
+			writeFile(t, srcDir, file, fileContent)
+		}
+		runGit(t, srcDir, "add", ".")
+		runGit(t, srcDir, "commit", "-m", "Change "+name)
+		return strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+	}
+	branch("top", "ONE\ntwo\nthree\nfour\nfive\nsix\nseven\n", "", "")
+	branch("bottom", "one\ntwo\nthree\nfour\nFIVE\nsix\nseven\n", "bottom.txt", "bottom\n")
+	branch("conflict", "uno\ntwo\nthree\nfour\nfive\nsix\nseven\n", "", "")
+	branch("topic", "one\ntwo\nTHREE\nfour\nfive\nsix\nseven\n", "", "")
+	writeFile(t, srcDir, "topic.txt", "topic\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Add topic.txt")
+	pick := branch("pick", "one\ntwo\nthree\nfour\nfive\nsix\nSEVEN\n", "", "")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main", "top", "bottom", "conflict", "topic", "pick")
+
+	t.Log("Merging...")
+	var merged struct {
+		Hash    string   `json:"hash"`
+		Parents []string `json:"parents"`
+	}
+	status := sendJSON(t, http.MethodPost, mergesURL, map[string]any{
+		"base": "main", "head": "top", "committer": committer,
+	}, &merged)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 merging top, got %d", status)
+	}
+	if len(merged.Parents) != 2 {
+		t.Errorf("expected a merge commit, got parents %v", merged.Parents)
+	}
+
+	status = sendJSON(t, http.MethodPost, mergesURL, map[string]any{"base": "main", "head": "top", "committer": committer}, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("expected status 204 merging top again, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPost, mergesURL, map[string]any{"base": "main", "head": "bottom", "method": "fast-forward"}, nil)
+	if status != http.StatusConflict {
+		t.Errorf("expected status 409 fast-forwarding a diverged branch, got %d", status)
+	}
+
+	var squashed struct {
+		Parents []string `json:"parents"`
+	}
+	status = sendJSON(t, http.MethodPost, mergesURL, map[string]any{
+		"base": "main", "head": "bottom", "method": "squash", "committer": committer, "expected_head": merged.Hash,
+	}, &squashed)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 squashing bottom, got %d", status)
+	}
+	if len(squashed.Parents) != 1 || squashed.Parents[0] != merged.Hash {
+		t.Errorf("expected a single parent %s, got %v", merged.Hash, squashed.Parents)
+	}
+
+	t.Log("Checking conflicts...")
+	var conflict struct {
+		Conflicts []struct {
+			Path  string `json:"path"`
+			Type  string `json:"type"`
+			Hunks []struct {
+				Ours struct {
+					Start int `json:"start"`
+					Lines int `json:"lines"`
+				} `json:"ours"`
+			} `json:"hunks"`
+		} `json:"conflicts"`
+	}
+	status = sendJSON(t, http.MethodPost, mergesURL, map[string]any{"base": "main", "head": "conflict", "committer": committer}, &conflict)
+	if status != http.StatusConflict {
+		t.Fatalf("expected status 409 merging conflicting changes, got %d", status)
+	}
+	if len(conflict.Conflicts) != 1 || conflict.Conflicts[0].Path != "file.txt" || conflict.Conflicts[0].Type != "content" {
+		t.Fatalf("unexpected conflicts: %+v", conflict.Conflicts)
+	}
+	if hunks := conflict.Conflicts[0].Hunks; len(hunks) != 1 || hunks[0].Ours.Start != 1 || hunks[0].Ours.Lines != 1 {
+		t.Errorf("unexpected hunks: %+v", hunks)
+	}
+
+	t.Log("Rebasing and cherry-picking...")
+	status = sendJSON(t, http.MethodPost, mergesURL, map[string]any{"base": "main", "head": "topic", "method": "rebase", "committer": committer}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 rebasing topic, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPost, cherryPicksURL, map[string]any{"branch": "main", "commit": pick, "committer": committer}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 cherry-picking, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPost, cherryPicksURL, map[string]any{"branch": "main", "commit": pick, "committer": committer}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 cherry-picking the same change again, got %d", status)
+	}
+
+	t.Log("Verifying the clone...")
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", repoURL, cloneDir)
+	runGit(t, cloneDir, "fsck", "--strict")
+
+	content, err := os.ReadFile(filepath.Join(cloneDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read file.txt: %v", err)
+	}
+	if string(content) != "ONE\ntwo\nTHREE\nfour\nFIVE\nsix\nSEVEN\n" {
+		t.Errorf("unexpected merged content: %q", content)
+	}
+	for _, name := range []string{"bottom.txt", "topic.txt"} {
+		if _, err := os.Stat(filepath.Join(cloneDir, name)); err != nil {
+			t.Errorf("expected %s in the clone: %v", name, err)
+		}
+	}
+
+	// Squash, the two rebased commits and the cherry-pick are on top of
+	// the merge commit.
+	out := runGitOutput(t, cloneDir, "rev-list", "--count", merged.Hash+"..HEAD")
+	if got := strings.TrimSpace(string(out)); got != "4" {
+		t.Errorf("expected 4 commits after the merge, got %s", got)
+	}
+	out = runGitOutput(t, cloneDir, "log", "-1", "--format=%an", "HEAD~1")
+	if got := strings.TrimSpace(string(out)); got == "Merge Queue" {
+		t.Errorf("expected rebased commits to keep their author")
+	}
+}
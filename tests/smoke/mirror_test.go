@@ -0,0 +1,169 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPullMirrorSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	suffix := rand.New(rand.NewSource(time.Now().UnixNano())).Int()
+	upstreamName := fmt.Sprintf("smoke-mirror-upstream-%d", suffix)
+	repoName := fmt.Sprintf("smoke-mirror-%d", suffix)
+	t.Logf("Running pull mirror smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, upstreamName)
+	defer deleteRepo(t, serverURL, upstreamName)
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	upstreamURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, upstreamName)
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	mirrorURL := fmt.Sprintf("%s/repositories/%s/mirror", serverURL, repoName)
+
+	t.Log("Pushing to the upstream repository...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "trunk", srcDir)
+	writeFile(t, srcDir, "file.txt", "one\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+	runGit(t, srcDir, "tag", "v1.0")
+	runGit(t, srcDir, "branch", "feature")
+	runGit(t, srcDir, "remote", "add", "origin", upstreamURL)
+	runGit(t, srcDir, "push", "origin", "trunk", "feature", "v1.0")
+	status := sendJSON(t, http.MethodPut, fmt.Sprintf("%s/repositories/%s", serverURL, upstreamName), map[string]any{"default_branch": "trunk"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 setting the default branch, got %d", status)
+	}
+
+	type pullMirror struct {
+		URL           string `json:"url"`
+		LastSyncState string `json:"last_sync_state"`
+		LastError     string `json:"last_error"`
+	}
+
+	if status := sendJSON(t, http.MethodGet, mirrorURL, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404 before configuring the mirror, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPut, mirrorURL, map[string]any{"url": upstreamURL, "interval_seconds": 1}, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a short interval, got %d", status)
+	}
+
+	t.Log("Configuring the mirror...")
+	var mirror pullMirror
+	if status := sendJSON(t, http.MethodPut, mirrorURL, map[string]any{"url": upstreamURL}, &mirror); status != http.StatusOK {
+		t.Fatalf("expected status 200 configuring the mirror, got %d", status)
+	}
+
+	// The mirror is due right away, so the scheduler picks it up.
+	deadline := time.Now().Add(time.Minute)
+	for mirror.LastSyncState == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("mirror was not synchronised: %+v", mirror)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if status := sendJSON(t, http.MethodGet, mirrorURL, nil, &mirror); status != http.StatusOK {
+			t.Fatalf("expected status 200 getting the mirror, got %d", status)
+		}
+	}
+	if mirror.LastSyncState != "succeeded" {
+		t.Fatalf("expected the synchronisation to succeed, got %+v", mirror)
+	}
+
+	cloneDir := filepath.Join(tmpDir, "clone")
+	runGit(t, tmpDir, "clone", repoURL, cloneDir)
+	if branch := strings.TrimSpace(string(runGitOutput(t, cloneDir, "branch", "--show-current"))); branch != "trunk" {
+		t.Errorf("expected the default branch trunk, got %s", branch)
+	}
+	assertRemoteRefs(t, cloneDir, repoURL, "refs/heads/feature", "refs/heads/trunk", "refs/tags/v1.0")
+
+	t.Log("Pushing to the mirror...")
+	writeFile(t, cloneDir, "local.txt", "local\n")
+	runGit(t, cloneDir, "add", ".")
+	runGit(t, cloneDir, "commit", "-m", "Local change")
+	cmd := exec.Command("git", "push", "origin", "trunk")
+	cmd.Dir = cloneDir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "mirrored ref") {
+		t.Errorf("expected the push to a mirrored branch to be rejected, got err=%v\nOutput: %s", err, out)
+	}
+	status = sendJSON(t, http.MethodDelete, fmt.Sprintf("%s/repositories/%s/refs/heads/feature", serverURL, repoName), nil, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("expected status 403 deleting a mirrored branch, got %d", status)
+	}
+
+	t.Log("Synchronising on demand...")
+	writeFile(t, srcDir, "file.txt", "two\n")
+	runGit(t, srcDir, "commit", "-am", "Second")
+	runGit(t, srcDir, "push", "origin", "trunk", ":feature")
+	if status := sendJSON(t, http.MethodPost, mirrorURL+"/sync", nil, &mirror); status != http.StatusOK {
+		t.Fatalf("expected status 200 synchronising, got %d: %+v", status, mirror)
+	}
+	assertRemoteRefs(t, cloneDir, repoURL, "refs/heads/trunk", "refs/tags/v1.0")
+	runGit(t, cloneDir, "fetch", "origin")
+	want := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "trunk")))
+	if got := strings.TrimSpace(string(runGitOutput(t, cloneDir, "rev-parse", "origin/trunk"))); got != want {
+		t.Errorf("expected trunk at %s, got %s", want, got)
+	}
+
+	t.Log("Following the default branch of the upstream...")
+	runGit(t, srcDir, "push", "origin", "trunk:develop")
+	status = sendJSON(t, http.MethodPut, fmt.Sprintf("%s/repositories/%s", serverURL, upstreamName), map[string]any{"default_branch": "develop"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200 setting the default branch, got %d", status)
+	}
+	if status := sendJSON(t, http.MethodPost, mirrorURL+"/sync", nil, &mirror); status != http.StatusOK {
+		t.Fatalf("expected status 200 synchronising, got %d: %+v", status, mirror)
+	}
+	assertClonedBranch(t, tmpDir, repoURL, "develop-clone", "develop")
+
+	t.Log("Synchronising with a missing upstream...")
+	missingURL := fmt.Sprintf("%s/repositories/%s-missing.git", serverURL, upstreamName)
+	if status := sendJSON(t, http.MethodPut, mirrorURL, map[string]any{"url": missingURL}, nil); status != http.StatusOK {
+		t.Fatalf("expected status 200 changing the upstream, got %d", status)
+	}
+	if status := sendJSON(t, http.MethodPost, mirrorURL+"/sync", nil, &mirror); status != http.StatusBadGateway {
+		t.Errorf("expected status 502 synchronising with a missing upstream, got %d", status)
+	}
+	if mirror.LastSyncState != "failed" || mirror.LastError == "" {
+		t.Errorf("expected the synchronisation to fail, got %+v", mirror)
+	}
+
+	t.Log("Removing the mirror...")
+	if status := sendJSON(t, http.MethodDelete, mirrorURL, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("expected status 204 removing the mirror, got %d", status)
+	}
+	runGit(t, cloneDir, "push", "origin", "trunk:local")
+}
+
+// assertRemoteRefs checks the branches and tags of the repository at url.
+func assertRemoteRefs(t *testing.T, dir, url string, want ...string) {
+	t.Helper()
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(runGitOutput(t, dir, "ls-remote", "--heads", "--tags", url))), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			got = append(got, fields[1])
+		}
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("expected refs %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,102 @@
+package smoke
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartialCloneSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-partial-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running partial clone smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	// Push three commits, each with a small and a large file.
+	t.Log("Pushing history...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	for i := 1; i <= 3; i++ {
+		small := []byte(fmt.Sprintf("small %d\n", i))
+		large := bytes.Repeat([]byte(fmt.Sprintf("large %d\n", i)), 1024)
+		if err := os.WriteFile(filepath.Join(srcDir, "small.txt"), small, 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, "large.txt"), large, 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		runGit(t, srcDir, "add", ".")
+		runGit(t, srcDir, "commit", "-m", fmt.Sprintf("Commit %d", i))
+	}
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main")
+
+	t.Run("blob:none", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "blobless")
+		runGit(t, tmpDir, "clone", "--no-checkout", "--filter=blob:none", repoURL, dir)
+		assertMissing(t, dir, 6)
+
+		// Checking out fetches the blobs of HEAD lazily.
+		runGit(t, dir, "checkout", "main")
+		assertMissing(t, dir, 4)
+		assertFile(t, dir, "small.txt", "small 3\n")
+	})
+
+	t.Run("blob:limit", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "limit")
+		runGit(t, tmpDir, "clone", "--no-checkout", "--filter=blob:limit=1k", repoURL, dir)
+		assertMissing(t, dir, 3)
+	})
+
+	t.Run("tree:0", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "treeless")
+		runGit(t, tmpDir, "clone", "--no-checkout", "--filter=tree:0", repoURL, dir)
+		assertMissing(t, dir, 3)
+
+		runGit(t, dir, "checkout", "main")
+		assertFile(t, dir, "small.txt", "small 3\n")
+	})
+}
+
+// assertMissing checks the number of objects a partial clone knows about but
+// has not fetched.
+func assertMissing(t *testing.T, dir string, want int) {
+	out := runGitOutput(t, dir, "rev-list", "--objects", "--all", "--missing=print")
+
+	missing := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "?") {
+			missing++
+		}
+	}
+
+	if missing != want {
+		t.Errorf("expected %d missing objects, got %d", want, missing)
+	}
+}
+
+func assertFile(t *testing.T, dir, name, want string) {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	if string(content) != want {
+		t.Errorf("content mismatch in %s: expected %q, got %q", name, want, content)
+	}
+}
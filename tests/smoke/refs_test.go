@@ -0,0 +1,142 @@
+package smoke
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefsSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-refs-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running refs smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	refsURL := fmt.Sprintf("%s/repositories/%s/refs", serverURL, repoName)
+
+	t.Log("Pushing history...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	writeFile(t, srcDir, "file.txt", "one\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "First")
+	first := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+	writeFile(t, srcDir, "file.txt", "two\n")
+	runGit(t, srcDir, "commit", "-am", "Second")
+	second := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main")
+
+	status := sendJSON(t, http.MethodPost, refsURL, map[string]any{"name": "refs/heads/release", "hash": first}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 creating a branch, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPost, refsURL, map[string]any{"name": "refs/heads/release", "hash": first}, nil)
+	if status != http.StatusConflict {
+		t.Errorf("expected status 409 creating an existing branch, got %d", status)
+	}
+
+	status = sendJSON(t, http.MethodPatch, refsURL+"/heads/release", map[string]any{"hash": second, "old_hash": second}, nil)
+	if status != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 with a stale old_hash, got %d", status)
+	}
+	status = sendJSON(t, http.MethodPatch, refsURL+"/heads/release", map[string]any{"hash": second, "old_hash": first}, nil)
+	if status != http.StatusOK {
+		t.Errorf("expected status 200 moving a branch, got %d", status)
+	}
+
+	t.Log("Creating an annotated tag...")
+	status = sendJSON(t, http.MethodPost, refsURL, map[string]any{
+		"name": "refs/tags/v1.0",
+		"hash": first,
+		"tag": map[string]any{
+			"message": "Version 1.0",
+			"tagger":  map[string]string{"name": "Smoke Test", "email": "smoke@test.local"},
+		},
+	}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201 creating a tag, got %d", status)
+	}
+
+	var tag struct {
+		Hash   string `json:"hash"`
+		Peeled string `json:"peeled"`
+	}
+	sendJSON(t, http.MethodGet, refsURL+"/tags/v1.0", nil, &tag)
+	if tag.Peeled != first || tag.Hash == first {
+		t.Errorf("expected an annotated tag of %s, got %+v", first, tag)
+	}
+
+	runGit(t, srcDir, "fetch", "origin", "--tags")
+	if out := strings.TrimSpace(string(runGitOutput(t, srcDir, "cat-file", "-t", "v1.0"))); out != "tag" {
+		t.Errorf("expected v1.0 to be an annotated tag, got %q", out)
+	}
+	if out := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "origin/release"))); out != second {
+		t.Errorf("expected origin/release at %s, got %s", second, out)
+	}
+
+	var refs []struct {
+		Name string `json:"name"`
+	}
+	sendJSON(t, http.MethodGet, refsURL+"?prefix=refs/heads/", nil, &refs)
+	if len(refs) != 2 || refs[0].Name != "refs/heads/main" || refs[1].Name != "refs/heads/release" {
+		t.Errorf("unexpected branches: %+v", refs)
+	}
+
+	status = sendJSON(t, http.MethodDelete, refsURL+"/heads/release?old_hash="+second, nil, nil)
+	if status != http.StatusNoContent {
+		t.Errorf("expected status 204 deleting a branch, got %d", status)
+	}
+	status = sendJSON(t, http.MethodGet, refsURL+"/heads/release", nil, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("expected status 404 for a deleted branch, got %d", status)
+	}
+}
+
+// sendJSON sends body, if not nil, as JSON and decodes the response into out,
+// if not nil. It returns the response status.
+func sendJSON(t *testing.T, method, url string, body, out any) int {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("failed to decode response of %s %s: %v", method, url, err)
+		}
+	}
+
+	return resp.StatusCode
+}
@@ -0,0 +1,125 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShallowSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-shallow-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running shallow smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+
+	// Push ten commits, one day apart, and tag the fourth one.
+	t.Log("Pushing history...")
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 10; i++ {
+		file := filepath.Join(srcDir, fmt.Sprintf("file-%d.txt", i))
+		if err := os.WriteFile(file, []byte(fmt.Sprintf("commit %d\n", i)), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		runGit(t, srcDir, "add", ".")
+		commitAt(t, srcDir, fmt.Sprintf("Commit %d", i), base.AddDate(0, 0, i))
+		if i == 4 {
+			runGit(t, srcDir, "tag", "v1")
+		}
+	}
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main", "v1")
+
+	t.Run("depth", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "depth")
+		runGit(t, tmpDir, "clone", "--depth=1", repoURL, dir)
+		assertHistory(t, dir, 1)
+
+		runGit(t, dir, "fetch", "--depth=3")
+		assertHistory(t, dir, 3)
+	})
+
+	t.Run("shallow-since", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "since")
+		since := base.AddDate(0, 0, 8).Unix()
+		runGit(t, tmpDir, "clone", fmt.Sprintf("--shallow-since=%d", since), repoURL, dir)
+		assertHistory(t, dir, 3)
+	})
+
+	t.Run("shallow-exclude", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "exclude")
+		runGit(t, tmpDir, "clone", "--shallow-exclude=v1", repoURL, dir)
+		assertHistory(t, dir, 6)
+	})
+
+	t.Run("deepen", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "deepen")
+		runGit(t, tmpDir, "clone", "--depth=2", repoURL, dir)
+		assertHistory(t, dir, 2)
+
+		runGit(t, dir, "fetch", "--deepen=3")
+		assertHistory(t, dir, 5)
+	})
+
+	t.Run("unshallow", func(t *testing.T) {
+		dir := filepath.Join(tmpDir, "unshallow")
+		runGit(t, tmpDir, "clone", "--depth=2", repoURL, dir)
+
+		runGit(t, dir, "fetch", "--unshallow")
+		assertHistory(t, dir, 10)
+
+		out := runGitOutput(t, dir, "rev-parse", "--is-shallow-repository")
+		if strings.TrimSpace(string(out)) != "false" {
+			t.Errorf("expected a complete repository after --unshallow, got %q", out)
+		}
+	})
+}
+
+// commitAt commits the staged changes with both dates set to when.
+func commitAt(t *testing.T, dir, message string, when time.Time) {
+	date := when.Format(time.RFC3339)
+	cmd := exec.Command("git", "commit", "-m", message)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME=Smoke Test",
+		"GIT_AUTHOR_EMAIL=smoke@test.local",
+		"GIT_COMMITTER_NAME=Smoke Test",
+		"GIT_COMMITTER_EMAIL=smoke@test.local",
+		"GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_DATE="+date,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git commit failed: %v\nOutput: %s", err, out)
+	}
+}
+
+// assertHistory checks the number of commits reachable from HEAD and that the
+// clone is consistent.
+func assertHistory(t *testing.T, dir string, commits int) {
+	out := runGitOutput(t, dir, "rev-list", "--count", "HEAD")
+	if got := strings.TrimSpace(string(out)); got != fmt.Sprint(commits) {
+		t.Errorf("expected %d commits, got %s", commits, got)
+	}
+
+	runGit(t, dir, "fsck", "--no-dangling")
+}
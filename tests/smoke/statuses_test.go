@@ -0,0 +1,107 @@
+package smoke
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusesSmoke(t *testing.T) {
+	serverURL := os.Getenv("GIT_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+
+	waitForServer(t, serverURL)
+
+	repoName := fmt.Sprintf("smoke-statuses-%d", rand.New(rand.NewSource(time.Now().UnixNano())).Int())
+	t.Logf("Running statuses smoke test with repo: %s", repoName)
+
+	createRepo(t, serverURL, repoName)
+	defer deleteRepo(t, serverURL, repoName)
+
+	tmpDir := t.TempDir()
+	repoURL := fmt.Sprintf("%s/repositories/%s.git", serverURL, repoName)
+	statusesURL := fmt.Sprintf("%s/repositories/%s/statuses", serverURL, repoName)
+	statusURL := fmt.Sprintf("%s/repositories/%s/status", serverURL, repoName)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	runGit(t, tmpDir, "init", "-b", "main", srcDir)
+	writeFile(t, srcDir, "file.txt", "content\n")
+	runGit(t, srcDir, "add", ".")
+	runGit(t, srcDir, "commit", "-m", "Initial")
+	runGit(t, srcDir, "remote", "add", "origin", repoURL)
+	runGit(t, srcDir, "push", "origin", "main")
+	head := strings.TrimSpace(string(runGitOutput(t, srcDir, "rev-parse", "HEAD")))
+
+	type combined struct {
+		Commit   string `json:"commit"`
+		State    string `json:"state"`
+		Statuses []struct {
+			Context string `json:"context"`
+			State   string `json:"state"`
+		} `json:"statuses"`
+	}
+	var c combined
+	if status := sendJSON(t, http.MethodGet, statusURL+"/main", nil, &c); status != http.StatusOK {
+		t.Fatalf("expected status 200 getting the combined status, got %d", status)
+	}
+	if c.State != "pending" || len(c.Statuses) != 0 || c.Commit != head {
+		t.Errorf("unexpected combined status without statuses: %+v", c)
+	}
+
+	t.Log("Reporting statuses...")
+	report := func(state, context string) int {
+		return sendJSON(t, http.MethodPost, statusesURL+"/"+head, map[string]any{
+			"state": state, "context": context, "target_url": "https://ci.test.local/builds/1",
+		}, nil)
+	}
+	for _, s := range []struct{ state, context string }{
+		{"pending", "ci/build"},
+		{"success", "ci/build"},
+		{"pending", "ci/lint"},
+	} {
+		if status := report(s.state, s.context); status != http.StatusCreated {
+			t.Fatalf("expected status 201 reporting %s for %s, got %d", s.state, s.context, status)
+		}
+	}
+	if status := report("unknown", "ci/build"); status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid state, got %d", status)
+	}
+	status := sendJSON(t, http.MethodPost, statusesURL+"/"+strings.Repeat("0", 40), map[string]any{"state": "success"}, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for a missing commit, got %d", status)
+	}
+
+	if status := sendJSON(t, http.MethodGet, statusURL+"/main", nil, &c); status != http.StatusOK {
+		t.Fatalf("expected status 200 getting the combined status, got %d", status)
+	}
+	if c.State != "pending" || len(c.Statuses) != 2 || c.Statuses[0].State != "success" {
+		t.Errorf("unexpected combined status: %+v", c)
+	}
+
+	if status := report("failure", "ci/lint"); status != http.StatusCreated {
+		t.Fatalf("expected status 201 reporting a failure, got %d", status)
+	}
+	if status := sendJSON(t, http.MethodGet, statusURL+"/"+head, nil, &c); status != http.StatusOK || c.State != "failure" {
+		t.Errorf("expected a failed combined status, got %q with status %d", c.State, status)
+	}
+
+	var statuses []struct {
+		State string `json:"state"`
+	}
+	if status := sendJSON(t, http.MethodGet, statusesURL+"/main", nil, &statuses); status != http.StatusOK {
+		t.Fatalf("expected status 200 listing statuses, got %d", status)
+	}
+	if len(statuses) != 4 || statuses[0].State != "failure" {
+		t.Errorf("unexpected statuses: %+v", statuses)
+	}
+	if status := sendJSON(t, http.MethodGet, statusURL+"/missing", nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown revision, got %d", status)
+	}
+}